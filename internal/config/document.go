@@ -0,0 +1,560 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sourcehawk/go-flyway/internal/sops"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"gopkg.in/yaml.v3"
+)
+
+// Document is the configuration merged from one or more YAML files.
+//
+// Every node remembers the file it was read from, so that errors reported
+// at a configuration path can be traced back to a file, line and column
+type Document struct {
+	// The merged mapping at the root of the configuration
+	Root *yaml.Node
+	// source file of every node in the merged configuration
+	files map[*yaml.Node]string
+	// merge keys of the sequences in the configuration
+	keys MergeKeys
+	// the environments sections of the config files in order
+	environments []*yaml.Node
+	// absolute paths of the config files that have been loaded
+	loaded map[string]bool
+}
+
+// Key of the section of a config file that holds the overrides by environment name
+const EnvironmentsKey = "environments"
+
+// Key of the list of files and globs that a config file includes
+const IncludeKey = "include"
+
+// Directives that are set as YAML tags on values of later config files
+const (
+	// Removes the value, or the matching item of a sequence, e.g - !delete test_2
+	DeleteDirective = "!delete"
+	// Replaces the value, or the matching item of a sequence, instead of merging it
+	ReplaceDirective = "!replace"
+)
+
+// Returns the key that identifies an item of a sequence, so that the items of
+// later config files are merged into the item with the same key
+type MergeKey func(item *yaml.Node) (string, bool)
+
+// Merge keys by sequence path, where the items of sequences are denoted by [],
+// e.g schemas[].placeholders. Sequences without a merge key are replaced
+type MergeKeys map[string]MergeKey
+
+// Identifies mapping items by the value of the given field, e.g schemas by name.
+// A scalar item is taken to be the value of the field, e.g - !delete test_2
+func FieldMergeKey(field string) MergeKey {
+	return func(item *yaml.Node) (string, bool) {
+		switch item.Kind {
+		case yaml.ScalarNode:
+			return item.Value, item.Value != ""
+		case yaml.MappingNode:
+			if i := mappingIndex(item, field); i >= 0 && item.Content[i+1].Kind == yaml.ScalarNode {
+				return item.Content[i+1].Value, true
+			}
+		}
+		return "", false
+	}
+}
+
+// Identifies scalar items by the part before the separator, e.g flyway
+// arguments such as -key=value by their key
+func PrefixMergeKey(separator string) MergeKey {
+	return func(item *yaml.Node) (string, bool) {
+		if item.Kind != yaml.ScalarNode || item.Value == "" {
+			return "", false
+		}
+		key, _, _ := strings.Cut(item.Value, separator)
+		return key, true
+	}
+}
+
+// Reads and deep merges the given YAML files.
+//
+// Mappings are merged key by key. Items of sequences with a merge key are
+// merged into the item with the same key of earlier files or appended, other
+// sequences and scalar values are overridden by later files.
+// The !delete and !replace directives remove or replace values instead.
+//
+// The files included by a file are merged before the file itself. SOPS
+// encrypted files are decrypted when they are read
+func LoadDocument(paths []string, keys MergeKeys) (*Document, error) {
+	doc := &Document{
+		Root:   &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+		files:  make(map[*yaml.Node]string),
+		keys:   keys,
+		loaded: make(map[string]bool),
+	}
+
+	for _, path := range paths {
+		if err := doc.load(path, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// Reads the file and merges its includes and then the file into the document.
+// The chain holds the files that include the file, to detect include cycles
+func (d *Document) load(path string, chain []string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	d.loaded[abs] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file yaml.Node
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// an empty file holds no document
+	if len(file.Content) == 0 {
+		return nil
+	}
+
+	root := file.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d:%d: config must be a mapping", path, root.Line, root.Column)
+	}
+
+	// encrypted values are only ever decrypted in memory
+	if sops.IsEncrypted(root) {
+		if err := sops.Decrypt(root); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	d.track(root, path)
+
+	if i := mappingIndex(root, IncludeKey); i >= 0 {
+		includes := root.Content[i+1]
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+
+		chain = append(chain, abs)
+		if err := d.include(path, includes, chain); err != nil {
+			return err
+		}
+	}
+
+	// environments are merged on top of the configuration once selected
+	if i := mappingIndex(root, EnvironmentsKey); i >= 0 {
+		environments := root.Content[i+1]
+		if environments.Kind != yaml.MappingNode {
+			return d.errorAt(environments, "%s must be a mapping of environment names to overrides", EnvironmentsKey)
+		}
+		d.environments = append(d.environments, environments)
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+	}
+
+	return d.merge(d.Root, root, "")
+}
+
+// Loads the files matched by the include list of the file, in order. Relative
+// paths and globs are resolved against the directory of the including file
+func (d *Document) include(path string, includes *yaml.Node, chain []string) error {
+	if includes.Kind != yaml.SequenceNode {
+		return d.errorAt(includes, "%s must be a list of files", IncludeKey)
+	}
+
+	for _, item := range includes.Content {
+		if item.Kind != yaml.ScalarNode || item.Value == "" {
+			return d.errorAt(item, "%s items must be file paths or globs", IncludeKey)
+		}
+
+		pattern := item.Value
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return d.errorAt(item, "invalid %s pattern %s: %v", IncludeKey, item.Value, err)
+		}
+		if len(matches) == 0 {
+			return d.errorAt(item, "%s %s matches no files", IncludeKey, item.Value)
+		}
+
+		for _, match := range matches {
+			abs, err := filepath.Abs(match)
+			if err != nil {
+				return err
+			}
+			if i := slices.Index(chain, abs); i >= 0 {
+				cycle := append(slices.Clone(chain[i:]), abs)
+				return d.errorAt(item, "include cycle: %s", strings.Join(cycle, " -> "))
+			}
+			// a file included by several files is only merged the first time
+			if d.loaded[abs] {
+				continue
+			}
+			if err := d.load(match, chain); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the sorted names of the environments defined in the config files
+func (d *Document) Environments() []string {
+	names := []string{}
+	for _, environments := range d.environments {
+		for i := 0; i < len(environments.Content); i += 2 {
+			if name := environments.Content[i].Value; !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Merges the overrides of the named environment of every config file, in
+// order, on top of the configuration
+func (d *Document) SelectEnvironment(name string) error {
+	found := false
+
+	for _, environments := range d.environments {
+		i := mappingIndex(environments, name)
+		if i < 0 {
+			continue
+		}
+		found = true
+
+		overrides := environments.Content[i+1]
+		if overrides.Kind != yaml.MappingNode {
+			return d.errorAt(overrides, "%s.%s must be a mapping", EnvironmentsKey, name)
+		}
+		if err := d.merge(d.Root, overrides, ""); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		available := d.Environments()
+		if len(available) == 0 {
+			return fmt.Errorf("environment %s is not defined, the config does not define any environments", name)
+		}
+		return fmt.Errorf("environment %s is not defined, available environments are: %s", name, strings.Join(available, ", "))
+	}
+
+	return nil
+}
+
+func (d *Document) track(node *yaml.Node, file string) {
+	d.files[node] = file
+	for _, child := range node.Content {
+		d.track(child, file)
+	}
+}
+
+// Returns an error located at the node of a config file
+func (d *Document) errorAt(node *yaml.Node, format string, a ...any) error {
+	return fmt.Errorf("%s:%d:%d: %s", d.files[node], node.Line, node.Column, fmt.Sprintf(format, a...))
+}
+
+// Removes the directive of the node and returns it
+func takeDirective(node *yaml.Node) string {
+	directive := node.Tag
+	if directive != DeleteDirective && directive != ReplaceDirective {
+		return ""
+	}
+	node.Tag = ""
+	return directive
+}
+
+// Removes the directives from a value that does not merge into an earlier value
+func (d *Document) consume(node *yaml.Node, path string) error {
+	if takeDirective(node) == DeleteDirective {
+		return d.errorAt(node, "%s: nothing to delete, the value is not defined in an earlier config", path)
+	}
+	for _, child := range node.Content {
+		if err := d.consume(child, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merges the keys of the src mapping into the dst mapping
+func (d *Document) merge(dst *yaml.Node, src *yaml.Node, path string) error {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		keyPath := validation.JoinPath(path, key.Value)
+		directive := takeDirective(value)
+
+		existing := mappingIndex(dst, key.Value)
+
+		if directive == DeleteDirective {
+			if existing < 0 {
+				return d.errorAt(value, "%s: nothing to delete, the value is not defined in an earlier config", keyPath)
+			}
+			dst.Content = append(dst.Content[:existing], dst.Content[existing+2:]...)
+			continue
+		}
+
+		if existing < 0 {
+			if err := d.consume(value, keyPath); err != nil {
+				return err
+			}
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+
+		current := dst.Content[existing+1]
+		mergeKey, hasMergeKey := d.keys[keyPath]
+
+		switch {
+		case directive == ReplaceDirective:
+		case current.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			if err := d.merge(current, value, keyPath); err != nil {
+				return err
+			}
+			continue
+		case current.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode && hasMergeKey:
+			if err := d.mergeSequence(current, value, keyPath, mergeKey); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := d.consume(value, keyPath); err != nil {
+			return err
+		}
+		dst.Content[existing] = key
+		dst.Content[existing+1] = value
+	}
+
+	return nil
+}
+
+// Merges the items of the src sequence into the items of the dst sequence with the same key
+func (d *Document) mergeSequence(dst *yaml.Node, src *yaml.Node, path string, mergeKey MergeKey) error {
+	itemPath := path + "[]"
+
+	// only items of earlier files are merged into, items with the same key
+	// in a single file are kept apart
+	index := map[string]int{}
+	for i, item := range dst.Content {
+		if key, ok := mergeKey(item); ok {
+			if _, seen := index[key]; !seen {
+				index[key] = i
+			}
+		}
+	}
+
+	for _, item := range src.Content {
+		directive := takeDirective(item)
+		key, hasKey := mergeKey(item)
+		i, found := index[key]
+		found = found && hasKey
+
+		switch {
+		case directive == DeleteDirective:
+			if !found {
+				return d.errorAt(item, "%s: nothing to delete, no item with key %s is defined in an earlier config", path, key)
+			}
+			dst.Content[i] = nil
+			delete(index, key)
+		case found && directive != ReplaceDirective && dst.Content[i].Kind == yaml.MappingNode && item.Kind == yaml.MappingNode:
+			if err := d.merge(dst.Content[i], item, itemPath); err != nil {
+				return err
+			}
+		default:
+			if err := d.consume(item, itemPath); err != nil {
+				return err
+			}
+			if found {
+				dst.Content[i] = item
+			} else {
+				dst.Content = append(dst.Content, item)
+			}
+		}
+	}
+
+	// drop the deleted items
+	items := dst.Content[:0]
+	for _, item := range dst.Content {
+		if item != nil {
+			items = append(items, item)
+		}
+	}
+	dst.Content = items
+
+	return nil
+}
+
+// Returns the index of the key in the content of a mapping node, or -1
+func mappingIndex(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Decodes the merged configuration into v
+func (d *Document) Decode(v any) error {
+	return d.Root.Decode(v)
+}
+
+// Returns the file, line and column of the value at the given configuration
+// path, e.g schemas[3].credentials.aws_sm.port.
+//
+// Keys of mappings are located at the key. If the value does not exist, the
+// closest enclosing value that exists is located instead
+func (d *Document) Locate(path string) (string, int, int) {
+	node := d.Root
+	located := node
+
+	for _, segment := range splitPath(path) {
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+
+		switch {
+		case node.Kind == yaml.SequenceNode && strings.HasPrefix(segment, "["):
+			i, err := strconv.Atoi(strings.Trim(segment, "[]"))
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return d.position(located)
+			}
+			node = node.Content[i]
+			located = node
+		case node.Kind == yaml.MappingNode:
+			i := mappingIndex(node, segment)
+			if i < 0 {
+				return d.position(located)
+			}
+			located = node.Content[i]
+			node = node.Content[i+1]
+		default:
+			return d.position(located)
+		}
+	}
+
+	return d.position(located)
+}
+
+func (d *Document) position(node *yaml.Node) (string, int, int) {
+	return d.files[node], node.Line, node.Column
+}
+
+// Splits a configuration path into keys and indexes, e.g schemas[3].name
+// into schemas, [3] and name
+func splitPath(path string) []string {
+	segments := []string{}
+	for _, part := range strings.Split(path, ".") {
+		for part != "" {
+			i := strings.Index(part[1:], "[")
+			if i < 0 {
+				segments = append(segments, part)
+				break
+			}
+			segments = append(segments, part[:i+1])
+			part = part[i+1:]
+		}
+	}
+	return segments
+}
+
+// Makes the relative paths held by the fields at the given patterns absolute,
+// resolving them against the directory of the config file that defines them.
+//
+// In the patterns, [] stands for every item of a sequence and * for every value
+// of a mapping, e.g schemas[].placeholders[].valueFromFile. Values that still
+// contain unresolved references are left as is
+func (d *Document) ResolvePaths(patterns []string) error {
+	for _, pattern := range patterns {
+		for _, node := range matchPattern(d.Root, splitPath(pattern)) {
+			if node.Kind != yaml.ScalarNode || node.Value == "" || filepath.IsAbs(node.Value) || strings.Contains(node.Value, "${") {
+				continue
+			}
+			file, ok := d.files[node]
+			if !ok {
+				continue
+			}
+			dir, err := filepath.Abs(filepath.Dir(file))
+			if err != nil {
+				return err
+			}
+			node.Value = filepath.Join(dir, node.Value)
+		}
+	}
+	return nil
+}
+
+// Returns the nodes at the path segments, where [] matches every item of a
+// sequence and * every value of a mapping
+func matchPattern(node *yaml.Node, segments []string) []*yaml.Node {
+	if len(segments) == 0 {
+		return []*yaml.Node{node}
+	}
+
+	matches := []*yaml.Node{}
+	segment, rest := segments[0], segments[1:]
+
+	switch {
+	case segment == "[]" && node.Kind == yaml.SequenceNode:
+		for _, item := range node.Content {
+			matches = append(matches, matchPattern(item, rest)...)
+		}
+	case segment == "*" && node.Kind == yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			matches = append(matches, matchPattern(node.Content[i], rest)...)
+		}
+	case node.Kind == yaml.MappingNode:
+		if i := mappingIndex(node, segment); i >= 0 {
+			matches = append(matches, matchPattern(node.Content[i+1], rest)...)
+		}
+	}
+
+	return matches
+}
+
+// Prefixes every error at a configuration path with the file, line and
+// column of the value it refers to, e.g config.yml:12:7: schemas[0].name: ...
+func (d *Document) Annotate(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	errs := []error{}
+	for _, e := range validation.Errors(err) {
+		var fieldErr *validation.FieldError
+		if !errors.As(e, &fieldErr) || fieldErr.Path == "" {
+			errs = append(errs, e)
+			continue
+		}
+
+		file, line, column := d.Locate(fieldErr.Path)
+		if file == "" {
+			errs = append(errs, e)
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s:%d:%d: %w", file, line, column, e))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,460 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, name string, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func Test_LoadDocument_MergesFilesInOrder(t *testing.T) {
+	base := writeConfigFile(t, "base.yml", `
+credentials:
+  provider: text
+  text:
+    username: base
+    host: localhost
+flywayArgs:
+  - -a=1
+schemas:
+  - name: base
+`)
+	override := writeConfigFile(t, "override.yml", `
+credentials:
+  text:
+    username: override
+flywayArgs:
+  - -b=2
+`)
+
+	doc, err := LoadDocument([]string{base, override}, nil)
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	var decoded struct {
+		Credentials struct {
+			Provider string            `yaml:"provider"`
+			Text     map[string]string `yaml:"text"`
+		} `yaml:"credentials"`
+		FlywayArgs []string `yaml:"flywayArgs"`
+		Schemas    []struct {
+			Name string `yaml:"name"`
+		} `yaml:"schemas"`
+	}
+	assert.NoError(doc.Decode(&decoded))
+	assert.Equal("text", decoded.Credentials.Provider)
+	assert.Equal(map[string]string{"username": "override", "host": "localhost"}, decoded.Credentials.Text)
+	assert.Equal([]string{"-b=2"}, decoded.FlywayArgs)
+	assert.Equal("base", decoded.Schemas[0].Name)
+}
+
+func Test_LoadDocument_FailsOnInvalidFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := LoadDocument([]string{filepath.Join(t.TempDir(), "missing.yml")}, nil)
+	assert.Error(err)
+
+	list := writeConfigFile(t, "list.yml", "- a\n- b\n")
+	_, err = LoadDocument([]string{list}, nil)
+	assert.ErrorContains(err, list+":1:1: config must be a mapping")
+}
+
+func Test_Document_Locate_FindsValueInFileItCameFrom(t *testing.T) {
+	base := writeConfigFile(t, "base.yml", `schemas:
+  - name: a
+    migrationsPath: /a
+credentials:
+  provider: text
+`)
+	override := writeConfigFile(t, "override.yml", `credentials:
+  text:
+    port: notanumber
+`)
+
+	doc, err := LoadDocument([]string{base, override}, nil)
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	file, line, column := doc.Locate("schemas[0].migrationsPath")
+	assert.Equal(base, file)
+	assert.Equal(3, line)
+	assert.Equal(5, column)
+
+	file, line, column = doc.Locate("credentials.text.port")
+	assert.Equal(override, file)
+	assert.Equal(3, line)
+	assert.Equal(5, column)
+
+	// missing values are located at the closest value that exists
+	file, line, _ = doc.Locate("schemas[0].placeholders[2].name")
+	assert.Equal(base, file)
+	assert.Equal(2, line)
+
+	file, line, _ = doc.Locate("schemas[5]")
+	assert.Equal(base, file)
+	assert.Equal(1, line)
+}
+
+func Test_Document_Annotate_PrefixesSourceLocations(t *testing.T) {
+	path := writeConfigFile(t, "config.yml", `schemas:
+  - name: a
+`)
+	doc, err := LoadDocument([]string{path}, nil)
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	errs := validation.Errors(doc.Annotate(validation.AtPath("schemas[0]", validation.Errorf("name", "invalid"))))
+	assert.Len(errs, 1)
+	assert.EqualError(errs[0], path+":2:5: schemas[0].name: invalid")
+
+	assert.Nil(doc.Annotate(nil))
+}
+
+var testMergeKeys = MergeKeys{
+	"args":           PrefixMergeKey("="),
+	"items":          FieldMergeKey("name"),
+	"items[].nested": FieldMergeKey("name"),
+}
+
+type testMergedConfig struct {
+	Args  []string `yaml:"args"`
+	Items []struct {
+		Name   string              `yaml:"name"`
+		Value  string              `yaml:"value"`
+		Other  string              `yaml:"other"`
+		Nested []map[string]string `yaml:"nested"`
+	} `yaml:"items"`
+	Block map[string]string `yaml:"block"`
+	Gone  string            `yaml:"gone"`
+}
+
+func loadMerged(t *testing.T, files ...string) (*testMergedConfig, error) {
+	paths := []string{}
+	for i, data := range files {
+		paths = append(paths, writeConfigFile(t, fmt.Sprintf("config_%d.yml", i), data))
+	}
+	doc, err := LoadDocument(paths, testMergeKeys)
+	if err != nil {
+		return nil, err
+	}
+	merged := &testMergedConfig{}
+	return merged, doc.Decode(merged)
+}
+
+func Test_LoadDocument_MergesSequencesByKey(t *testing.T) {
+	merged, err := loadMerged(t, `
+args: [-a=1, -b=2]
+items:
+  - name: one
+    value: "1"
+    other: kept
+    nested:
+      - {name: x, value: "1"}
+  - name: two
+    value: "2"
+`, `
+args: [-b=3, -c=4]
+items:
+  - name: one
+    value: "11"
+    nested:
+      - {name: x, value: "11"}
+      - {name: y, value: "2"}
+  - name: three
+    value: "3"
+`)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"-a=1", "-b=3", "-c=4"}, merged.Args)
+	assert.Len(merged.Items, 3)
+	assert.Equal("one", merged.Items[0].Name)
+	assert.Equal("11", merged.Items[0].Value)
+	assert.Equal("kept", merged.Items[0].Other)
+	assert.Equal([]map[string]string{{"name": "x", "value": "11"}, {"name": "y", "value": "2"}}, merged.Items[0].Nested)
+	assert.Equal("two", merged.Items[1].Name)
+	assert.Equal("three", merged.Items[2].Name)
+}
+
+func Test_LoadDocument_AppliesDirectives(t *testing.T) {
+	merged, err := loadMerged(t, `
+args: [-a=1, -b=2]
+items:
+  - name: one
+    value: "1"
+    other: dropped
+  - name: two
+  - name: three
+block:
+  a: "1"
+  b: "2"
+gone: value
+`, `
+args:
+  - !delete -a
+items:
+  - !replace
+    name: one
+    value: "11"
+  - !delete two
+  - !delete {name: three}
+block: !replace
+  c: "3"
+gone: !delete
+`)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"-b=2"}, merged.Args)
+	assert.Len(merged.Items, 1)
+	assert.Equal("11", merged.Items[0].Value)
+	assert.Empty(merged.Items[0].Other)
+	assert.Equal(map[string]string{"c": "3"}, merged.Block)
+	assert.Empty(merged.Gone)
+}
+
+func Test_LoadDocument_ReplacesSequencesWithDirective(t *testing.T) {
+	merged, err := loadMerged(t, `
+items:
+  - name: one
+  - name: two
+`, `
+items: !replace
+  - name: three
+`)
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Len(merged.Items, 1)
+	assert.Equal("three", merged.Items[0].Name)
+}
+
+func Test_LoadDocument_FailsToDeleteUndefinedValues(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := loadMerged(t, `
+items:
+  - name: one
+`, `
+items:
+  - !delete two
+`)
+	assert.ErrorContains(err, "config_1.yml:3:5: items: nothing to delete, no item with key two is defined in an earlier config")
+
+	_, err = loadMerged(t, `
+gone: !delete
+`)
+	assert.ErrorContains(err, "config_0.yml:2:7: gone: nothing to delete")
+
+	_, err = loadMerged(t, `
+items:
+  - name: one
+`, `
+items: !replace
+  - !delete one
+`)
+	assert.ErrorContains(err, "nothing to delete")
+}
+
+func Test_Document_SelectEnvironment_MergesOverridesOfEveryFile(t *testing.T) {
+	base := writeConfigFile(t, "base.yml", `
+args: [-a=1]
+items:
+  - name: one
+    value: "1"
+  - name: two
+    value: "2"
+environments:
+  prod:
+    args: [-a=2]
+    items:
+      - name: one
+        value: "11"
+  dev:
+    items:
+      - !delete two
+`)
+	override := writeConfigFile(t, "override.yml", `
+items:
+  - name: three
+environments:
+  prod:
+    items:
+      - name: three
+        value: "33"
+`)
+
+	doc, err := LoadDocument([]string{base, override}, testMergeKeys)
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"dev", "prod"}, doc.Environments())
+	assert.NoError(doc.SelectEnvironment("prod"))
+
+	merged := &testMergedConfig{}
+	assert.NoError(doc.Decode(merged))
+	assert.Equal([]string{"-a=2"}, merged.Args)
+	assert.Len(merged.Items, 3)
+	assert.Equal("11", merged.Items[0].Value)
+	assert.Equal("2", merged.Items[1].Value)
+	assert.Equal("33", merged.Items[2].Value)
+
+	// the overrides are located in the file they are defined in
+	file, line, _ := doc.Locate("items[2].value")
+	assert.Equal(override, file)
+	assert.Equal(8, line)
+}
+
+func Test_Document_SelectEnvironment_IgnoresEnvironmentsUnlessSelected(t *testing.T) {
+	path := writeConfigFile(t, "config.yml", `
+args: [-a=1]
+environments:
+  prod:
+    args: [-a=2]
+`)
+
+	doc, err := LoadDocument([]string{path}, testMergeKeys)
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	merged := &testMergedConfig{}
+	assert.NoError(doc.Decode(merged))
+	assert.Equal([]string{"-a=1"}, merged.Args)
+}
+
+func Test_Document_SelectEnvironment_FailsOnUnknownEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "config.yml", `
+environments:
+  prod: {}
+  dev: {}
+`)
+	doc, err := LoadDocument([]string{path}, testMergeKeys)
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.EqualError(doc.SelectEnvironment("staging"), "environment staging is not defined, available environments are: dev, prod")
+
+	doc, err = LoadDocument([]string{writeConfigFile(t, "empty.yml", "args: []")}, testMergeKeys)
+	assert.NoError(err)
+	assert.ErrorContains(doc.SelectEnvironment("staging"), "does not define any environments")
+
+	_, err = LoadDocument([]string{writeConfigFile(t, "invalid.yml", "environments: [prod]")}, testMergeKeys)
+	assert.ErrorContains(err, "environments must be a mapping")
+}
+
+func Test_Document_ResolvePaths_ResolvesAgainstDefiningFile(t *testing.T) {
+	baseDir := t.TempDir()
+	overrideDir := t.TempDir()
+	base := filepath.Join(baseDir, "base.yml")
+	override := filepath.Join(overrideDir, "override.yml")
+	assert := assert.New(t)
+	assert.NoError(os.WriteFile(base, []byte(`
+items:
+  - name: one
+    value: ./one
+    nested:
+      - name: a
+        value: a.txt
+  - name: two
+    value: /absolute/two
+  - name: three
+    value: ${env:NOT_RESOLVED}/three
+block:
+  x: {value: x}
+`), 0600))
+	assert.NoError(os.WriteFile(override, []byte(`
+items:
+  - name: one
+    nested:
+      - name: b
+        value: ../b.txt
+`), 0600))
+
+	doc, err := LoadDocument([]string{base, override}, testMergeKeys)
+	assert.NoError(err)
+	assert.NoError(doc.ResolvePaths([]string{"items[].value", "items[].nested[].value", "block.*.value"}))
+
+	merged := &struct {
+		Items []struct {
+			Value  string              `yaml:"value"`
+			Nested []map[string]string `yaml:"nested"`
+		} `yaml:"items"`
+		Block map[string]map[string]string `yaml:"block"`
+	}{}
+	assert.NoError(doc.Decode(merged))
+	assert.Equal(filepath.Join(baseDir, "one"), merged.Items[0].Value)
+	assert.Equal(filepath.Join(baseDir, "a.txt"), merged.Items[0].Nested[0]["value"])
+	assert.Equal(filepath.Join(filepath.Dir(overrideDir), "b.txt"), merged.Items[0].Nested[1]["value"])
+	assert.Equal("/absolute/two", merged.Items[1].Value)
+	assert.Equal("${env:NOT_RESOLVED}/three", merged.Items[2].Value)
+	assert.Equal(filepath.Join(baseDir, "x"), merged.Block["x"]["value"])
+}
+
+func Test_LoadDocument_MergesIncludesBeforeIncludingFile(t *testing.T) {
+	dir := t.TempDir()
+	assert := assert.New(t)
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "shared", "args"), 0700))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "shared", "args", "a.yml"), []byte("args: [-a=1, -b=1]"), 0600))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "shared", "args", "b.yml"), []byte("args: [-b=2]"), 0600))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "shared", "items.yml"), []byte(`
+include: [args/*.yml]
+items:
+  - name: one
+    value: shared
+`), 0600))
+	config := filepath.Join(dir, "config.yml")
+	assert.NoError(os.WriteFile(config, []byte(`
+include:
+  - ./shared/items.yml
+  - shared/args/a.yml
+items:
+  - name: one
+    other: own
+`), 0600))
+
+	doc, err := LoadDocument([]string{config}, testMergeKeys)
+	assert.NoError(err)
+
+	merged := &testMergedConfig{}
+	assert.NoError(doc.Decode(merged))
+	// a.yml is not merged again after b.yml, since it was already included
+	assert.Equal([]string{"-a=1", "-b=2"}, merged.Args)
+	assert.Equal("shared", merged.Items[0].Value)
+	assert.Equal("own", merged.Items[0].Other)
+
+	file, line, _ := doc.Locate("items[0].value")
+	assert.Equal(filepath.Join(dir, "shared", "items.yml"), file)
+	assert.Equal(5, line)
+}
+
+func Test_LoadDocument_FailsOnIncludeCycles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.yml")
+	b := filepath.Join(dir, "b.yml")
+	assert := assert.New(t)
+	assert.NoError(os.WriteFile(a, []byte("include: [b.yml]"), 0600))
+	assert.NoError(os.WriteFile(b, []byte("args: []\ninclude: [a.yml]"), 0600))
+
+	_, err := LoadDocument([]string{a}, testMergeKeys)
+	assert.EqualError(err, fmt.Sprintf("%s:2:11: include cycle: %s -> %s -> %s", b, a, b, a))
+}
+
+func Test_LoadDocument_FailsOnInvalidIncludes(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := LoadDocument([]string{writeConfigFile(t, "missing.yml", "include: [nothing/*.yml]")}, testMergeKeys)
+	assert.ErrorContains(err, "include nothing/*.yml matches no files")
+
+	_, err = LoadDocument([]string{writeConfigFile(t, "mapping.yml", "include: {file: a.yml}")}, testMergeKeys)
+	assert.ErrorContains(err, "include must be a list of files")
+
+	_, err = LoadDocument([]string{writeConfigFile(t, "nested.yml", "include: [[a.yml]]")}, testMergeKeys)
+	assert.ErrorContains(err, "include items must be file paths or globs")
+}
@@ -0,0 +1,356 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"gopkg.in/yaml.v3"
+)
+
+type ReferenceType string
+
+const (
+	SecretReferenceType ReferenceType = "secret"
+	EnvReferenceType    ReferenceType = "env"
+	FileReferenceType   ReferenceType = "file"
+)
+
+// Matches the expressions that are expanded in config values:
+//   - references such as ${secret:aws_sm://name#key}, ${env:VAR} and ${file:/path}
+//   - shell style environment variables ${VAR}, ${VAR:-default} and ${VAR:?error}
+//   - $${, which escapes a literal ${, e.g $${placeholder} for a flyway placeholder
+//
+// Any other ${...} expression, e.g ${flyway:defaultSchema}, is left untouched
+var expressionPattern = regexp.MustCompile(`\$\$\{|\$\{(secret|env|file):([^}]*)\}|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:-|:\?)([^}]*))?\}`)
+
+// Escapes a literal ${ in config values, which expands to ${
+const EscapedExpression = "$${"
+
+var NewSecretsProvider = sp.NewSecretsProvider
+
+// ReferenceResolver expands secret, environment and file references in config values.
+//
+// Secrets are cached in the shared secret cache of the run, so a secret that is
+// referenced several times, or also used for credentials, is only fetched once
+type ReferenceResolver struct {
+	// cached secrets providers keyed by provider key of the secret references
+	providers map[string]sp.SecretsProvider
+	cache     *sp.SecretCache
+	// only check the references instead of resolving them
+	offline bool
+	// paths of the values that contain references not resolved in offline mode
+	unresolved map[string]bool
+}
+
+// Stand-in for values that consist of a single reference in offline mode.
+// It decodes both as a number and as a non-empty string
+const offlineStandIn = "1"
+
+func NewReferenceResolver() *ReferenceResolver {
+	return &ReferenceResolver{
+		providers: make(map[string]sp.SecretsProvider),
+		cache:     sp.DefaultSecretCache,
+	}
+}
+
+// Creates a resolver that checks the syntax of references without resolving them,
+// so that no secret store, environment variable or file is accessed.
+//
+// Values that consist of a single reference are replaced by a stand-in value
+// so that the config can still be decoded, values that embed a reference are
+// left as is. The paths of these values are returned by Unresolved
+func NewOfflineReferenceResolver() *ReferenceResolver {
+	return &ReferenceResolver{
+		offline:    true,
+		unresolved: make(map[string]bool),
+	}
+}
+
+// Returns the paths of the values, e.g schemas[0].migrationsPath, that contain
+// references which were not resolved because the resolver is offline
+func (r *ReferenceResolver) Unresolved() map[string]bool {
+	return r.unresolved
+}
+
+// Parses the body of a secret reference in the form
+// <provider>://<secretName>[?<option>=<value>&...][#<secretKey>]
+//
+// Supported options are the version and AWS client settings of a secret
+// reference, e.g versionStage, region or roleArn
+func ParseSecretReference(ref string) (*sp.SecretRef, error) {
+	provider, rest, ok := strings.Cut(ref, "://")
+	if !ok || provider == "" {
+		return nil, fmt.Errorf("secret reference '%s' must be in the form <provider>://<secretName>[#<secretKey>]", ref)
+	}
+
+	// the key is optional, without it the whole secret value is used
+	rest, key, hasKey := strings.Cut(rest, "#")
+	if hasKey && key == "" {
+		return nil, fmt.Errorf("secret reference '%s' has an empty secret key", ref)
+	}
+	name, query, _ := strings.Cut(rest, "?")
+
+	secretRef := &sp.SecretRef{
+		Provider:   provider,
+		SecretName: name,
+		SecretKey:  key,
+	}
+
+	options, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("secret reference '%s' has invalid options: %w", ref, err)
+	}
+
+	fields := map[string]*string{
+		"versionId":    &secretRef.VersionId,
+		"versionStage": &secretRef.VersionStage,
+		"region":       &secretRef.Region,
+		"profile":      &secretRef.Profile,
+		"roleArn":      &secretRef.RoleArn,
+		"externalId":   &secretRef.ExternalId,
+		"sessionName":  &secretRef.SessionName,
+		"endpointUrl":  &secretRef.EndpointUrl,
+		"encoding":     &secretRef.Encoding,
+	}
+
+	for option, values := range options {
+		field, ok := fields[option]
+		if !ok {
+			return nil, fmt.Errorf("secret reference '%s' has unknown option '%s'", ref, option)
+		}
+		*field = values[len(values)-1]
+	}
+
+	if err := secretRef.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid secret reference '%s': %w", ref, err)
+	}
+
+	return secretRef, nil
+}
+
+func (r *ReferenceResolver) resolveSecret(ref string) (string, error) {
+	secretRef, err := ParseSecretReference(ref)
+	if err != nil {
+		return "", err
+	}
+
+	key := secretRef.ProviderKey()
+	provider, ok := r.providers[key]
+	if !ok {
+		newProvider, err := NewSecretsProvider(secretRef)
+		if err != nil {
+			return "", err
+		}
+		provider = r.cache.Wrap(key, newProvider)
+		r.providers[key] = provider
+	}
+
+	secret, err := secretRef.Fetch(provider)
+	if err != nil {
+		return "", err
+	}
+
+	return secretRef.LookupString(secret)
+}
+
+// Checks the syntax of the reference without resolving it
+func (r *ReferenceResolver) check(refType ReferenceType, ref string) error {
+	switch refType {
+	case SecretReferenceType:
+		_, err := ParseSecretReference(ref)
+		return err
+	case EnvReferenceType:
+		if ref == "" {
+			return fmt.Errorf("missing environment variable name")
+		}
+		return nil
+	case FileReferenceType:
+		if ref == "" {
+			return fmt.Errorf("missing file path")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown reference type %s", refType)
+	}
+}
+
+func (r *ReferenceResolver) resolve(refType ReferenceType, ref string) (string, error) {
+	if r.offline {
+		return offlineStandIn, r.check(refType, ref)
+	}
+
+	switch refType {
+	case SecretReferenceType:
+		return r.resolveSecret(ref)
+	case EnvReferenceType:
+		value, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", ref)
+		}
+		return value, nil
+	case FileReferenceType:
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("could not read file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		return "", fmt.Errorf("unknown reference type %s", refType)
+	}
+}
+
+// Resolves a shell style environment variable expression. The operator is
+// :- to fall back to the argument, or :? to fail with the argument as message,
+// if the variable is not set or empty
+func (r *ReferenceResolver) resolveVariable(name string, operator string, arg string) (string, error) {
+	if r.offline {
+		return offlineStandIn, nil
+	}
+
+	value, ok := os.LookupEnv(name)
+
+	switch operator {
+	case ":-":
+		if value == "" {
+			return arg, nil
+		}
+	case ":?":
+		if value == "" {
+			if arg == "" {
+				return "", fmt.Errorf("environment variable %s is not set or empty", name)
+			}
+			return "", errors.New(arg)
+		}
+	default:
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set, write $${%s} for a literal ${%s}", name, name, name)
+		}
+	}
+
+	return value, nil
+}
+
+// Expands every reference and environment variable in the given string
+func (r *ReferenceResolver) ExpandString(s string) (string, error) {
+	var expandErr error
+
+	expanded := expressionPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if expandErr != nil {
+			return match
+		}
+		if match == EscapedExpression {
+			return "${"
+		}
+
+		var value string
+		var err error
+
+		groups := expressionPattern.FindStringSubmatch(match)
+		if groups[1] != "" {
+			value, err = r.resolve(ReferenceType(groups[1]), groups[2])
+		} else {
+			value, err = r.resolveVariable(groups[3], groups[4], groups[5])
+		}
+
+		if err != nil {
+			expandErr = fmt.Errorf("failed to resolve %s: %w", match, err)
+			return match
+		}
+		return value
+	})
+
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	return expanded, nil
+}
+
+// Expands the references in every scalar value of the given YAML node in place.
+// Map keys are never expanded. All values that cannot be expanded are reported
+func (r *ReferenceResolver) Expand(node *yaml.Node) error {
+	errs := []error{}
+	r.expandNode(node, "", &errs)
+	return errors.Join(errs...)
+}
+
+func (r *ReferenceResolver) expandNode(node *yaml.Node, path string, errs *[]error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			r.expandNode(child, path, errs)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			r.expandNode(node.Content[i+1], validation.JoinPath(path, node.Content[i].Value), errs)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			r.expandNode(item, validation.JoinPath(path, fmt.Sprintf("[%d]", i)), errs)
+		}
+	case yaml.ScalarNode:
+		if err := r.expandScalar(node, path); err != nil {
+			*errs = append(*errs, err)
+		}
+	}
+}
+
+func (r *ReferenceResolver) expandScalar(node *yaml.Node, path string) error {
+	value := node.Value
+	if !expressionPattern.MatchString(value) {
+		return nil
+	}
+
+	expanded, err := r.ExpandString(value)
+	if err != nil {
+		return validation.AtPath(path, err)
+	}
+
+	wholeValue := value != EscapedExpression && expressionPattern.FindString(value) == value
+	// values that only escape ${ are expanded without resolving anything
+	if r.offline && hasReference(value) {
+		r.unresolved[path] = true
+		if !wholeValue {
+			return nil
+		}
+	}
+
+	node.Value = expanded
+	node.Style = 0
+	node.Tag = "!!str"
+	if wholeValue {
+		// the value consists of a single reference, keep it untyped so that
+		// e.g a port resolved from a secret can still be decoded into an integer
+		node.Tag = untypedTag(expanded)
+	}
+	return nil
+}
+
+// Returns whether the value contains a reference or variable, rather than only escapes
+func hasReference(value string) bool {
+	for _, match := range expressionPattern.FindAllString(value, -1) {
+		if match != EscapedExpression {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns an empty tag, so that the value is decoded according to its content,
+// if it is a number or boolean, and the string tag otherwise
+func untypedTag(value string) string {
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return ""
+	}
+	if _, err := strconv.ParseBool(value); err == nil {
+		return ""
+	}
+	return "!!str"
+}
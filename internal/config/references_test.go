@@ -0,0 +1,376 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gopkg.in/yaml.v3"
+)
+
+type MockSecretsProvider struct {
+	mock.Mock
+}
+
+func (m *MockSecretsProvider) GetSecret(name string) (*sp.Secret, error) {
+	args := m.Called(name)
+	return args.Get(0).(*sp.Secret), args.Error(1)
+}
+
+func testResolver(secrets sp.SecretsProvider) *ReferenceResolver {
+	r := NewReferenceResolver()
+	r.cache = sp.NewSecretCache(0)
+	r.providers[string(sp.AWSSMSecretsProviderType)] = r.cache.Wrap(string(sp.AWSSMSecretsProviderType), secrets)
+	return r
+}
+
+func yamlNode(t *testing.T, data string) *yaml.Node {
+	var node yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(data), &node))
+	return &node
+}
+
+func Test_ParseSecretReference_Succeeds(t *testing.T) {
+	ref, err := ParseSecretReference("aws_sm://path/to/secret#key")
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(sp.SecretRef{Provider: "aws_sm", SecretName: "path/to/secret", SecretKey: "key"}, *ref)
+}
+
+func Test_ParseSecretReference_SucceedsWithoutSecretKey(t *testing.T) {
+	ref, err := ParseSecretReference("aws_sm://path/to/secret?encoding=base64")
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(sp.SecretRef{Provider: "aws_sm", SecretName: "path/to/secret", Encoding: "base64"}, *ref)
+}
+
+func Test_ReferenceResolver_ExpandString_ResolvesPlainAndNestedSecrets(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "password").Return(sp.NewSecret("plain-password"), nil)
+	secrets.On("GetSecret", "db").Return(sp.NewSecret(`{"primary": {"hosts": ["a", "b"]}}`), nil)
+	assert := assert.New(t)
+
+	r := testResolver(secrets)
+	v, err := r.ExpandString("${secret:aws_sm://password}@${secret:aws_sm://db#primary.hosts[1]}")
+	assert.NoError(err)
+	assert.Equal("plain-password@b", v)
+}
+
+func Test_ParseSecretReference_FailsOnInvalidReference(t *testing.T) {
+	assert := assert.New(t)
+	for _, ref := range []string{
+		"name#key",
+		"aws_sm://name#a[",
+		"://name#key",
+		"aws_sm://#key",
+		"aws_sm://name#",
+		"unknown://name#key",
+		"aws_sm://name?unknown=x#key",
+		"aws_sm://name?externalId=x#key",
+		"aws_sm://name?%zz#key",
+	} {
+		_, err := ParseSecretReference(ref)
+		assert.Error(err, ref)
+	}
+}
+
+func Test_ReferenceResolver_ExpandString_ExpandsAllReferenceTypes(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "db").Return(sp.NewSecret(`{"host": "dbhost"}`), nil)
+	t.Setenv("REFERENCE_TEST_PORT", "5432")
+	path := filepath.Join(t.TempDir(), "database")
+	assert := assert.New(t)
+	assert.NoError(os.WriteFile(path, []byte("postgres\n"), 0o600))
+
+	r := testResolver(secrets)
+	v, err := r.ExpandString(fmt.Sprintf("${secret:aws_sm://db#host}:${env:REFERENCE_TEST_PORT}/${file:%s}", path))
+	assert.NoError(err)
+	assert.Equal("dbhost:5432/postgres", v)
+}
+
+func Test_ReferenceResolver_ExpandString_LeavesOtherExpressionsUntouched(t *testing.T) {
+	r := testResolver(new(MockSecretsProvider))
+	v, err := r.ExpandString("${flyway:defaultSchema}.$${my_placeholder}.$${env:X}")
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("${flyway:defaultSchema}.${my_placeholder}.${env:X}", v)
+}
+
+func Test_ReferenceResolver_ExpandString_ExpandsEnvironmentVariables(t *testing.T) {
+	t.Setenv("REFERENCE_TEST_ROOT", "/migrations")
+	t.Setenv("REFERENCE_TEST_EMPTY", "")
+	r := testResolver(new(MockSecretsProvider))
+	assert := assert.New(t)
+
+	for value, expected := range map[string]string{
+		"${REFERENCE_TEST_ROOT}/billing":           "/migrations/billing",
+		"${REFERENCE_TEST_NOT_SET:-localhost}":     "localhost",
+		"${REFERENCE_TEST_EMPTY:-localhost}":       "localhost",
+		"${REFERENCE_TEST_ROOT:-localhost}":        "/migrations",
+		"${REFERENCE_TEST_ROOT:?root must be set}": "/migrations",
+		"${REFERENCE_TEST_EMPTY}":                  "",
+		"$${REFERENCE_TEST_ROOT}":                  "${REFERENCE_TEST_ROOT}",
+		"${REFERENCE_TEST_NOT_SET:-}":              "",
+		"${REFERENCE_TEST_NOT_SET:-a:b-c}":         "a:b-c",
+	} {
+		v, err := r.ExpandString(value)
+		assert.NoError(err, value)
+		assert.Equal(expected, v, value)
+	}
+}
+
+func Test_ReferenceResolver_ExpandString_FailsOnMissingEnvironmentVariables(t *testing.T) {
+	t.Setenv("REFERENCE_TEST_EMPTY", "")
+	r := testResolver(new(MockSecretsProvider))
+	assert := assert.New(t)
+
+	_, err := r.ExpandString("${REFERENCE_TEST_NOT_SET}")
+	assert.EqualError(err, "failed to resolve ${REFERENCE_TEST_NOT_SET}: environment variable REFERENCE_TEST_NOT_SET not set, write $${REFERENCE_TEST_NOT_SET} for a literal ${REFERENCE_TEST_NOT_SET}")
+
+	_, err = r.ExpandString("${REFERENCE_TEST_EMPTY:?root must be set}")
+	assert.EqualError(err, "failed to resolve ${REFERENCE_TEST_EMPTY:?root must be set}: root must be set")
+
+	_, err = r.ExpandString("${REFERENCE_TEST_NOT_SET:?}")
+	assert.EqualError(err, "failed to resolve ${REFERENCE_TEST_NOT_SET:?}: environment variable REFERENCE_TEST_NOT_SET is not set or empty")
+}
+
+func Test_ReferenceResolver_Expand_KeepsEnvironmentVariablesUntyped(t *testing.T) {
+	t.Setenv("REFERENCE_TEST_PORT", "5432")
+	config := yamlNode(t, `
+port: ${REFERENCE_TEST_PORT}
+host: ${REFERENCE_TEST_HOST:-localhost}
+placeholder: $${name}
+`)
+	assert := assert.New(t)
+	assert.NoError(testResolver(new(MockSecretsProvider)).Expand(config))
+
+	var decoded struct {
+		Port        int    `yaml:"port"`
+		Host        string `yaml:"host"`
+		Placeholder string `yaml:"placeholder"`
+	}
+	assert.NoError(config.Decode(&decoded))
+	assert.Equal(5432, decoded.Port)
+	assert.Equal("localhost", decoded.Host)
+	assert.Equal("${name}", decoded.Placeholder)
+}
+
+func Test_ReferenceResolver_ExpandString_FailsOnUnresolvableReference(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "db").Return((*sp.Secret)(nil), fmt.Errorf("test error 123"))
+	r := testResolver(secrets)
+	assert := assert.New(t)
+
+	for _, s := range []string{
+		"${secret:aws_sm://db#host}",
+		"${env:REFERENCE_TEST_NOT_SET}",
+		"${file:doesnotexistpath}",
+		"${secret:invalid}",
+	} {
+		_, err := r.ExpandString(s)
+		assert.Error(err, s)
+	}
+}
+
+func Test_ReferenceResolver_ExpandString_CachesSecrets(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "db").Return(sp.NewSecret(`{"user": "bob", "password": "pw"}`), nil)
+	r := testResolver(secrets)
+	assert := assert.New(t)
+
+	_, err := r.ExpandString("${secret:aws_sm://db#user}")
+	assert.NoError(err)
+	_, err = r.ExpandString("${secret:aws_sm://db#password}")
+	assert.NoError(err)
+	secrets.AssertNumberOfCalls(t, "GetSecret", 1)
+}
+
+func Test_ReferenceResolver_ExpandString_CreatesSecretsProviderOnce(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", mock.Anything).Return(sp.NewSecret(`{"key": "value"}`), nil)
+	calls := 0
+	NewSecretsProvider = func(ref *sp.SecretRef) (sp.SecretsProvider, error) {
+		calls++
+		return secrets, nil
+	}
+	defer func() { NewSecretsProvider = sp.NewSecretsProvider }()
+
+	r := NewReferenceResolver()
+	r.cache = sp.NewSecretCache(0)
+	assert := assert.New(t)
+	_, err := r.ExpandString("${secret:aws_sm://a#key} ${secret:aws_sm://b#key}")
+	assert.NoError(err)
+	assert.Equal(1, calls)
+	secrets.AssertNumberOfCalls(t, "GetSecret", 2)
+}
+
+func Test_ReferenceResolver_Expand_ExpandsNestedValuesKeepingTypes(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "db").Return(sp.NewSecret(`{"port": 5432, "password": "0123"}`), nil)
+	t.Setenv("REFERENCE_TEST_DIR", "/migrations")
+
+	data := `
+credentials:
+  provider: text
+  text:
+    password: ${secret:aws_sm://db#password}
+    port: ${secret:aws_sm://db#port}
+schemas:
+  - name: ${env:REFERENCE_TEST_DIR}
+    migrationsPath: ${env:REFERENCE_TEST_DIR}/schema
+    placeholders:
+      - name: p
+        value: ${flyway:defaultSchema}
+`
+	var config yaml.Node
+	assert := assert.New(t)
+	assert.NoError(yaml.Unmarshal([]byte(data), &config))
+	assert.NoError(testResolver(secrets).Expand(&config))
+
+	var decoded struct {
+		Credentials struct {
+			Text struct {
+				Password string `yaml:"password"`
+				Port     int    `yaml:"port"`
+			} `yaml:"text"`
+		} `yaml:"credentials"`
+		Schemas []struct {
+			Name           string `yaml:"name"`
+			MigrationsPath string `yaml:"migrationsPath"`
+			Placeholders   []struct {
+				Value string `yaml:"value"`
+			} `yaml:"placeholders"`
+		} `yaml:"schemas"`
+	}
+	assert.NoError(config.Decode(&decoded))
+	assert.Equal("0123", decoded.Credentials.Text.Password)
+	assert.Equal(5432, decoded.Credentials.Text.Port)
+	assert.Equal("/migrations", decoded.Schemas[0].Name)
+	assert.Equal("/migrations/schema", decoded.Schemas[0].MigrationsPath)
+	assert.Equal("${flyway:defaultSchema}", decoded.Schemas[0].Placeholders[0].Value)
+}
+
+func Test_ReferenceResolver_Expand_ReportsPathOfFailedValue(t *testing.T) {
+	config := yamlNode(t, `
+schemas:
+  - name: ${env:REFERENCE_TEST_NOT_SET}
+    migrationsPath: ${env:REFERENCE_TEST_NOT_SET_EITHER}
+`)
+	errs := validation.Errors(NewReferenceResolver().Expand(config))
+	assert := assert.New(t)
+	assert.Len(errs, 2)
+	assert.ErrorContains(errs[0], "schemas[0].name: ")
+	assert.ErrorContains(errs[1], "schemas[0].migrationsPath: ")
+}
+
+func Test_ParseSecretReference_ParsesOptions(t *testing.T) {
+	ref, err := ParseSecretReference("aws_sm://path/to/secret?versionStage=AWSPREVIOUS&region=eu-west-1&endpointUrl=http%3A%2F%2Flocalhost%3A4566#key")
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("path/to/secret", ref.SecretName)
+	assert.Equal("key", ref.SecretKey)
+	assert.Equal("AWSPREVIOUS", ref.VersionStage)
+	assert.Equal(sp.AWSClientConfig{Region: "eu-west-1", EndpointUrl: "http://localhost:4566"}, ref.AWSClientConfig)
+}
+
+func Test_ReferenceResolver_ExpandString_UsesProviderPerClientConfig(t *testing.T) {
+	current := new(MockSecretsProvider)
+	current.On("GetSecret", "db").Return(sp.NewSecret(`{"region": "default"}`), nil)
+	other := new(MockSecretsProvider)
+	other.On("GetSecret", "db").Return(sp.NewSecret(`{"region": "eu-west-1"}`), nil)
+	NewSecretsProvider = func(ref *sp.SecretRef) (sp.SecretsProvider, error) {
+		return other, nil
+	}
+	defer func() { NewSecretsProvider = sp.NewSecretsProvider }()
+
+	r := testResolver(current)
+	v, err := r.ExpandString("${secret:aws_sm://db#region} ${secret:aws_sm://db?region=eu-west-1#region}")
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("default eu-west-1", v)
+}
+
+func Test_OfflineReferenceResolver_Expand_ChecksReferencesWithoutResolving(t *testing.T) {
+	NewSecretsProvider = func(ref *sp.SecretRef) (sp.SecretsProvider, error) {
+		t.Fatal("secrets provider created by offline resolver")
+		return nil, nil
+	}
+	defer func() { NewSecretsProvider = sp.NewSecretsProvider }()
+
+	data := `
+credentials:
+  provider: text
+  text:
+    password: ${secret:aws_sm://db#password}
+    port: ${secret:aws_sm://db#port}
+schemas:
+  - name: schema
+    migrationsPath: ${env:REFERENCE_TEST_NOT_SET}/schema
+    placeholders:
+      - name: p
+        valueFromFile: ${file:/path/that/does/not/exist}
+`
+	var config yaml.Node
+	assert := assert.New(t)
+	assert.NoError(yaml.Unmarshal([]byte(data), &config))
+
+	r := NewOfflineReferenceResolver()
+	assert.NoError(r.Expand(&config))
+	assert.Equal(map[string]bool{
+		"credentials.text.password":                true,
+		"credentials.text.port":                    true,
+		"schemas[0].migrationsPath":                true,
+		"schemas[0].placeholders[0].valueFromFile": true,
+	}, r.Unresolved())
+
+	var decoded struct {
+		Credentials struct {
+			Text struct {
+				Password string `yaml:"password"`
+				Port     int    `yaml:"port"`
+			} `yaml:"text"`
+		} `yaml:"credentials"`
+		Schemas []struct {
+			MigrationsPath string `yaml:"migrationsPath"`
+		} `yaml:"schemas"`
+	}
+	assert.NoError(config.Decode(&decoded))
+	assert.NotEmpty(decoded.Credentials.Text.Password)
+	assert.NotZero(decoded.Credentials.Text.Port)
+	assert.Equal("${env:REFERENCE_TEST_NOT_SET}/schema", decoded.Schemas[0].MigrationsPath)
+}
+
+func Test_OfflineReferenceResolver_Expand_FailsOnInvalidReference(t *testing.T) {
+	assert := assert.New(t)
+	for _, value := range []string{
+		"${secret:unknown://db#password}",
+		"${secret:aws_sm://db?unknown=x}",
+		"prefix-${env:}",
+		"${file:}",
+	} {
+		node := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "key"},
+			{Kind: yaml.ScalarNode, Value: value},
+		}}
+		err := NewOfflineReferenceResolver().Expand(node)
+		assert.ErrorContains(err, "key: ", value)
+	}
+}
+
+func Test_OfflineReferenceResolver_Expand_ExpandsEscapesOnly(t *testing.T) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: "key"},
+		{Kind: yaml.ScalarNode, Value: "$${other}"},
+	}}
+
+	r := NewOfflineReferenceResolver()
+	assert := assert.New(t)
+	assert.NoError(r.Expand(node))
+	assert.Equal("${other}", node.Content[1].Value)
+	assert.Empty(r.Unresolved())
+}
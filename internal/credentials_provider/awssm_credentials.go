@@ -1,51 +1,112 @@
 package credentials_provider
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 
 	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
 )
 
-var NewAWSSecretsManager = sp.NewAWSSecretsManager
+var NewAWSSecretsManager = sp.SharedAWSSecretsManager
 
 type AWSSMDatabaseCredentials struct {
-	Username    *sp.SecretRef `yaml:"username,omitempty"`
-	Password    *sp.SecretRef `yaml:"password,omitempty"`
-	Host        *sp.SecretRef `yaml:"host,omitempty"`
-	Port        *sp.SecretRef `yaml:"port,omitempty"`
-	Database    *sp.SecretRef `yaml:"database,omitempty"`
-	awssm       sp.SecretsProvider
+	Username *sp.SecretRef `yaml:"username,omitempty"`
+	Password *sp.SecretRef `yaml:"password,omitempty"`
+	Host     *sp.SecretRef `yaml:"host,omitempty"`
+	Port     *sp.SecretRef `yaml:"port,omitempty"`
+	Database *sp.SecretRef `yaml:"database,omitempty"`
+	// Default version for all secret references that do not specify one
+	sp.SecretVersion `yaml:",inline"`
+	// Default AWS client settings for all secret references that do not specify them
+	sp.AWSClientConfig `yaml:",inline"`
+	// Version stages to try, in order, when the current credentials are rejected
+	// by the database, e.g AWSPENDING and AWSPREVIOUS during a rotation
+	FallbackVersionStages []string `yaml:"fallbackVersionStages,omitempty"`
+	// secrets providers keyed by provider key of the secret references
+	providers   map[string]sp.SecretsProvider
 	credentials *DatabaseCredentials
 }
 
-func (d *AWSSMDatabaseCredentials) Validate() error {
-	if d.Username == nil {
-		return fmt.Errorf("missing 'username' key in %s credentials", AWSSMProviderType)
+// A credentials field and the secret reference holding its value
+type secretField struct {
+	name string
+	ref  *sp.SecretRef
+}
+
+// Returns the secret references of every field as configured
+func (d *AWSSMDatabaseCredentials) fields() []secretField {
+	return []secretField{
+		{name: "username", ref: d.Username},
+		{name: "password", ref: d.Password},
+		{name: "host", ref: d.Host},
+		{name: "port", ref: d.Port},
+		{name: "database", ref: d.Database},
 	}
-	if d.Password == nil {
-		return fmt.Errorf("missing 'password' key in %s credentials", AWSSMProviderType)
+}
+
+// Returns the secret references of every field with the block defaults applied
+func (d *AWSSMDatabaseCredentials) secretRefs() []secretField {
+	refs := d.fields()
+	for i := range refs {
+		refs[i].ref = refs[i].ref.WithDefaults(d.SecretVersion, d.AWSClientConfig)
+	}
+	return refs
+}
+
+func (d *AWSSMDatabaseCredentials) ValidateConfig() error {
+	errs := []error{}
+
+	for _, s := range d.fields() {
+		if s.ref == nil {
+			errs = append(errs, validation.Errorf(s.name, "missing '%s' key in %s credentials", s.name, AWSSMProviderType))
+		}
 	}
-	if d.Host == nil {
-		return fmt.Errorf("missing 'host' key in %s credentials", AWSSMProviderType)
+	for i, stage := range d.FallbackVersionStages {
+		if stage == "" {
+			errs = append(errs, validation.Errorf(fmt.Sprintf("fallbackVersionStages[%d]", i), "empty version stage in %s credentials", AWSSMProviderType))
+		}
 	}
-	if d.Port == nil {
-		return fmt.Errorf("missing 'port' key in %s credentials", AWSSMProviderType)
+	if err := d.AWSClientConfig.Validate(); err != nil {
+		errs = append(errs, err)
 	}
-	if d.Database == nil {
-		return fmt.Errorf("missing 'database' key in %s credentials", AWSSMProviderType)
+	if len(errs) > 0 {
+		return errors.Join(errs...)
 	}
-	for _, s := range []*sp.SecretRef{d.Username, d.Password, d.Host, d.Port, d.Database} {
-		if err := s.Validate(); err != nil {
-			return err
+
+	// the references are validated with the block defaults applied
+	for _, s := range d.secretRefs() {
+		if err := s.ref.Validate(); err != nil {
+			errs = append(errs, validation.AtPath(s.name, err))
+			continue
+		}
+		if s.ref.ProviderType() != sp.AWSSMSecretsProviderType {
+			errs = append(errs, validation.Errorf(s.name+".provider", "secretRef '%s' in %s credentials cannot use provider %s", s.ref.SecretName, AWSSMProviderType, s.ref.Provider))
 		}
 	}
-	if d.awssm == nil {
-		awssm, err := NewAWSSecretsManager()
+	return errors.Join(errs...)
+}
+
+// Validates the configuration and creates a secrets manager client for each
+// distinct client configuration of the secret references
+func (d *AWSSMDatabaseCredentials) Validate() error {
+	if err := d.ValidateConfig(); err != nil {
+		return err
+	}
+	if d.providers == nil {
+		d.providers = make(map[string]sp.SecretsProvider)
+	}
+	for _, s := range d.secretRefs() {
+		key := s.ref.ProviderKey()
+		if _, ok := d.providers[key]; ok {
+			continue
+		}
+		awssm, err := NewAWSSecretsManager(s.ref.AWSClientConfig)
 		if err != nil {
 			return err
 		}
-		d.awssm = awssm
+		// share fetched secrets with every other user of the secret store during the run
+		d.providers[key] = sp.DefaultSecretCache.Wrap(key, awssm)
 	}
 	return nil
 }
@@ -59,51 +120,88 @@ func (d *AWSSMDatabaseCredentials) GetCredentials() (*DatabaseCredentials, error
 		return d.credentials, nil
 	}
 
-	secretsMap := make(map[string]map[string]any)
-	credentialsMap := make(map[string]any)
-
-	for _, s := range []sp.SecretRefToStructJsonField{
-		{
-			StructJsonField: "username",
-			SecretRef:       d.Username,
-		},
-		{
-			StructJsonField: "password",
-			SecretRef:       d.Password,
-		},
-		{
-			StructJsonField: "host",
-			SecretRef:       d.Host,
-		},
-		{
-			StructJsonField: "port",
-			SecretRef:       d.Port,
-		},
-		{
-			StructJsonField: "database",
-			SecretRef:       d.Database,
-		},
-	} {
-		if err := s.PopulateJSONFieldFromSecret(d.awssm, secretsMap, credentialsMap); err != nil {
+	credentials, err := d.resolve(d.secretRefs())
+	if err != nil {
+		return nil, err
+	}
+
+	d.credentials = credentials
+	return credentials, nil
+}
+
+// Fetches the secrets of the references and converts them to credentials
+func (d *AWSSMDatabaseCredentials) resolve(refs []secretField) (*DatabaseCredentials, error) {
+	credentials := &DatabaseCredentials{}
+	var errs []error
+
+	for _, s := range refs {
+		secret, err := s.ref.Fetch(d.providers[s.ref.ProviderKey()])
+		if err != nil {
 			return nil, err
 		}
+		switch s.name {
+		case "username":
+			credentials.Username, err = s.ref.LookupString(secret)
+		case "password":
+			credentials.Password, err = s.ref.LookupString(secret)
+		case "host":
+			credentials.Host, err = s.ref.LookupString(secret)
+		case "port":
+			credentials.Port, err = s.ref.LookupInt(secret)
+		case "database":
+			credentials.Database, err = s.ref.LookupString(secret)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s in %s credentials: %w", s.name, AWSSMProviderType, err))
+		}
 	}
 
-	// convert the map to json
-	jsonData, err := json.Marshal(credentialsMap)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal credentials to json: %w", err)
+// Drops the cached credentials and secrets so that they are fetched again
+func (d *AWSSMDatabaseCredentials) Invalidate() {
+	d.credentials = nil
+	// the providers are only created once the references are validated
+	if d.providers == nil {
+		return
+	}
+	for _, s := range d.secretRefs() {
+		sp.InvalidateSecret(d.providers[s.ref.ProviderKey()], s.ref.SecretName)
 	}
+}
 
-	// convert the json back, this time using a go struct for correct types
-	credentials := &DatabaseCredentials{}
-	err = json.Unmarshal(jsonData, credentials)
+// Returns the credentials stored in each of the fallback version stages.
+//
+// Stages that do not exist, e.g AWSPENDING outside of a rotation, are skipped
+func (d *AWSSMDatabaseCredentials) FallbackCredentials() ([]*DatabaseCredentials, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal credentials to json: %w", err)
+	fallbacks := []*DatabaseCredentials{}
+	errs := []error{}
+
+	for _, stage := range d.FallbackVersionStages {
+		refs := d.secretRefs()
+		for i := range refs {
+			ref := *refs[i].ref
+			ref.SecretVersion = sp.SecretVersion{VersionStage: stage}
+			refs[i].ref = &ref
+		}
+		credentials, err := d.resolve(refs)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("version stage %s: %w", stage, err))
+			continue
+		}
+		fallbacks = append(fallbacks, credentials)
 	}
 
-	d.credentials = credentials
-	return credentials, nil
+	if len(fallbacks) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return fallbacks, nil
 }
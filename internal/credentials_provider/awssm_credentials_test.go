@@ -1,32 +1,37 @@
 package credentials_provider
 
 import (
-	"encoding/json"
 	"fmt"
 	"testing"
 
 	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gopkg.in/yaml.v3"
 )
 
 type MockSecretsProvider struct {
 	mock.Mock
 }
 
-func (m *MockSecretsProvider) GetSecret(name string) (map[string]any, error) {
+func (m *MockSecretsProvider) GetSecret(name string) (*sp.Secret, error) {
 	args := m.Called(name)
-	return args.Get(0).(map[string]any), args.Error(1)
+	return args.Get(0).(*sp.Secret), args.Error(1)
+}
+
+func testProviders(provider sp.SecretsProvider) map[string]sp.SecretsProvider {
+	return map[string]sp.SecretsProvider{string(sp.AWSSMSecretsProviderType): provider}
 }
 
 func validAWSSMDatabaseCredentials() *AWSSMDatabaseCredentials {
 	return &AWSSMDatabaseCredentials{
-		Username: &sp.SecretRef{SecretName: "a", SecretKey: "b"},
-		Password: &sp.SecretRef{SecretName: "a", SecretKey: "b"},
-		Host:     &sp.SecretRef{SecretName: "a", SecretKey: "b"},
-		Port:     &sp.SecretRef{SecretName: "a", SecretKey: "b"},
-		Database: &sp.SecretRef{SecretName: "a", SecretKey: "b"},
-		awssm:    new(MockSecretsProvider),
+		Username:  &sp.SecretRef{SecretName: "a", SecretKey: "b"},
+		Password:  &sp.SecretRef{SecretName: "a", SecretKey: "b"},
+		Host:      &sp.SecretRef{SecretName: "a", SecretKey: "b"},
+		Port:      &sp.SecretRef{SecretName: "a", SecretKey: "b"},
+		Database:  &sp.SecretRef{SecretName: "a", SecretKey: "b"},
+		providers: testProviders(new(MockSecretsProvider)),
 	}
 }
 
@@ -57,10 +62,10 @@ func Test_AWSSMDatabaseCredentials_Validate_FailsWhenFieldNil(t *testing.T) {
 
 func Test_AWSSMDatabaseCredentials_Validate_LoadsAWSProvider(t *testing.T) {
 	c := validAWSSMDatabaseCredentials()
-	c.awssm = nil
+	c.providers = nil
 	assert := assert.New(t)
 	calls := 0
-	NewAWSSecretsManager = func() (*sp.AWSSecretsManager, error) {
+	NewAWSSecretsManager = func(sp.AWSClientConfig) (*sp.AWSSecretsManager, error) {
 		calls++
 		return &sp.AWSSecretsManager{}, nil
 	}
@@ -70,10 +75,10 @@ func Test_AWSSMDatabaseCredentials_Validate_LoadsAWSProvider(t *testing.T) {
 
 func Test_AWSSMDatabaseCredentials_Validate_FailsWhenAWSProviderLoadFails(t *testing.T) {
 	c := validAWSSMDatabaseCredentials()
-	c.awssm = nil
+	c.providers = nil
 	assert := assert.New(t)
 	calls := 0
-	NewAWSSecretsManager = func() (*sp.AWSSecretsManager, error) {
+	NewAWSSecretsManager = func(sp.AWSClientConfig) (*sp.AWSSecretsManager, error) {
 		calls++
 		return nil, fmt.Errorf("error")
 	}
@@ -83,22 +88,22 @@ func Test_AWSSMDatabaseCredentials_Validate_FailsWhenAWSProviderLoadFails(t *tes
 
 func Test_AWSSMDatabaseCredentials_GetCredentials_Succeeds(t *testing.T) {
 	awssm := new(MockSecretsProvider)
-	fakeSecret := map[string]any{
+	fakeSecret := sp.NewSecret(`{
 		"usernamey": "bob",
 		"passwordy": "supersecret",
 		"hosty":     "localhost",
 		"porty":     5432,
-		"databasey": "postgres",
-	}
+		"databasey": "postgres"
+	}`)
 	awssm.On("GetSecret", "a").Return(fakeSecret, nil)
 
 	c := &AWSSMDatabaseCredentials{
-		Username: &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
-		Password: &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
-		Host:     &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
-		Port:     &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
-		Database: &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
-		awssm:    awssm,
+		Username:  &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
+		Password:  &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
+		Host:      &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
+		Port:      &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
+		Database:  &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
+		providers: testProviders(awssm),
 	}
 
 	creds, err := c.GetCredentials()
@@ -113,22 +118,22 @@ func Test_AWSSMDatabaseCredentials_GetCredentials_Succeeds(t *testing.T) {
 
 func Test_AWSSMDatabaseCredentials_GetCredentials_CachesCredentials(t *testing.T) {
 	awssm := new(MockSecretsProvider)
-	fakeSecret := map[string]any{
+	fakeSecret := sp.NewSecret(`{
 		"usernamey": "bob",
 		"passwordy": "supersecret",
 		"hosty":     "localhost",
 		"porty":     5432,
-		"databasey": "postgres",
-	}
+		"databasey": "postgres"
+	}`)
 	awssm.On("GetSecret", "a").Return(fakeSecret, nil)
 
 	c := &AWSSMDatabaseCredentials{
-		Username: &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
-		Password: &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
-		Host:     &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
-		Port:     &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
-		Database: &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
-		awssm:    awssm,
+		Username:  &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
+		Password:  &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
+		Host:      &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
+		Port:      &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
+		Database:  &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
+		providers: testProviders(awssm),
 	}
 
 	creds, err := c.GetCredentials()
@@ -139,72 +144,111 @@ func Test_AWSSMDatabaseCredentials_GetCredentials_CachesCredentials(t *testing.T
 	assert.Same(creds, creds2, "Returns same object on second call")
 }
 
-type TestNotJSONSerializable struct {
-	Ch chan struct{}
-}
-
-func Test_AWSSMDatabaseCredentials_GetCredentials_FailsWhenSecretValueNotJsonSerializable(t *testing.T) {
+func Test_AWSSMDatabaseCredentials_GetCredentials_FailsWhenSecretValueNotScalar(t *testing.T) {
 	awssm := new(MockSecretsProvider)
-	fakeSecret := map[string]any{
-		"usernamey": TestNotJSONSerializable{},
+	fakeSecret := sp.NewSecret(`{
+		"usernamey": {"name": "bob"},
 		"passwordy": "supersecret",
 		"hosty":     "localhost",
 		"porty":     5432,
-		"databasey": "postgres",
-	}
+		"databasey": "postgres"
+	}`)
 	awssm.On("GetSecret", "a").Return(fakeSecret, nil)
 
 	c := &AWSSMDatabaseCredentials{
-		Username: &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
-		Password: &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
-		Host:     &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
-		Port:     &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
-		Database: &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
-		awssm:    awssm,
+		Username:  &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
+		Password:  &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
+		Host:      &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
+		Port:      &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
+		Database:  &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
+		providers: testProviders(awssm),
 	}
 
 	_, err := c.GetCredentials()
 	assert := assert.New(t)
-	var targetErr *json.UnsupportedTypeError
-	assert.Error(err)
-	assert.ErrorAs(err, &targetErr)
+	assert.EqualError(err, "invalid username in aws_sm credentials: key 'usernamey' in secret a: cannot use object as a string")
 }
 
-func Test_AWSSMDatabaseCredentials_GetCredentials_FailsWhenJsonNotParsableToStruct(t *testing.T) {
+func Test_AWSSMDatabaseCredentials_GetCredentials_CoercesValuesToFieldTypes(t *testing.T) {
 	awssm := new(MockSecretsProvider)
-	fakeSecret := map[string]any{
+	fakeSecret := sp.NewSecret(`{
 		"usernamey": 10,
 		"passwordy": "supersecret",
 		"hosty":     "localhost",
-		"porty":     5432,
-		"databasey": "postgres",
+		"porty":     "5432",
+		"databasey": "postgres"
+	}`)
+	awssm.On("GetSecret", "a").Return(fakeSecret, nil)
+
+	c := &AWSSMDatabaseCredentials{
+		Username:  &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
+		Password:  &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
+		Host:      &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
+		Port:      &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
+		Database:  &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
+		providers: testProviders(awssm),
 	}
+
+	creds, err := c.GetCredentials()
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("10", creds.Username)
+	assert.Equal(5432, creds.Port)
+}
+
+func Test_AWSSMDatabaseCredentials_GetCredentials_FailsWhenPortNotNumeric(t *testing.T) {
+	awssm := new(MockSecretsProvider)
+	fakeSecret := sp.NewSecret(`{
+		"usernamey": "bob",
+		"passwordy": "supersecret",
+		"hosty":     "localhost",
+		"porty":     "fivefourthreetwo",
+		"databasey": "postgres"
+	}`)
 	awssm.On("GetSecret", "a").Return(fakeSecret, nil)
 
 	c := &AWSSMDatabaseCredentials{
-		Username: &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
-		Password: &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
-		Host:     &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
-		Port:     &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
-		Database: &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
-		awssm:    awssm,
+		Username:  &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
+		Password:  &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
+		Host:      &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
+		Port:      &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
+		Database:  &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
+		providers: testProviders(awssm),
 	}
 
 	_, err := c.GetCredentials()
 	assert := assert.New(t)
-	var targetErr *json.UnmarshalTypeError
-	assert.Error(err)
-	assert.ErrorAs(err, &targetErr)
+	assert.EqualError(err, "invalid port in aws_sm credentials: key 'porty' in secret a: value is not an integer")
+}
+
+func Test_AWSSMDatabaseCredentials_GetCredentials_ReadsPlainAndNestedSecrets(t *testing.T) {
+	awssm := new(MockSecretsProvider)
+	awssm.On("GetSecret", "password").Return(sp.NewSecret("plain-password"), nil)
+	awssm.On("GetSecret", "db").Return(sp.NewSecret(`{"primary": {"host": "localhost", "port": 5432}, "users": [{"name": "bob"}], "name": "postgres"}`), nil)
+
+	c := &AWSSMDatabaseCredentials{
+		Username:  &sp.SecretRef{SecretName: "db", SecretKey: "users[0].name"},
+		Password:  &sp.SecretRef{SecretName: "password"},
+		Host:      &sp.SecretRef{SecretName: "db", SecretKey: "primary.host"},
+		Port:      &sp.SecretRef{SecretName: "db", SecretKey: "$.primary.port"},
+		Database:  &sp.SecretRef{SecretName: "db", SecretKey: "name"},
+		providers: testProviders(awssm),
+	}
+
+	creds, err := c.GetCredentials()
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(&DatabaseCredentials{Username: "bob", Password: "plain-password", Host: "localhost", Port: 5432, Database: "postgres"}, creds)
 }
 
 func Test_AWSSMDatabaseCredentials_GetCredentials_FailsWhenValidationError(t *testing.T) {
 	c := &AWSSMDatabaseCredentials{
-		Username: &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
-		Password: &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
-		Host:     &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
-		Port:     &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
-		Database: nil,
-		awssm:    new(MockSecretsProvider),
+		Username:  &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
+		Password:  &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
+		Host:      &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
+		Port:      &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
+		Database:  nil,
+		providers: testProviders(new(MockSecretsProvider)),
 	}
 	_, err := c.GetCredentials()
 	assert := assert.New(t)
@@ -215,19 +259,278 @@ func Test_AWSSMDatabaseCredentials_GetCredentials_FailsOnSecretsProviderError(t
 	awssm := new(MockSecretsProvider)
 
 	c := &AWSSMDatabaseCredentials{
-		Username: &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
-		Password: &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
-		Host:     &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
-		Port:     &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
-		Database: &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
-		awssm:    awssm,
+		Username:  &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
+		Password:  &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
+		Host:      &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
+		Port:      &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
+		Database:  &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
+		providers: testProviders(awssm),
 	}
 
-	fakeSecret := make(map[string]any)
-	awssm.On("GetSecret", mock.Anything).Return(fakeSecret, fmt.Errorf("test error 123"))
+	awssm.On("GetSecret", mock.Anything).Return((*sp.Secret)(nil), fmt.Errorf("test error 123"))
 
 	_, err := c.GetCredentials()
 	awssm.AssertCalled(t, "GetSecret", "a")
 	assert := assert.New(t)
 	assert.Error(err)
 }
+
+func Test_AWSSMDatabaseCredentials_GetCredentials_SharesSecretsAcrossInstances(t *testing.T) {
+	awssm := new(MockSecretsProvider)
+	fakeSecret := sp.NewSecret(`{
+		"usernamey": "bob",
+		"passwordy": "supersecret",
+		"hosty":     "localhost",
+		"porty":     5432,
+		"databasey": "postgres"
+	}`)
+	awssm.On("GetSecret", "a").Return(fakeSecret, nil)
+	cached := sp.NewSecretCache(0).Wrap(string(sp.AWSSMSecretsProviderType), awssm)
+
+	assert := assert.New(t)
+	for i := 0; i < 3; i++ {
+		c := &AWSSMDatabaseCredentials{
+			Username:  &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
+			Password:  &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
+			Host:      &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
+			Port:      &sp.SecretRef{SecretName: "a", SecretKey: "porty"},
+			Database:  &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
+			providers: testProviders(cached),
+		}
+		_, err := c.GetCredentials()
+		assert.NoError(err)
+	}
+	awssm.AssertNumberOfCalls(t, "GetSecret", 1)
+}
+
+type MockVersionedSecretsProvider struct {
+	MockSecretsProvider
+}
+
+func (m *MockVersionedSecretsProvider) GetSecretVersion(name string, version sp.SecretVersion) (*sp.Secret, error) {
+	args := m.Called(name, version)
+	return args.Get(0).(*sp.Secret), args.Error(1)
+}
+
+func Test_AWSSMDatabaseCredentials_Validate_CreatesOneClientPerConfig(t *testing.T) {
+	c := validAWSSMDatabaseCredentials()
+	c.providers = nil
+	c.Region = "eu-west-1"
+	c.Password.AWSClientConfig = sp.AWSClientConfig{Region: "us-east-1", RoleArn: "arn:aws:iam::123456789012:role/x"}
+	c.Host.AWSClientConfig = sp.AWSClientConfig{Region: "us-east-1", RoleArn: "arn:aws:iam::123456789012:role/x"}
+
+	configs := []sp.AWSClientConfig{}
+	NewAWSSecretsManager = func(cfg sp.AWSClientConfig) (*sp.AWSSecretsManager, error) {
+		configs = append(configs, cfg)
+		return &sp.AWSSecretsManager{}, nil
+	}
+	defer func() { NewAWSSecretsManager = sp.SharedAWSSecretsManager }()
+
+	assert := assert.New(t)
+	assert.NoError(c.Validate())
+	assert.ElementsMatch([]sp.AWSClientConfig{
+		{Region: "eu-west-1"},
+		{Region: "us-east-1", RoleArn: "arn:aws:iam::123456789012:role/x"},
+	}, configs)
+}
+
+func Test_AWSSMDatabaseCredentials_Validate_FailsWithInvalidClientConfig(t *testing.T) {
+	c := validAWSSMDatabaseCredentials()
+	c.ExternalId = "ext"
+	assert := assert.New(t)
+	assert.Error(c.Validate())
+}
+
+func Test_AWSSMDatabaseCredentials_GetCredentials_FetchesConfiguredVersions(t *testing.T) {
+	awssm := new(MockVersionedSecretsProvider)
+	previous := sp.NewSecret(`{
+		"usernamey": "bob",
+		"passwordy": "oldsecret",
+		"hosty":     "localhost",
+		"porty":     5432,
+		"databasey": "postgres"
+	}`)
+	awssm.On("GetSecretVersion", "a", sp.SecretVersion{VersionStage: "AWSPREVIOUS"}).Return(previous, nil)
+
+	c := &AWSSMDatabaseCredentials{
+		Username:      &sp.SecretRef{SecretName: "a", SecretKey: "usernamey"},
+		Password:      &sp.SecretRef{SecretName: "a", SecretKey: "passwordy"},
+		Host:          &sp.SecretRef{SecretName: "a", SecretKey: "hosty"},
+		Port:          &sp.SecretRef{SecretName: "b", SecretKey: "porty", SecretVersion: sp.SecretVersion{VersionStage: "AWSCURRENT"}},
+		Database:      &sp.SecretRef{SecretName: "a", SecretKey: "databasey"},
+		SecretVersion: sp.SecretVersion{VersionStage: "AWSPREVIOUS"},
+		providers:     map[string]sp.SecretsProvider{"aws_sm[region=eu-west-1]": awssm},
+	}
+	c.Region = "eu-west-1"
+	awssm.On("GetSecretVersion", "b", sp.SecretVersion{VersionStage: "AWSCURRENT"}).Return(sp.NewSecret(`{"porty": 6543}`), nil)
+
+	creds, err := c.GetCredentials()
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("oldsecret", creds.Password)
+	assert.Equal(6543, creds.Port)
+}
+
+func Test_Credentials_AWSSMDatabaseCredentials_FromYaml(t *testing.T) {
+	data := `
+region: eu-west-1
+versionStage: AWSPREVIOUS
+username:
+  secretName: a
+  secretKey: b
+  region: us-east-1
+  roleArn: arn:aws:iam::123456789012:role/x
+  sessionName: migrations
+password:
+  secretName: a
+  secretKey: b
+  versionId: v1
+host:
+  secretName: a
+  secretKey: b
+  endpointUrl: http://localhost:4566
+port:
+  secretName: a
+  secretKey: b
+database:
+  secretName: a
+  secretKey: b
+`
+	c := &AWSSMDatabaseCredentials{}
+	assert := assert.New(t)
+	assert.NoError(yaml.Unmarshal([]byte(data), c))
+	assert.Equal("eu-west-1", c.Region)
+	assert.Equal("AWSPREVIOUS", c.VersionStage)
+
+	refs := c.secretRefs()
+	assert.Equal(sp.AWSClientConfig{Region: "us-east-1", RoleArn: "arn:aws:iam::123456789012:role/x", SessionName: "migrations"}, refs[0].ref.AWSClientConfig)
+	assert.Equal(sp.SecretVersion{VersionStage: "AWSPREVIOUS"}, refs[0].ref.SecretVersion)
+	assert.Equal(sp.SecretVersion{VersionId: "v1"}, refs[1].ref.SecretVersion)
+	assert.Equal(sp.AWSClientConfig{Region: "eu-west-1", EndpointUrl: "http://localhost:4566"}, refs[2].ref.AWSClientConfig)
+	assert.Equal(sp.AWSClientConfig{Region: "eu-west-1"}, refs[3].ref.AWSClientConfig)
+}
+
+func rotatingAWSSMDatabaseCredentials(provider sp.SecretsProvider) *AWSSMDatabaseCredentials {
+	return &AWSSMDatabaseCredentials{
+		Username:  &sp.SecretRef{SecretName: "a", SecretKey: "username"},
+		Password:  &sp.SecretRef{SecretName: "a", SecretKey: "password"},
+		Host:      &sp.SecretRef{SecretName: "a", SecretKey: "host"},
+		Port:      &sp.SecretRef{SecretName: "a", SecretKey: "port"},
+		Database:  &sp.SecretRef{SecretName: "a", SecretKey: "database"},
+		providers: testProviders(provider),
+	}
+}
+
+func rotatedSecret(password string) *sp.Secret {
+	return sp.NewSecret(fmt.Sprintf(`{
+		"username": "bob",
+		"password": %q,
+		"host":     "localhost",
+		"port":     5432,
+		"database": "postgres"
+	}`, password))
+}
+
+func Test_AWSSMDatabaseCredentials_Invalidate_RefetchesSecrets(t *testing.T) {
+	awssm := new(MockSecretsProvider)
+	awssm.On("GetSecret", "a").Return(rotatedSecret("old"), nil).Once()
+	awssm.On("GetSecret", "a").Return(rotatedSecret("new"), nil).Once()
+	c := rotatingAWSSMDatabaseCredentials(sp.NewSecretCache(0).Wrap("ns", awssm))
+
+	assert := assert.New(t)
+	creds, err := c.GetCredentials()
+	assert.NoError(err)
+	assert.Equal("old", creds.Password)
+
+	c.Invalidate()
+	creds, err = c.GetCredentials()
+	assert.NoError(err)
+	assert.Equal("new", creds.Password)
+	awssm.AssertNumberOfCalls(t, "GetSecret", 2)
+}
+
+func Test_AWSSMDatabaseCredentials_Invalidate_DoesNothingBeforeValidation(t *testing.T) {
+	c := &AWSSMDatabaseCredentials{}
+	assert := assert.New(t)
+	assert.NotPanics(c.Invalidate)
+}
+
+func Test_AWSSMDatabaseCredentials_FallbackCredentials_SkipsMissingStages(t *testing.T) {
+	awssm := new(MockVersionedSecretsProvider)
+	awssm.On("GetSecretVersion", "a", sp.SecretVersion{VersionStage: "AWSPENDING"}).Return((*sp.Secret)(nil), fmt.Errorf("not found"))
+	awssm.On("GetSecretVersion", "a", sp.SecretVersion{VersionStage: "AWSPREVIOUS"}).Return(rotatedSecret("previous"), nil)
+	c := rotatingAWSSMDatabaseCredentials(awssm)
+	c.FallbackVersionStages = []string{"AWSPENDING", "AWSPREVIOUS"}
+
+	fallbacks, err := c.FallbackCredentials()
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Len(fallbacks, 1)
+	assert.Equal("previous", fallbacks[0].Password)
+}
+
+func Test_AWSSMDatabaseCredentials_FallbackCredentials_FailsWhenNoStageResolves(t *testing.T) {
+	awssm := new(MockVersionedSecretsProvider)
+	awssm.On("GetSecretVersion", "a", mock.Anything).Return((*sp.Secret)(nil), fmt.Errorf("not found"))
+	c := rotatingAWSSMDatabaseCredentials(awssm)
+	c.FallbackVersionStages = []string{"AWSPENDING"}
+
+	_, err := c.FallbackCredentials()
+	assert := assert.New(t)
+	assert.ErrorContains(err, "version stage AWSPENDING")
+}
+
+func Test_AWSSMDatabaseCredentials_Validate_FailsWithEmptyFallbackVersionStage(t *testing.T) {
+	c := validAWSSMDatabaseCredentials()
+	c.FallbackVersionStages = []string{"AWSPENDING", ""}
+	assert := assert.New(t)
+	assert.EqualError(c.Validate(), "fallbackVersionStages[1]: empty version stage in aws_sm credentials")
+}
+
+func Test_AWSSMDatabaseCredentials_ValidateConfig_DoesNotCreateClients(t *testing.T) {
+	c := validAWSSMDatabaseCredentials()
+	c.providers = nil
+	NewAWSSecretsManager = func(sp.AWSClientConfig) (*sp.AWSSecretsManager, error) {
+		t.Fatal("client created during config validation")
+		return nil, nil
+	}
+	defer func() { NewAWSSecretsManager = sp.SharedAWSSecretsManager }()
+
+	assert := assert.New(t)
+	assert.NoError(c.ValidateConfig())
+	assert.Nil(c.providers)
+}
+
+func Test_AWSSMDatabaseCredentials_ValidateConfig_FailsWhenSecretRefInvalid(t *testing.T) {
+	c := validAWSSMDatabaseCredentials()
+	c.Host = &sp.SecretRef{SecretName: "a", Provider: "unknown"}
+	assert := assert.New(t)
+	assert.Error(c.ValidateConfig())
+}
+
+func Test_AWSSMDatabaseCredentials_ValidateConfig_ReportsAllErrorsWithPaths(t *testing.T) {
+	c := validAWSSMDatabaseCredentials()
+	c.Username = nil
+	c.Port = nil
+	c.ExternalId = "ext"
+
+	errs := validation.Errors(c.ValidateConfig())
+	assert := assert.New(t)
+	assert.Len(errs, 3)
+	assert.EqualError(errs[0], "username: missing 'username' key in aws_sm credentials")
+	assert.EqualError(errs[1], "port: missing 'port' key in aws_sm credentials")
+	assert.EqualError(errs[2], "externalId: 'externalId' requires 'roleArn' to be set")
+}
+
+func Test_AWSSMDatabaseCredentials_ValidateConfig_ReportsSecretRefPaths(t *testing.T) {
+	c := validAWSSMDatabaseCredentials()
+	c.Host = &sp.SecretRef{SecretName: "a", SecretKey: "b[", Encoding: "hex"}
+	c.Port = &sp.SecretRef{SecretName: "a", Provider: "other"}
+
+	errs := validation.Errors(c.ValidateConfig())
+	assert := assert.New(t)
+	assert.Len(errs, 3)
+	assert.Contains(errs[0].Error(), "host.secretKey: ")
+	assert.Contains(errs[1].Error(), "host.encoding: ")
+	assert.Contains(errs[2].Error(), "port.provider: ")
+}
@@ -0,0 +1,144 @@
+package credentials_provider
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
+
+// CompositeDatabaseCredentials resolves every credentials field from its own source
+type CompositeDatabaseCredentials struct {
+	Username *ValueSource `yaml:"username,omitempty"`
+	Password *ValueSource `yaml:"password,omitempty"`
+	Host     *ValueSource `yaml:"host,omitempty"`
+	Port     *ValueSource `yaml:"port,omitempty"`
+	Database *ValueSource `yaml:"database,omitempty"`
+
+	// secrets providers keyed by provider key of the secret references
+	providers map[string]sp.SecretsProvider
+}
+
+type compositeField struct {
+	name   string
+	source *ValueSource
+}
+
+func (c *CompositeDatabaseCredentials) fields() []compositeField {
+	return []compositeField{
+		{"username", c.Username},
+		{"password", c.Password},
+		{"host", c.Host},
+		{"port", c.Port},
+		{"database", c.Database},
+	}
+}
+
+func (c *CompositeDatabaseCredentials) ValidateConfig() error {
+	errs := []error{}
+	for _, f := range c.fields() {
+		if f.source == nil {
+			errs = append(errs, validation.Errorf(f.name, "missing '%s' key in %s credentials", f.name, CompositeProviderType))
+			continue
+		}
+		if err := f.source.Validate(); err != nil {
+			errs = append(errs, validation.AtPath(f.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validates the configuration and creates the secrets providers of the secret sources
+func (c *CompositeDatabaseCredentials) Validate() error {
+	if err := c.ValidateConfig(); err != nil {
+		return err
+	}
+
+	if c.providers == nil {
+		c.providers = make(map[string]sp.SecretsProvider)
+	}
+
+	for _, f := range c.fields() {
+		if f.source.Secret == nil {
+			continue
+		}
+		key := f.source.Secret.ProviderKey()
+		if _, ok := c.providers[key]; ok {
+			continue
+		}
+		provider, err := NewSecretsProvider(f.source.Secret)
+		if err != nil {
+			return err
+		}
+		c.providers[key] = sp.DefaultSecretCache.Wrap(key, provider)
+	}
+
+	return nil
+}
+
+// Resolves all fields concurrently and merges them into one set of credentials
+func (c *CompositeDatabaseCredentials) GetCredentials() (*DatabaseCredentials, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	fields := c.fields()
+	values := make([]string, len(fields))
+	errs := make([]error, len(fields))
+
+	var wg sync.WaitGroup
+	for i, f := range fields {
+		wg.Add(1)
+		go func(i int, name string, source *ValueSource) {
+			defer wg.Done()
+			value, err := source.Resolve(c.providers)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to resolve '%s' in %s credentials: %w", name, CompositeProviderType, err)
+				return
+			}
+			values[i] = value
+		}(i, f.name, f.source)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	credentials := &DatabaseCredentials{}
+
+	for i, f := range fields {
+		switch f.name {
+		case "username":
+			credentials.Username = values[i]
+		case "password":
+			credentials.Password = values[i]
+		case "host":
+			credentials.Host = values[i]
+		case "database":
+			credentials.Database = values[i]
+		case "port":
+			// the error of Atoi holds the value, which may be a secret
+			port, err := strconv.Atoi(values[i])
+			if err != nil {
+				return nil, fmt.Errorf("'port' in %s credentials is not an integer", CompositeProviderType)
+			}
+			credentials.Port = port
+		}
+	}
+
+	return credentials, nil
+}
+
+// Drops the cached secrets of the fields so that they are fetched again
+func (c *CompositeDatabaseCredentials) Invalidate() {
+	for _, f := range c.fields() {
+		if f.source == nil || f.source.Secret == nil {
+			continue
+		}
+		sp.InvalidateSecret(c.providers[f.source.Secret.ProviderKey()], f.source.Secret.SecretName)
+	}
+}
@@ -0,0 +1,164 @@
+package credentials_provider
+
+import (
+	"fmt"
+	"testing"
+
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"github.com/stretchr/testify/assert"
+)
+
+func validCompositeCredentials(t *testing.T, secrets sp.SecretsProvider) *CompositeDatabaseCredentials {
+	t.Setenv("COMPOSITE_USER", "envuser")
+	return &CompositeDatabaseCredentials{
+		Username:  &ValueSource{Env: "COMPOSITE_USER"},
+		Password:  &ValueSource{Secret: &sp.SecretRef{SecretName: "db", SecretKey: "password"}},
+		Host:      &ValueSource{Value: "localhost"},
+		Port:      &ValueSource{Value: "5432"},
+		Database:  &ValueSource{Value: "postgres"},
+		providers: testProviders(secrets),
+	}
+}
+
+func Test_CompositeDatabaseCredentials_Validate_Succeeds(t *testing.T) {
+	c := validCompositeCredentials(t, new(MockSecretsProvider))
+	assert := assert.New(t)
+	assert.NoError(c.Validate())
+}
+
+func Test_CompositeDatabaseCredentials_Validate_FailsWhenFieldNil(t *testing.T) {
+	c := validCompositeCredentials(t, new(MockSecretsProvider))
+	assert := assert.New(t)
+	for _, field := range []**ValueSource{&c.Username, &c.Password, &c.Host, &c.Port, &c.Database} {
+		fieldBefore := *field
+		*field = nil
+		assert.Error(c.Validate())
+		*field = fieldBefore
+	}
+}
+
+func Test_CompositeDatabaseCredentials_Validate_FailsWhenFieldInvalid(t *testing.T) {
+	c := validCompositeCredentials(t, new(MockSecretsProvider))
+	c.Host = &ValueSource{}
+	assert := assert.New(t)
+	assert.Error(c.Validate())
+}
+
+func Test_CompositeDatabaseCredentials_Validate_CreatesSecretsProviders(t *testing.T) {
+	c := validCompositeCredentials(t, nil)
+	c.providers = nil
+	calls := 0
+	NewSecretsProvider = func(ref *sp.SecretRef) (sp.SecretsProvider, error) {
+		calls++
+		return new(MockSecretsProvider), nil
+	}
+	defer func() { NewSecretsProvider = sp.NewSecretsProvider }()
+
+	assert := assert.New(t)
+	assert.NoError(c.Validate())
+	assert.NoError(c.Validate())
+	assert.Equal(1, calls)
+}
+
+func Test_CompositeDatabaseCredentials_Validate_FailsWhenSecretsProviderFails(t *testing.T) {
+	c := validCompositeCredentials(t, nil)
+	c.providers = nil
+	NewSecretsProvider = func(ref *sp.SecretRef) (sp.SecretsProvider, error) {
+		return nil, fmt.Errorf("error")
+	}
+	defer func() { NewSecretsProvider = sp.NewSecretsProvider }()
+
+	assert := assert.New(t)
+	assert.Error(c.Validate())
+}
+
+func Test_CompositeDatabaseCredentials_GetCredentials_MergesAllSources(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "db").Return(sp.NewSecret(`{"password": "supersecret"}`), nil)
+	c := validCompositeCredentials(t, secrets)
+
+	creds, err := c.GetCredentials()
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(DatabaseCredentials{
+		Username: "envuser",
+		Password: "supersecret",
+		Host:     "localhost",
+		Port:     5432,
+		Database: "postgres",
+	}, *creds)
+}
+
+func Test_CompositeDatabaseCredentials_GetCredentials_ReportsAllFailedFields(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "db").Return((*sp.Secret)(nil), fmt.Errorf("test error 123"))
+	c := validCompositeCredentials(t, secrets)
+	c.Username = &ValueSource{Env: "COMPOSITE_USER_NOT_SET"}
+
+	_, err := c.GetCredentials()
+	assert := assert.New(t)
+	assert.Error(err)
+	assert.Contains(err.Error(), "'username'")
+	assert.Contains(err.Error(), "'password'")
+}
+
+func Test_CompositeDatabaseCredentials_GetCredentials_FailsWhenPortNotInteger(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "db").Return(sp.NewSecret(`{"password": "supersecret"}`), nil)
+	c := validCompositeCredentials(t, secrets)
+	c.Port = &ValueSource{Value: "notint"}
+
+	_, err := c.GetCredentials()
+	assert := assert.New(t)
+	assert.EqualError(err, "'port' in composite credentials is not an integer")
+}
+
+func Test_CompositeDatabaseCredentials_Invalidate_RefetchesSecrets(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "db").Return(sp.NewSecret(`{"password": "supersecret"}`), nil)
+	c := validCompositeCredentials(t, sp.NewSecretCache(0).Wrap("ns", secrets))
+
+	assert := assert.New(t)
+	for i := 0; i < 2; i++ {
+		_, err := c.GetCredentials()
+		assert.NoError(err)
+	}
+	c.Invalidate()
+	_, err := c.GetCredentials()
+	assert.NoError(err)
+	secrets.AssertNumberOfCalls(t, "GetSecret", 2)
+}
+
+func Test_CompositeDatabaseCredentials_ValidateConfig_DoesNotResolveSources(t *testing.T) {
+	c := &CompositeDatabaseCredentials{
+		Username: &ValueSource{Env: "COMPOSITE_USER_NOT_SET"},
+		Password: &ValueSource{Secret: &sp.SecretRef{SecretName: "db", SecretKey: "password"}},
+		Host:     &ValueSource{File: "/path/that/does/not/exist"},
+		Port:     &ValueSource{Value: "5432"},
+		Database: &ValueSource{Value: "postgres"},
+	}
+	NewSecretsProvider = func(ref *sp.SecretRef) (sp.SecretsProvider, error) {
+		t.Fatal("secrets provider created during config validation")
+		return nil, nil
+	}
+	defer func() { NewSecretsProvider = sp.NewSecretsProvider }()
+
+	assert := assert.New(t)
+	assert.NoError(c.ValidateConfig())
+	assert.Nil(c.providers)
+}
+
+func Test_CompositeDatabaseCredentials_ValidateConfig_ReportsAllErrorsWithPaths(t *testing.T) {
+	c := validCompositeCredentials(t, nil)
+	c.Username = nil
+	c.Host = &ValueSource{Value: "a", Env: "B"}
+	c.Password.Secret.SecretName = ""
+
+	errs := validation.Errors(c.ValidateConfig())
+	assert := assert.New(t)
+	assert.Len(errs, 3)
+	assert.EqualError(errs[0], "username: missing 'username' key in composite credentials")
+	assert.EqualError(errs[1], "password.secret.secretName: secretRef missing 'secretName' attribute")
+	assert.EqualError(errs[2], "host: only one of 'value', 'env', 'file' or 'secret' can be specified")
+}
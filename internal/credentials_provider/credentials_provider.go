@@ -3,18 +3,37 @@ package credentials_provider
 type CredentialsProviderType string
 
 const (
-	TextProviderType  CredentialsProviderType = "text"
-	EnvProviderType   CredentialsProviderType = "env"
-	AWSSMProviderType CredentialsProviderType = "aws_sm"
+	TextProviderType      CredentialsProviderType = "text"
+	EnvProviderType       CredentialsProviderType = "env"
+	AWSSMProviderType     CredentialsProviderType = "aws_sm"
+	ChainProviderType     CredentialsProviderType = "chain"
+	CompositeProviderType CredentialsProviderType = "composite"
 )
 
 type DatabaseCredentialsProvider interface {
+	// Validates the structure of the configuration without resolving any values,
+	// so that it can be checked without access to the environment or secret stores
+	ValidateConfig() error
 	// Validates the struct for any errors / missing fields etc
 	Validate() error
 	// Returns database credentials according to the given configuration
 	GetCredentials() (*DatabaseCredentials, error)
 }
 
+// Implemented by providers that cache credentials which can change during a run,
+// e.g when a secret is rotated
+type RefreshableCredentialsProvider interface {
+	// Drops any cached credentials so that the next call to GetCredentials fetches them again
+	Invalidate()
+}
+
+// Implemented by providers that can offer other credentials to try when the
+// current credentials are rejected by the database
+type FallbackCredentialsProvider interface {
+	// Returns the alternative credentials in the order they should be tried
+	FallbackCredentials() ([]*DatabaseCredentials, error)
+}
+
 type DatabaseCredentials struct {
 	Username string `json:"username,omitempty" yaml:"username,omitempty"`
 	Password string `json:"password,omitempty" yaml:"password,omitempty"`
@@ -1,9 +1,12 @@
 package credentials_provider
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+
+	"github.com/sourcehawk/go-flyway/internal/validation"
 )
 
 type EnvDatabaseCredentials struct {
@@ -60,21 +63,28 @@ func (e *EnvDatabaseCredentials) loadFromEnv() error {
 	return nil
 }
 
-func (e *EnvDatabaseCredentials) Validate() error {
-	if e.UsernameKey == "" {
-		return fmt.Errorf("missing 'usernameKey' in %s credentials", EnvProviderType)
-	}
-	if e.PasswordKey == "" {
-		return fmt.Errorf("missing 'passwordKey' in %s credentials", EnvProviderType)
-	}
-	if e.HostKey == "" {
-		return fmt.Errorf("missing 'hostKey' in %s credentials", EnvProviderType)
-	}
-	if e.PortKey == "" {
-		return fmt.Errorf("missing 'portKey' in %s credentials", EnvProviderType)
+func (e *EnvDatabaseCredentials) ValidateConfig() error {
+	errs := []error{}
+	for _, f := range []struct {
+		name string
+		key  string
+	}{
+		{"usernameKey", e.UsernameKey},
+		{"passwordKey", e.PasswordKey},
+		{"hostKey", e.HostKey},
+		{"portKey", e.PortKey},
+		{"databaseKey", e.DatabaseKey},
+	} {
+		if f.key == "" {
+			errs = append(errs, validation.Errorf(f.name, "missing '%s' in %s credentials", f.name, EnvProviderType))
+		}
 	}
-	if e.DatabaseKey == "" {
-		return fmt.Errorf("missing 'databaseKey in %s credentials", EnvProviderType)
+	return errors.Join(errs...)
+}
+
+func (e *EnvDatabaseCredentials) Validate() error {
+	if err := e.ValidateConfig(); err != nil {
+		return err
 	}
 	if err := e.loadFromEnv(); err != nil {
 		return err
@@ -133,3 +133,23 @@ func Test_EnvDatabaseCredentials_GetCredentials_FailsOnValidationError(t *testin
 	assert := assert.New(t)
 	assert.Error(err)
 }
+
+func Test_EnvDatabaseCredentials_ValidateConfig_DoesNotReadEnv(t *testing.T) {
+	e := &EnvDatabaseCredentials{
+		UsernameKey: "ENV_CREDENTIALS_NOT_SET",
+		PasswordKey: "ENV_CREDENTIALS_NOT_SET",
+		HostKey:     "ENV_CREDENTIALS_NOT_SET",
+		PortKey:     "ENV_CREDENTIALS_NOT_SET",
+		DatabaseKey: "ENV_CREDENTIALS_NOT_SET",
+	}
+	assert := assert.New(t)
+	assert.NoError(e.ValidateConfig())
+	assert.Error(e.Validate())
+}
+
+func Test_EnvDatabaseCredentials_ValidateConfig_FailsWhenMissingEnvKey(t *testing.T) {
+	e := validEnvCredentials(t)
+	e.HostKey = ""
+	assert := assert.New(t)
+	assert.EqualError(e.ValidateConfig(), "hostKey: missing 'hostKey' in env credentials")
+}
@@ -1,28 +1,36 @@
 package credentials_provider
 
-import "fmt"
+import (
+	"errors"
+
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
 
 type TextDatabaseCredentials struct {
 	DatabaseCredentials `yaml:",inline"`
 }
 
-func (d *TextDatabaseCredentials) Validate() error {
-	if d.Username == "" {
-		return fmt.Errorf("missing 'username' key in %s credentials", TextProviderType)
-	}
-	if d.Password == "" {
-		return fmt.Errorf("missing 'password' key in %s credentials", TextProviderType)
-	}
-	if d.Host == "" {
-		return fmt.Errorf("missing 'host' key in %s credentials", TextProviderType)
-	}
-	if d.Port == 0 {
-		return fmt.Errorf("missing 'port' key in %s credentials", TextProviderType)
+func (d *TextDatabaseCredentials) ValidateConfig() error {
+	errs := []error{}
+	for _, f := range []struct {
+		name  string
+		isSet bool
+	}{
+		{"username", d.Username != ""},
+		{"password", d.Password != ""},
+		{"host", d.Host != ""},
+		{"port", d.Port != 0},
+		{"database", d.Database != ""},
+	} {
+		if !f.isSet {
+			errs = append(errs, validation.Errorf(f.name, "missing '%s' key in %s credentials", f.name, TextProviderType))
+		}
 	}
-	if d.Database == "" {
-		return fmt.Errorf("missing 'database' key in %s credentials", TextProviderType)
-	}
-	return nil
+	return errors.Join(errs...)
+}
+
+func (d *TextDatabaseCredentials) Validate() error {
+	return d.ValidateConfig()
 }
 
 func (d *TextDatabaseCredentials) GetCredentials() (*DatabaseCredentials, error) {
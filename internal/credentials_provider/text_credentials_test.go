@@ -3,6 +3,7 @@ package credentials_provider
 import (
 	"testing"
 
+	"github.com/sourcehawk/go-flyway/internal/validation"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -79,3 +80,14 @@ func Test_TextDatabaseCredentials_GetCredentials_FailsOnValidationError(t *testi
 	_, err := d.GetCredentials()
 	assert.Error(err)
 }
+
+func Test_TextDatabaseCredentials_ValidateConfig_ReportsEveryMissingField(t *testing.T) {
+	d := &TextDatabaseCredentials{DatabaseCredentials: DatabaseCredentials{Username: "a", Host: "a"}}
+
+	errs := validation.Errors(d.ValidateConfig())
+	assert := assert.New(t)
+	assert.Len(errs, 3)
+	assert.EqualError(errs[0], "password: missing 'password' key in text credentials")
+	assert.EqualError(errs[1], "port: missing 'port' key in text credentials")
+	assert.EqualError(errs[2], "database: missing 'database' key in text credentials")
+}
@@ -0,0 +1,89 @@
+package credentials_provider
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
+
+var NewSecretsProvider = sp.NewSecretsProvider
+
+// ValueSource declares where a single credentials field gets its value from.
+// Exactly one of the sources must be set.
+type ValueSource struct {
+	// Literal value
+	Value string `yaml:"value,omitempty"`
+	// Name of an environment variable holding the value
+	Env string `yaml:"env,omitempty"`
+	// Path of a file holding the value, trailing newlines are removed
+	File string `yaml:"file,omitempty"`
+	// Reference to a key in a secret of any registered secrets provider
+	Secret *sp.SecretRef `yaml:"secret,omitempty"`
+}
+
+func (v *ValueSource) Validate() error {
+	set := 0
+	for _, isSet := range []bool{v.Value != "", v.Env != "", v.File != "", v.Secret != nil} {
+		if isSet {
+			set++
+		}
+	}
+
+	if set == 0 {
+		return fmt.Errorf("must specify one of 'value', 'env', 'file' or 'secret'")
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of 'value', 'env', 'file' or 'secret' can be specified")
+	}
+
+	if v.Secret != nil {
+		return validation.AtPath("secret", v.Secret.Validate())
+	}
+
+	return nil
+}
+
+// Resolves the value from its source.
+//
+// providers holds the secrets provider to use for each provider key,
+// resolving a secret reference without a provider fails
+func (v *ValueSource) Resolve(providers map[string]sp.SecretsProvider) (string, error) {
+	switch {
+	case v.Value != "":
+		return v.Value, nil
+	case v.Env != "":
+		value, ok := os.LookupEnv(v.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", v.Env)
+		}
+		if value == "" {
+			return "", fmt.Errorf("environment variable %s has empty value", v.Env)
+		}
+		return value, nil
+	case v.File != "":
+		data, err := os.ReadFile(v.File)
+		if err != nil {
+			return "", fmt.Errorf("could not read file: %w", err)
+		}
+		value := strings.TrimRight(string(data), "\r\n")
+		if value == "" {
+			return "", fmt.Errorf("file %s is empty", v.File)
+		}
+		return value, nil
+	case v.Secret != nil:
+		provider, ok := providers[v.Secret.ProviderKey()]
+		if !ok {
+			return "", fmt.Errorf("secrets provider %s not initialized", v.Secret.ProviderKey())
+		}
+		secret, err := v.Secret.Fetch(provider)
+		if err != nil {
+			return "", err
+		}
+		return v.Secret.LookupString(secret)
+	default:
+		return "", v.Validate()
+	}
+}
@@ -0,0 +1,102 @@
+package credentials_provider
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ValueSource_Validate_SucceedsWithSingleSource(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError((&ValueSource{Value: "a"}).Validate())
+	assert.NoError((&ValueSource{Env: "A"}).Validate())
+	assert.NoError((&ValueSource{File: "/a"}).Validate())
+	assert.NoError((&ValueSource{Secret: &sp.SecretRef{SecretName: "a", SecretKey: "b"}}).Validate())
+}
+
+func Test_ValueSource_Validate_FailsWithoutSource(t *testing.T) {
+	assert := assert.New(t)
+	assert.Error((&ValueSource{}).Validate())
+}
+
+func Test_ValueSource_Validate_FailsWithMultipleSources(t *testing.T) {
+	assert := assert.New(t)
+	assert.Error((&ValueSource{Value: "a", Env: "A"}).Validate())
+}
+
+func Test_ValueSource_Validate_FailsWithInvalidSecretRef(t *testing.T) {
+	assert := assert.New(t)
+	assert.Error((&ValueSource{Secret: &sp.SecretRef{SecretName: "a", SecretKey: "b["}}).Validate())
+}
+
+func Test_ValueSource_Resolve_FromEnv(t *testing.T) {
+	t.Setenv("VALUE_SOURCE_TEST", "from-env")
+	assert := assert.New(t)
+
+	v, err := (&ValueSource{Env: "VALUE_SOURCE_TEST"}).Resolve(nil)
+	assert.NoError(err)
+	assert.Equal("from-env", v)
+
+	t.Setenv("VALUE_SOURCE_TEST", "")
+	_, err = (&ValueSource{Env: "VALUE_SOURCE_TEST"}).Resolve(nil)
+	assert.Error(err)
+
+	_, err = (&ValueSource{Env: "VALUE_SOURCE_TEST_NOT_SET"}).Resolve(nil)
+	assert.Error(err)
+}
+
+func Test_ValueSource_Resolve_FromFileTrimsTrailingNewlines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "value")
+	assert := assert.New(t)
+	assert.NoError(os.WriteFile(path, []byte("from-file\n"), 0o600))
+
+	v, err := (&ValueSource{File: path}).Resolve(nil)
+	assert.NoError(err)
+	assert.Equal("from-file", v)
+
+	_, err = (&ValueSource{File: path + "-missing"}).Resolve(nil)
+	assert.Error(err)
+}
+
+func Test_ValueSource_Resolve_FromSecret(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "a").Return(sp.NewSecret(`{"str": "x", "num": 5432, "nil": null}`), nil)
+	providers := testProviders(secrets)
+	assert := assert.New(t)
+
+	v, err := (&ValueSource{Secret: &sp.SecretRef{SecretName: "a", SecretKey: "str"}}).Resolve(providers)
+	assert.NoError(err)
+	assert.Equal("x", v)
+
+	v, err = (&ValueSource{Secret: &sp.SecretRef{SecretName: "a", SecretKey: "num"}}).Resolve(providers)
+	assert.NoError(err)
+	assert.Equal("5432", v)
+
+	_, err = (&ValueSource{Secret: &sp.SecretRef{SecretName: "a", SecretKey: "nil"}}).Resolve(providers)
+	assert.Error(err)
+
+	_, err = (&ValueSource{Secret: &sp.SecretRef{SecretName: "a", SecretKey: "missing"}}).Resolve(providers)
+	assert.Error(err)
+}
+
+func Test_ValueSource_Resolve_FailsOnSecretsProviderError(t *testing.T) {
+	secrets := new(MockSecretsProvider)
+	secrets.On("GetSecret", "a").Return((*sp.Secret)(nil), fmt.Errorf("test error 123"))
+	providers := testProviders(secrets)
+
+	_, err := (&ValueSource{Secret: &sp.SecretRef{SecretName: "a", SecretKey: "b"}}).Resolve(providers)
+	assert := assert.New(t)
+	assert.Error(err)
+}
+
+func Test_ValueSource_Resolve_FailsWithoutSecretsProvider(t *testing.T) {
+	_, err := (&ValueSource{Secret: &sp.SecretRef{SecretName: "a", SecretKey: "b"}}).Resolve(nil)
+
+	assert := assert.New(t)
+	assert.ErrorContains(err, "secrets provider")
+	assert.ErrorContains(err, "not initialized")
+}
@@ -0,0 +1,191 @@
+package flyway_config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Name of the environment flyway uses when flyway.environment is not set
+const DefaultEnvironment = "default"
+
+// A native flyway configuration, read from a flyway.conf or flyway.toml file.
+//
+// Nested tables are flattened to dotted names, e.g placeholders.owner
+type Config struct {
+	// Settings of the flyway namespace by name
+	Flyway map[string]any
+	// Settings of each environment by environment name, e.g url. Flyway
+	// settings of an environment are prefixed with flyway., e.g flyway.locations
+	Environments map[string]map[string]any
+}
+
+// Returns the name of the environment flyway connects to
+func (c *Config) Environment() string {
+	if name, ok := c.Flyway["environment"].(string); ok && name != "" {
+		return name
+	}
+	return DefaultEnvironment
+}
+
+// Returns the names of the environments in order
+func (c *Config) EnvironmentNames() []string {
+	names := make([]string, 0, len(c.Environments))
+	for name := range c.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Loads a flyway.toml file, or a flyway.conf file if the file does not have
+// the .toml extension
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flyway config: %w", err)
+	}
+
+	var c *Config
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		c, err = ParseToml(data)
+	} else {
+		c, err = ParseConf(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parses a flyway.toml file. Settings outside of the flyway and
+// environments tables are ignored, as flyway does
+func ParseToml(data []byte) (*Config, error) {
+	var root map[string]any
+	if _, err := toml.Decode(string(data), &root); err != nil {
+		return nil, err
+	}
+
+	c := &Config{Flyway: map[string]any{}, Environments: map[string]map[string]any{}}
+
+	if flyway, ok := root["flyway"].(map[string]any); ok {
+		flatten("", flyway, c.Flyway)
+	}
+	if environments, ok := root["environments"].(map[string]any); ok {
+		for name, environment := range environments {
+			settings, ok := environment.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("environments.%s must be a table", name)
+			}
+			c.Environments[name] = map[string]any{}
+			flatten("", settings, c.Environments[name])
+		}
+	}
+
+	return c, nil
+}
+
+// Parses a flyway.conf file, a Java properties file of flyway.name=value
+// settings. Values are strings, properties without the flyway. prefix are ignored
+func ParseConf(data []byte) (*Config, error) {
+	c := &Config{Flyway: map[string]any{}, Environments: map[string]map[string]any{}}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	line, number := "", 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimLeft(scanner.Text(), " \t\f")
+		if line == "" && (text == "" || text[0] == '#' || text[0] == '!') {
+			continue
+		}
+
+		// a line ending with an odd number of backslashes continues on the next line
+		if trailing := len(text) - len(strings.TrimRight(text, `\`)); trailing%2 == 1 {
+			line += text[:len(text)-1]
+			continue
+		}
+		line += text
+
+		key, value, err := cutProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		if name, ok := strings.CutPrefix(key, "flyway."); ok {
+			c.Flyway[name] = value
+		}
+		line = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Splits a property at the first unescaped '=' or ':' and unescapes its key and value
+func cutProperty(line string) (string, string, error) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '=', ':':
+			key := unescapeProperty(strings.TrimSpace(line[:i]))
+			if key == "" {
+				return "", "", fmt.Errorf("missing property name")
+			}
+			return key, unescapeProperty(strings.TrimLeft(line[i+1:], " \t\f")), nil
+		}
+	}
+	return "", "", fmt.Errorf("expected name=value, found %s", line)
+}
+
+func unescapeProperty(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Flattens the nested tables of the table into dotted names
+func flatten(prefix string, table map[string]any, settings map[string]any) {
+	for key, value := range table {
+		name := prefix + key
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(name+".", v, settings)
+		case int64:
+			settings[name] = int(v)
+		case []any:
+			list := make([]any, len(v))
+			for i, item := range v {
+				if n, ok := item.(int64); ok {
+					item = int(n)
+				}
+				list[i] = item
+			}
+			settings[name] = list
+		default:
+			settings[name] = v
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package flyway_config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseToml_FlattensTables(t *testing.T) {
+	c, err := ParseToml([]byte(`
+[environments.default]
+url = "jdbc:postgresql://localhost/app"
+schemas = ["app"]
+
+[environments.default.jdbcProperties]
+ssl = "true"
+
+[environments.prod]
+url = "jdbc:postgresql://prod/app"
+
+[environments.prod.flyway]
+cleanDisabled = true
+
+[flyway]
+environment = "prod"
+connectRetries = 3
+locations = ["filesystem:sql"]
+
+[flyway.placeholders]
+owner = "app_owner"
+"a.b" = 1
+
+[other]
+ignored = true
+`))
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		"environment":        "prod",
+		"connectRetries":     3,
+		"locations":          []any{"filesystem:sql"},
+		"placeholders.owner": "app_owner",
+		"placeholders.a.b":   1,
+	}, c.Flyway)
+	assert.Equal(map[string]map[string]any{
+		"default": {"url": "jdbc:postgresql://localhost/app", "schemas": []any{"app"}, "jdbcProperties.ssl": "true"},
+		"prod":    {"url": "jdbc:postgresql://prod/app", "flyway.cleanDisabled": true},
+	}, c.Environments)
+	assert.Equal("prod", c.Environment())
+	assert.Equal([]string{"default", "prod"}, c.EnvironmentNames())
+}
+
+func Test_ParseToml_FailsOnInvalidToml(t *testing.T) {
+	_, err := ParseToml([]byte("[flyway\n"))
+	assert.Error(t, err)
+}
+
+func Test_ParseConf_ParsesFlywayProperties(t *testing.T) {
+	c, err := ParseConf([]byte(`# comment
+! another comment
+flyway.url=jdbc:postgresql://localhost/app
+flyway.user : admin
+  flyway.schemas=app
+flyway.initSql=SET search_path=app,\
+    public
+flyway.placeholders.grants=GRANT a;\nGRANT b;
+flyway.placeholders.path=C:\\migrations
+other.setting=ignored
+`))
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		"url":                 "jdbc:postgresql://localhost/app",
+		"user":                "admin",
+		"schemas":             "app",
+		"initSql":             "SET search_path=app,public",
+		"placeholders.grants": "GRANT a;\nGRANT b;",
+		"placeholders.path":   `C:\migrations`,
+	}, c.Flyway)
+	assert.Empty(c.Environments)
+	assert.Equal(DefaultEnvironment, c.Environment())
+}
+
+func Test_ParseConf_FailsOnInvalidLines(t *testing.T) {
+	_, err := ParseConf([]byte("flyway.url=x\nflyway.user\n"))
+	assert.EqualError(t, err, "line 2: expected name=value, found flyway.user")
+}
+
+func Test_Load_DetectsFormatByExtension(t *testing.T) {
+	dir := t.TempDir()
+	toml := filepath.Join(dir, "flyway.toml")
+	conf := filepath.Join(dir, "flyway.conf")
+	os.WriteFile(toml, []byte("[flyway]\nlocations = [\"filesystem:sql\"]\n"), 0644)
+	os.WriteFile(conf, []byte("flyway.locations=filesystem:sql\n"), 0644)
+
+	assert := assert.New(t)
+	c, err := Load(toml)
+	assert.NoError(err)
+	assert.Equal([]any{"filesystem:sql"}, c.Flyway["locations"])
+
+	c, err = Load(conf)
+	assert.NoError(err)
+	assert.Equal("filesystem:sql", c.Flyway["locations"])
+
+	_, err = Load(filepath.Join(dir, "missing.toml"))
+	assert.ErrorContains(err, "failed to read flyway config")
+}
@@ -0,0 +1,176 @@
+package json_schema
+
+import (
+	"reflect"
+	"strings"
+)
+
+// The JSON Schema dialect of the generated schemas
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Matches values that contain a secret, env or file reference or an environment
+// variable, which may resolve to a value of any type
+const ReferencePattern = `\$\{((secret|env|file):[^}]*|[A-Za-z_][A-Za-z0-9_]*((:-|:\?)[^}]*)?)\}`
+
+// Schema is the subset of JSON Schema used to describe the configuration
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// Implemented by configuration types that restrict their generated schema
+// further than their fields and yaml tags do, e.g with the allowed values of a field
+type Extender interface {
+	ExtendJSONSchema(s *Schema)
+}
+
+var extenderType = reflect.TypeOf((*Extender)(nil)).Elem()
+
+type generator struct {
+	defs map[string]*Schema
+}
+
+// Generates the schema of the YAML representation of v from its fields and yaml tags.
+//
+// Structs other than v, and other named types that implement Extender, are
+// described in $defs and referenced by their type name
+func Generate(v any, title string) *Schema {
+	g := &generator{defs: map[string]*Schema{}}
+
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	root := g.structSchema(t)
+	root.Schema = Draft
+	root.Title = title
+	root.Defs = g.defs
+
+	return root
+}
+
+func (g *generator) schemaFor(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct:
+		return g.define(t, func() *Schema { return g.structSchema(t) })
+	case t.Name() != "" && reflect.PointerTo(t).Implements(extenderType):
+		return g.define(t, func() *Schema {
+			s := g.kindSchema(t)
+			reflect.New(t).Interface().(Extender).ExtendJSONSchema(s)
+			return s
+		})
+	default:
+		return g.kindSchema(t)
+	}
+}
+
+// Describes the named type in $defs, once, and returns a reference to it
+func (g *generator) define(t reflect.Type, build func() *Schema) *Schema {
+	name := t.Name()
+	if _, ok := g.defs[name]; !ok {
+		// registered before it is generated so that recursive types terminate
+		g.defs[name] = nil
+		g.defs[name] = build()
+	}
+	return &Schema{Ref: "#/$defs/" + name}
+}
+
+func (g *generator) kindSchema(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return OrReference(&Schema{Type: "boolean"})
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return OrReference(&Schema{Type: "integer"})
+	case reflect.Float32, reflect.Float64:
+		return OrReference(&Schema{Type: "number"})
+	default:
+		return &Schema{}
+	}
+}
+
+// Allows a reference or environment variable in place of a value that is not a
+// string, since they are only resolved to the value when the config is loaded
+func OrReference(s *Schema) *Schema {
+	return &Schema{AnyOf: []*Schema{
+		s,
+		{Type: "string", Pattern: ReferencePattern, Description: "reference or environment variable"},
+	}}
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{
+		Title:                t.Name(),
+		Type:                 "object",
+		Properties:           map[string]*Schema{},
+		AdditionalProperties: false,
+	}
+	g.addFields(s, t)
+
+	if reflect.PointerTo(t).Implements(extenderType) {
+		reflect.New(t).Interface().(Extender).ExtendJSONSchema(s)
+	}
+
+	return s
+}
+
+// Adds the fields of the struct, including those of inlined structs, to the schema
+func (g *generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		opts := "," + options + ","
+
+		if strings.Contains(opts, ",inline,") {
+			inlined := field.Type
+			for inlined.Kind() == reflect.Pointer {
+				inlined = inlined.Elem()
+			}
+			if inlined.Kind() == reflect.Map {
+				s.AdditionalProperties = g.schemaFor(inlined.Elem())
+				continue
+			}
+			g.addFields(s, inlined)
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		s.Properties[name] = g.schemaFor(field.Type)
+		if !strings.Contains(opts, ",omitempty,") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
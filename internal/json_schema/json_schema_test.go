@@ -0,0 +1,81 @@
+package json_schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testVersion struct {
+	Stage string `yaml:"stage,omitempty"`
+}
+
+type testRef struct {
+	testVersion `yaml:",inline"`
+	Name        string `yaml:"name"`
+	Port        int    `yaml:"port,omitempty"`
+}
+
+type testConfig struct {
+	Kind     string              `yaml:"kind"`
+	Refs     []*testRef          `yaml:"refs,omitempty"`
+	ByName   map[string]*testRef `yaml:"byName,omitempty"`
+	Parent   *testConfig         `yaml:"parent,omitempty"`
+	Untagged bool
+	Ignored  string `yaml:"-"`
+	private  string
+}
+
+func (c *testConfig) ExtendJSONSchema(s *Schema) {
+	s.Properties["kind"].Enum = []string{"a", "b"}
+}
+
+type testSettings map[string]any
+
+func (testSettings) ExtendJSONSchema(s *Schema) {
+	s.Properties = map[string]*Schema{"retries": {Type: "integer"}}
+}
+
+func Test_Generate_DescribesFieldsFromYamlTags(t *testing.T) {
+	s := Generate(&testConfig{}, "test")
+
+	assert := assert.New(t)
+	assert.Equal(Draft, s.Schema)
+	assert.Equal("test", s.Title)
+	assert.Equal("object", s.Type)
+	assert.Equal(false, s.AdditionalProperties)
+	assert.Equal([]string{"kind", "untagged"}, s.Required)
+	assert.ElementsMatch([]string{"kind", "refs", "byName", "parent", "untagged"}, propertyNames(s))
+
+	assert.Equal([]string{"a", "b"}, s.Properties["kind"].Enum)
+	assert.Equal(&Schema{Type: "array", Items: &Schema{Ref: "#/$defs/testRef"}}, s.Properties["refs"])
+	assert.Equal(&Schema{Type: "object", AdditionalProperties: &Schema{Ref: "#/$defs/testRef"}}, s.Properties["byName"])
+	assert.Equal(&Schema{Ref: "#/$defs/testConfig"}, s.Properties["parent"])
+	assert.Len(s.Properties["untagged"].AnyOf, 2)
+
+	ref := s.Defs["testRef"]
+	assert.ElementsMatch([]string{"stage", "name", "port"}, propertyNames(ref))
+	assert.Equal([]string{"name"}, ref.Required)
+	assert.Equal("integer", ref.Properties["port"].AnyOf[0].Type)
+	assert.Equal(ReferencePattern, ref.Properties["port"].AnyOf[1].Pattern)
+
+	_, err := json.Marshal(s)
+	assert.NoError(err)
+}
+
+func Test_Generate_DefinesExtendedNamedTypes(t *testing.T) {
+	s := Generate(&struct {
+		Settings testSettings      `yaml:"settings"`
+		Plain    map[string]string `yaml:"plain"`
+	}{}, "test")
+
+	assert := assert.New(t)
+	assert.Equal(&Schema{Ref: "#/$defs/testSettings"}, s.Properties["settings"])
+	assert.Equal(&Schema{
+		Type:                 "object",
+		Properties:           map[string]*Schema{"retries": {Type: "integer"}},
+		AdditionalProperties: &Schema{},
+	}, s.Defs["testSettings"])
+	assert.Equal(&Schema{Type: "object", AdditionalProperties: &Schema{Type: "string"}}, s.Properties["plain"])
+}
@@ -0,0 +1,187 @@
+package json_schema
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"gopkg.in/yaml.v3"
+)
+
+type validator struct {
+	defs     map[string]*Schema
+	patterns map[string]*regexp.Regexp
+}
+
+// Validates the YAML node against the schema, reporting every value that
+// does not match the schema at its configuration path
+func (s *Schema) Validate(node *yaml.Node) error {
+	v := &validator{defs: s.Defs, patterns: map[string]*regexp.Regexp{}}
+	errs := []error{}
+	v.validate(s, node, "", &errs)
+	return errors.Join(errs...)
+}
+
+// Returns the JSON type of the value of a YAML node
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+
+	switch node.ShortTag() {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	default:
+		return "string"
+	}
+}
+
+func typeMatches(expected string, actual string) bool {
+	return expected == "" || expected == actual || (expected == "number" && actual == "integer")
+}
+
+// Describes the values accepted by the schema for error messages
+func (v *validator) describe(s *Schema) string {
+	s = v.resolve(s)
+	if s.Description != "" {
+		return s.Description
+	}
+	if len(s.AnyOf) > 0 {
+		descriptions := make([]string, len(s.AnyOf))
+		for i, option := range s.AnyOf {
+			descriptions[i] = v.describe(option)
+		}
+		return strings.Join(descriptions, " or ")
+	}
+	if s.Type == "" {
+		return "any value"
+	}
+	return s.Type
+}
+
+func (v *validator) resolve(s *Schema) *Schema {
+	for s.Ref != "" {
+		s = v.defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+	}
+	return s
+}
+
+func (v *validator) validate(s *Schema, node *yaml.Node, path string, errs *[]error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			v.validate(s, node.Content[0], path, errs)
+		}
+		return
+	case yaml.AliasNode:
+		v.validate(s, node.Alias, path, errs)
+		return
+	}
+
+	s = v.resolve(s)
+
+	if len(s.AnyOf) > 0 {
+		for _, option := range s.AnyOf {
+			optionErrs := []error{}
+			v.validate(option, node, path, &optionErrs)
+			if len(optionErrs) == 0 {
+				return
+			}
+		}
+		*errs = append(*errs, validation.Errorf(path, "expected %s, found %s", v.describe(s), nodeType(node)))
+		return
+	}
+
+	actual := nodeType(node)
+	if !typeMatches(s.Type, actual) {
+		*errs = append(*errs, validation.Errorf(path, "expected %s, found %s", s.Type, actual))
+		return
+	}
+
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, node.Value) {
+		*errs = append(*errs, validation.Errorf(path, "%s is not one of %s", node.Value, strings.Join(s.Enum, ", ")))
+	}
+
+	if s.Pattern != "" && !v.pattern(s.Pattern).MatchString(node.Value) {
+		*errs = append(*errs, validation.Errorf(path, "%s does not match %s", node.Value, s.Pattern))
+	}
+
+	switch actual {
+	case "object":
+		v.validateObject(s, node, path, errs)
+	case "array":
+		if s.Items != nil {
+			for i, item := range node.Content {
+				v.validate(s.Items, item, validation.JoinPath(path, fmt.Sprintf("[%d]", i)), errs)
+			}
+		}
+	}
+}
+
+func (v *validator) pattern(pattern string) *regexp.Regexp {
+	re, ok := v.patterns[pattern]
+	if !ok {
+		re = regexp.MustCompile(pattern)
+		v.patterns[pattern] = re
+	}
+	return re
+}
+
+func (v *validator) validateObject(s *Schema, node *yaml.Node, path string, errs *[]error) {
+	present := map[string]bool{}
+	merged := false
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		keyPath := validation.JoinPath(path, key)
+		present[key] = true
+
+		if key == "<<" {
+			// keys merged from anchors are not known until the node is decoded
+			merged = true
+			continue
+		}
+
+		if property, ok := s.Properties[key]; ok {
+			v.validate(property, value, keyPath, errs)
+			continue
+		}
+
+		switch additional := s.AdditionalProperties.(type) {
+		case *Schema:
+			v.validate(additional, value, keyPath, errs)
+		case bool:
+			if !additional {
+				*errs = append(*errs, validation.UnknownKey(keyPath, key, propertyNames(s)))
+			}
+		}
+	}
+
+	for _, required := range s.Required {
+		if !present[required] && !merged {
+			*errs = append(*errs, validation.Errorf(validation.JoinPath(path, required), "missing required key %s", required))
+		}
+	}
+}
+
+func propertyNames(s *Schema) []string {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
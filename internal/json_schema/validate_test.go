@@ -0,0 +1,82 @@
+package json_schema
+
+import (
+	"testing"
+
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func parseNode(t *testing.T, data string) *yaml.Node {
+	var node yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(data), &node))
+	return &node
+}
+
+func Test_Schema_Validate_AcceptsValidConfig(t *testing.T) {
+	node := parseNode(t, `
+kind: a
+untagged: ${env:FLAG}
+refs:
+  - name: x
+    port: 5432
+  - name: y
+    port: ${secret:aws_sm://db#port}
+    stage: AWSCURRENT
+byName:
+  z:
+    name: z
+parent:
+  kind: b
+  untagged: true
+`)
+	assert := assert.New(t)
+	assert.NoError(Generate(&testConfig{}, "test").Validate(node))
+}
+
+func Test_Schema_Validate_ReportsEveryMismatchWithPath(t *testing.T) {
+	node := parseNode(t, `
+kind: c
+refs:
+  - port: notaport
+  - name: [x]
+    stgae: AWSCURRENT
+byName:
+  z: notamapping
+parent:
+  untagged: true
+`)
+	errs := validation.Errors(Generate(&testConfig{}, "test").Validate(node))
+
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	assert := assert.New(t)
+	assert.Equal([]string{
+		"kind: c is not one of a, b",
+		"refs[0].port: expected integer or reference or environment variable, found string",
+		"refs[0].name: missing required key name",
+		"refs[1].name: expected string, found array",
+		"refs[1].stgae: unknown key stgae, did you mean stage?",
+		"byName.z: expected object, found string",
+		"parent.kind: missing required key kind",
+		"untagged: missing required key untagged",
+	}, messages)
+}
+
+func Test_Schema_Validate_AcceptsKeysMergedFromAnchors(t *testing.T) {
+	node := parseNode(t, `
+refs:
+  - &base
+    name: x
+  - <<: *base
+    port: 1
+kind: a
+untagged: false
+`)
+	assert := assert.New(t)
+	assert.NoError(Generate(&testConfig{}, "test").Validate(node))
+}
@@ -0,0 +1,76 @@
+package migration_lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Output format of the diagnostics
+type Format string
+
+const (
+	// One file:line: severity: message line per diagnostic
+	FormatText Format = "text"
+	// A JSON array of diagnostics
+	FormatJSON Format = "json"
+	// GitHub Actions workflow commands, shown as annotations on pull requests
+	FormatGitHub Format = "github"
+)
+
+// Validates that the format is known
+func (f Format) Validate() error {
+	switch f {
+	case FormatText, FormatJSON, FormatGitHub:
+		return nil
+	default:
+		return fmt.Errorf("unknown format %s, expected one of %s, %s, %s", f, FormatText, FormatJSON, FormatGitHub)
+	}
+}
+
+// Writes the diagnostics in the format
+func Write(w io.Writer, format Format, diagnostics []Diagnostic) error {
+	if err := format.Validate(); err != nil {
+		return err
+	}
+
+	if format == FormatJSON {
+		if diagnostics == nil {
+			diagnostics = []Diagnostic{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(diagnostics)
+	}
+
+	for _, d := range diagnostics {
+		line := d.String()
+		if format == FormatGitHub {
+			line = githubAnnotation(d)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Formats the diagnostic as a GitHub Actions workflow command, e.g
+// ::error file=db/V1__init.sql,line=3::message
+func githubAnnotation(d Diagnostic) string {
+	properties := "file=" + escapeProperty(d.File)
+	if d.Line > 0 {
+		properties += fmt.Sprintf(",line=%d", d.Line)
+	}
+	return fmt.Sprintf("::%s %s::%s", d.Severity, properties, escapeData(d.Message))
+}
+
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
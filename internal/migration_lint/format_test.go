@@ -0,0 +1,64 @@
+package migration_lint
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDiagnostics = []Diagnostic{
+	{File: "db/V1__init.sql", Line: 2, Severity: Error, Message: "invalid UTF-8"},
+	{File: "db/V3,a:b.sql", Severity: Warning, Message: "gap 100% <here>\nnext"},
+}
+
+func Test_Format_Validate_RejectsUnknownFormats(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(FormatText.Validate())
+	assert.NoError(FormatJSON.Validate())
+	assert.NoError(FormatGitHub.Validate())
+	assert.EqualError(Format("xml").Validate(), "unknown format xml, expected one of text, json, github")
+}
+
+func Test_Write_WritesText(t *testing.T) {
+	var b bytes.Buffer
+
+	assert := assert.New(t)
+	assert.NoError(Write(&b, FormatText, testDiagnostics[:1]))
+	assert.Equal("db/V1__init.sql:2: error: invalid UTF-8\n", b.String())
+}
+
+func Test_Write_WritesGitHubAnnotations(t *testing.T) {
+	var b bytes.Buffer
+
+	assert := assert.New(t)
+	assert.NoError(Write(&b, FormatGitHub, testDiagnostics))
+	assert.Equal("::error file=db/V1__init.sql,line=2::invalid UTF-8\n"+
+		"::warning file=db/V3%2Ca%3Ab.sql::gap 100%25 <here>%0Anext\n", b.String())
+}
+
+func Test_Write_WritesJSON(t *testing.T) {
+	var b bytes.Buffer
+
+	assert := assert.New(t)
+	assert.NoError(Write(&b, FormatJSON, testDiagnostics))
+	assert.JSONEq(`[
+		{"file": "db/V1__init.sql", "line": 2, "severity": "error", "message": "invalid UTF-8"},
+		{"file": "db/V3,a:b.sql", "severity": "warning", "message": "gap 100% <here>\nnext"}
+	]`, b.String())
+	assert.Contains(b.String(), "<here>")
+}
+
+func Test_Write_WritesEmptyJSONArray(t *testing.T) {
+	var b bytes.Buffer
+
+	assert := assert.New(t)
+	assert.NoError(Write(&b, FormatJSON, nil))
+	assert.Equal("[]\n", b.String())
+}
+
+func Test_Write_FailsOnUnknownFormat(t *testing.T) {
+	var b bytes.Buffer
+
+	assert.Error(t, Write(&b, Format("xml"), testDiagnostics))
+}
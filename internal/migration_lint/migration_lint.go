@@ -0,0 +1,414 @@
+package migration_lint
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity of a diagnostic. Errors are problems that make flyway fail or
+// skip the file, warnings are likely mistakes
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// A problem found in a migration file or the configuration of the migrations
+type Diagnostic struct {
+	File string `json:"file"`
+	// Line of the problem, 0 for problems with the file as a whole
+	Line int `json:"line,omitempty"`
+	// Configuration path of problems of the configuration, e.g
+	// schemas[0].placeholders[1]. File and Line are those of the config file,
+	// if located
+	Path     string   `json:"path,omitempty"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	file := d.File
+	if file == "" {
+		file = d.Path
+	}
+	if d.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", file, d.Line, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", file, d.Severity, d.Message)
+}
+
+// Returns the number of diagnostics with the severity
+func Count(diagnostics []Diagnostic, severity Severity) int {
+	n := 0
+	for _, d := range diagnostics {
+		if d.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Naming, encoding and placeholder syntax of migration files, configured by
+// the flyway settings of the same names
+type Naming struct {
+	SQLMigrationPrefix           string
+	UndoSQLMigrationPrefix       string
+	RepeatableSQLMigrationPrefix string
+	SQLMigrationSeparator        string
+	SQLMigrationSuffixes         []string
+	// Only files encoded as UTF-8 are checked for invalid characters
+	Encoding          string
+	PlaceholderPrefix string
+	PlaceholderSuffix string
+}
+
+// Returns the naming flyway uses by default
+func DefaultNaming() Naming {
+	return Naming{
+		SQLMigrationPrefix:           "V",
+		UndoSQLMigrationPrefix:       "U",
+		RepeatableSQLMigrationPrefix: "R",
+		SQLMigrationSeparator:        "__",
+		SQLMigrationSuffixes:         []string{".sql"},
+		Encoding:                     "UTF-8",
+		PlaceholderPrefix:            "${",
+		PlaceholderSuffix:            "}",
+	}
+}
+
+// Flyway callback events, which name callback files such as afterMigrate.sql
+var callbackEvents = []string{
+	"beforeMigrate", "beforeRepeatables", "beforeEachMigrate", "beforeEachMigrateStatement",
+	"afterEachMigrateStatement", "afterEachMigrateStatementError", "afterEachMigrate",
+	"afterEachMigrateError", "afterMigrate", "afterMigrateApplied", "afterVersioned", "afterMigrateError",
+	"beforeUndo", "beforeEachUndo", "beforeEachUndoStatement", "afterEachUndoStatement",
+	"afterEachUndoStatementError", "afterEachUndo", "afterEachUndoError", "afterUndo", "afterUndoError",
+	"beforeClean", "afterClean", "afterCleanError", "beforeInfo", "afterInfo", "afterInfoError",
+	"beforeValidate", "afterValidate", "afterValidateError", "beforeBaseline", "afterBaseline",
+	"afterBaselineError", "beforeRepair", "afterRepair", "afterRepairError", "beforeCreateSchema",
+	"beforeConnect", "afterConnect",
+}
+
+// Versions whose last part has at least this many digits are taken to be
+// timestamps, whose gaps are expected
+const timestampDigits = 8
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16BOMLE = []byte{0xff, 0xfe}
+	utf16BOMBE = []byte{0xfe, 0xff}
+)
+
+type kind int
+
+const (
+	versioned kind = iota
+	undo
+	repeatable
+	callback
+)
+
+type migration struct {
+	file        string
+	kind        kind
+	version     string
+	parts       []string
+	description string
+}
+
+type linter struct {
+	naming      Naming
+	migrations  []*migration
+	diagnostics []Diagnostic
+}
+
+// Lints the migration files in the directories and their subdirectories,
+// which flyway reads as the locations of a single schema. Versions are
+// checked across all directories
+func Lint(dirs []string, naming Naming) []Diagnostic {
+	l := &linter{naming: naming}
+
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				l.report(path, 0, Error, "failed to read: %v", err)
+				return nil
+			}
+			if !d.IsDir() {
+				l.file(path)
+			}
+			return nil
+		})
+		if err != nil {
+			l.report(dir, 0, Error, "failed to read: %v", err)
+		}
+	}
+	l.checkVersions()
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i], l.diagnostics[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return l.diagnostics
+}
+
+func (l *linter) report(file string, line int, severity Severity, format string, args ...any) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{File: file, Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) file(path string) {
+	name := filepath.Base(path)
+
+	suffix := l.naming.suffix(name)
+	if suffix == "" {
+		// files that are named like migrations are most likely meant to be run
+		ext := filepath.Ext(name)
+		if _, err := l.parse(path, strings.TrimSuffix(name, ext)); err == nil && ext != "" {
+			l.report(path, 0, Error, "unsupported extension %s, flyway only reads files ending with %s", ext, strings.Join(l.naming.SQLMigrationSuffixes, ", "))
+		}
+		return
+	}
+
+	m, err := l.parse(path, strings.TrimSuffix(name, suffix))
+	if err != nil {
+		l.report(path, 0, Error, "%v", err)
+	} else {
+		l.migrations = append(l.migrations, m)
+	}
+
+	l.checkContent(path)
+}
+
+// Returns the longest of the SQL migration suffixes the file name ends with,
+// or an empty string if it has none of them
+func (n Naming) suffix(name string) string {
+	suffix := ""
+	for _, s := range n.SQLMigrationSuffixes {
+		if strings.HasSuffix(name, s) && len(s) > len(suffix) {
+			suffix = s
+		}
+	}
+	return suffix
+}
+
+// Parses the name of a migration file without its suffix
+func (l *linter) parse(path string, name string) (*migration, error) {
+	n := l.naming
+	sep := n.SQLMigrationSeparator
+
+	if event, _, _ := strings.Cut(name, sep); slices.Contains(callbackEvents, event) {
+		return &migration{file: path, kind: callback}, nil
+	}
+
+	var k kind
+	var rest string
+	switch {
+	case strings.HasPrefix(name, n.SQLMigrationPrefix):
+		k, rest = versioned, strings.TrimPrefix(name, n.SQLMigrationPrefix)
+	case strings.HasPrefix(name, n.UndoSQLMigrationPrefix):
+		k, rest = undo, strings.TrimPrefix(name, n.UndoSQLMigrationPrefix)
+	case strings.HasPrefix(name, n.RepeatableSQLMigrationPrefix):
+		description, ok := strings.CutPrefix(strings.TrimPrefix(name, n.RepeatableSQLMigrationPrefix), sep)
+		if !ok {
+			return nil, fmt.Errorf("repeatable migration must be named %s%s<description>, without a version", n.RepeatableSQLMigrationPrefix, sep)
+		}
+		if description == "" {
+			return nil, fmt.Errorf("missing description after %s", sep)
+		}
+		return &migration{file: path, kind: repeatable, description: description}, nil
+	default:
+		return nil, fmt.Errorf("not named like a versioned (%s1%sdescription), undo (%s1%sdescription) or repeatable (%s%sdescription) migration or a callback, flyway ignores it",
+			n.SQLMigrationPrefix, sep, n.UndoSQLMigrationPrefix, sep, n.RepeatableSQLMigrationPrefix, sep)
+	}
+
+	version, description, found := strings.Cut(rest, sep)
+	if !found {
+		return nil, fmt.Errorf("missing separator %s between version and description", sep)
+	}
+	parts := strings.FieldsFunc(version, func(r rune) bool { return r == '.' || r == '_' })
+	if version == "" || !validVersion(version, parts) {
+		return nil, fmt.Errorf("invalid version %q, expected numbers separated by . or _", version)
+	}
+	if description == "" {
+		return nil, fmt.Errorf("missing description after %s", sep)
+	}
+
+	return &migration{file: path, kind: k, version: version, parts: parts, description: description}, nil
+}
+
+func validVersion(version string, parts []string) bool {
+	// separators must be between numbers, e.g not 1..2
+	if strings.Count(version, ".")+strings.Count(version, "_") != len(parts)-1 {
+		return false
+	}
+	for _, part := range parts {
+		if strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks the encoding and content of a migration file
+func (l *linter) checkContent(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.report(path, 0, Error, "failed to read: %v", err)
+		return
+	}
+
+	switch {
+	case bytes.HasPrefix(data, utf16BOMLE) || bytes.HasPrefix(data, utf16BOMBE):
+		l.report(path, 0, Error, "file is encoded as UTF-16, expected %s", l.encoding())
+		return
+	case bytes.HasPrefix(data, utf8BOM):
+		l.report(path, 1, Warning, "file starts with a UTF-8 byte order mark")
+		data = data[len(utf8BOM):]
+	}
+
+	if l.isUTF8() {
+		for i, line := range bytes.Split(data, []byte("\n")) {
+			if !utf8.Valid(line) {
+				l.report(path, i+1, Error, "invalid UTF-8, the file is not encoded as %s", l.encoding())
+				break
+			}
+		}
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		l.report(path, 0, Warning, "empty migration file")
+	}
+}
+
+func (l *linter) encoding() string {
+	if l.naming.Encoding == "" {
+		return "UTF-8"
+	}
+	return l.naming.Encoding
+}
+
+func (l *linter) isUTF8() bool {
+	return strings.EqualFold(strings.ReplaceAll(l.encoding(), "-", ""), "utf8")
+}
+
+// Checks for duplicate, ambiguous and missing versions and duplicate
+// repeatable migrations
+func (l *linter) checkVersions() {
+	seen := map[string]*migration{}
+	versions := map[string]bool{}
+	descriptions := map[string]*migration{}
+	ordered := []*migration{}
+
+	for _, m := range l.migrations {
+		switch m.kind {
+		case versioned, undo:
+			key := fmt.Sprintf("%d:%s", m.kind, normalizeVersion(m.parts))
+			if first, ok := seen[key]; ok {
+				if first.version == m.version {
+					l.report(m.file, 0, Error, "duplicate version %s, also used by %s", m.version, first.file)
+				} else {
+					l.report(m.file, 0, Error, "version %s is the same as version %s of %s", m.version, first.version, first.file)
+				}
+				continue
+			}
+			seen[key] = m
+			if m.kind == versioned {
+				versions[normalizeVersion(m.parts)] = true
+				ordered = append(ordered, m)
+			}
+		case repeatable:
+			if first, ok := descriptions[m.description]; ok {
+				l.report(m.file, 0, Error, "duplicate repeatable migration %s, also used by %s", m.description, first.file)
+				continue
+			}
+			descriptions[m.description] = m
+		}
+	}
+
+	for _, m := range l.migrations {
+		if m.kind == undo && !versions[normalizeVersion(m.parts)] {
+			l.report(m.file, 0, Warning, "undo migration of version %s has no versioned migration", m.version)
+		}
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return compareVersions(ordered[i].parts, ordered[j].parts) < 0
+	})
+	for i := 1; i < len(ordered); i++ {
+		if previous, m := ordered[i-1], ordered[i]; hasGap(normalizeVersion(previous.parts), normalizeVersion(m.parts)) {
+			l.report(m.file, 0, Warning, "gap between version %s and %s", previous.version, m.version)
+		}
+	}
+}
+
+// Returns the version without leading zeros and trailing zero parts, as
+// flyway compares versions, e.g 1.0 and 01 are both 1
+func normalizeVersion(parts []string) string {
+	normalized := make([]string, len(parts))
+	for i, part := range parts {
+		normalized[i] = trimZeros(part)
+	}
+	for len(normalized) > 1 && normalized[len(normalized)-1] == "0" {
+		normalized = normalized[:len(normalized)-1]
+	}
+	return strings.Join(normalized, ".")
+}
+
+func trimZeros(part string) string {
+	if trimmed := strings.TrimLeft(part, "0"); trimmed != "" {
+		return trimmed
+	}
+	return "0"
+}
+
+func compareVersions(a, b []string) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		x, y := "0", "0"
+		if i < len(a) {
+			x = trimZeros(a[i])
+		}
+		if i < len(b) {
+			y = trimZeros(b[i])
+		}
+		if len(x) != len(y) {
+			return len(x) - len(y)
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Reports whether versions are missing between the consecutive normalized
+// versions, which only differ in their last part, e.g 1.2 and 1.4.
+// Timestamps are expected to have gaps
+func hasGap(previous, next string) bool {
+	previousPrefix, previousLast := cutLast(previous)
+	nextPrefix, nextLast := cutLast(next)
+	if previousPrefix != nextPrefix || len(nextLast) >= timestampDigits {
+		return false
+	}
+
+	a, errA := strconv.ParseUint(previousLast, 10, 64)
+	b, errB := strconv.ParseUint(nextLast, 10, 64)
+	return errA == nil && errB == nil && b > a+1
+}
+
+// Splits the version before its last part
+func cutLast(version string) (string, string) {
+	i := strings.LastIndex(version, ".")
+	return version[:i+1], version[i+1:]
+}
@@ -0,0 +1,201 @@
+package migration_lint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes the files into a temporary directory and returns the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Returns the messages of the diagnostics by file name
+func messages(diagnostics []Diagnostic) map[string][]string {
+	m := map[string][]string{}
+	for _, d := range diagnostics {
+		name := filepath.Base(d.File)
+		m[name] = append(m[name], string(d.Severity)+": "+d.Message)
+	}
+	return m
+}
+
+func Test_Lint_AcceptsValidMigrations(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"V1__init.sql":           "CREATE TABLE a();",
+		"V1.1__add_b.sql":        "CREATE TABLE b();",
+		"V1_2__add_c.sql":        "CREATE TABLE c();",
+		"nested/V2__nested.sql":  "CREATE TABLE d();",
+		"U2__nested.sql":         "DROP TABLE d;",
+		"R__views.sql":           "CREATE VIEW v AS SELECT 1;",
+		"afterMigrate.sql":       "SELECT 1;",
+		"beforeMigrate__log.sql": "SELECT 1;",
+		"README.md":              "# migrations",
+	})
+
+	assert.Empty(t, Lint([]string{dir}, DefaultNaming()))
+}
+
+func Test_Lint_ReportsInvalidNames(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"init.sql":          "SELECT 1;",
+		"V1_init.sql":       "SELECT 1;",
+		"Vx__init.sql":      "SELECT 1;",
+		"V1..2__init.sql":   "SELECT 1;",
+		"V2__.sql":          "SELECT 1;",
+		"R1__views.sql":     "SELECT 1;",
+		"V3__add_table.txt": "SELECT 1;",
+		"notes.txt":         "notes",
+	})
+
+	assert := assert.New(t)
+	assert.Equal(map[string][]string{
+		"init.sql":          {"error: not named like a versioned (V1__description), undo (U1__description) or repeatable (R__description) migration or a callback, flyway ignores it"},
+		"V1_init.sql":       {"error: missing separator __ between version and description"},
+		"Vx__init.sql":      {`error: invalid version "x", expected numbers separated by . or _`},
+		"V1..2__init.sql":   {`error: invalid version "1..2", expected numbers separated by . or _`},
+		"V2__.sql":          {"error: missing description after __"},
+		"R1__views.sql":     {"error: repeatable migration must be named R__<description>, without a version"},
+		"V3__add_table.txt": {"error: unsupported extension .txt, flyway only reads files ending with .sql"},
+	}, messages(Lint([]string{dir}, DefaultNaming())))
+}
+
+func Test_Lint_UsesNaming(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"M1-init.psql": "SELECT 1;",
+		"M2-add.sql":   "SELECT 1;",
+		"V3__init.sql": "SELECT 1;",
+	})
+	naming := DefaultNaming()
+	naming.SQLMigrationPrefix = "M"
+	naming.SQLMigrationSeparator = "-"
+	naming.SQLMigrationSuffixes = []string{".psql"}
+
+	assert.Equal(t, map[string][]string{
+		"M2-add.sql": {"error: unsupported extension .sql, flyway only reads files ending with .psql"},
+	}, messages(Lint([]string{dir}, naming)))
+}
+
+func Test_Lint_ReportsDuplicateVersionsAcrossDirectories(t *testing.T) {
+	first := writeFiles(t, map[string]string{
+		"V1__init.sql":     "SELECT 1;",
+		"R__views.sql":     "SELECT 1;",
+		"V2__add_a.sql":    "SELECT 1;",
+		"U2__add_a.sql":    "SELECT 1;",
+		"U5__missing.sql":  "SELECT 1;",
+		"V3__add_b.sql":    "SELECT 1;",
+		"V1_0_1__fix.sql":  "SELECT 1;",
+		"V1.0.01__fix.sql": "SELECT 1;",
+	})
+	second := writeFiles(t, map[string]string{
+		"V1__again.sql": "SELECT 1;",
+		"V02__b.sql":    "SELECT 1;",
+		"R__views.sql":  "SELECT 1;",
+	})
+
+	diagnostics := Lint([]string{first, second}, DefaultNaming())
+	assert := assert.New(t)
+	assert.Equal(map[string][]string{
+		"V1_0_1__fix.sql": {"error: version 1_0_1 is the same as version 1.0.01 of " + filepath.Join(first, "V1.0.01__fix.sql")},
+		"V1__again.sql":   {"error: duplicate version 1, also used by " + filepath.Join(first, "V1__init.sql")},
+		"V02__b.sql":      {"error: version 02 is the same as version 2 of " + filepath.Join(first, "V2__add_a.sql")},
+		"R__views.sql":    {"error: duplicate repeatable migration views, also used by " + filepath.Join(first, "R__views.sql")},
+		"U5__missing.sql": {"warning: undo migration of version 5 has no versioned migration"},
+	}, messages(diagnostics))
+}
+
+func Test_Lint_ReportsGapsExceptBetweenTimestamps(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"V1__a.sql":              "SELECT 1;",
+		"V1.0__b.sql":            "SELECT 1;",
+		"V4__c.sql":              "SELECT 1;",
+		"V4.1__d.sql":            "SELECT 1;",
+		"V4.3__e.sql":            "SELECT 1;",
+		"V5__f.sql":              "SELECT 1;",
+		"V20240101120000__g.sql": "SELECT 1;",
+		"V20240301120000__h.sql": "SELECT 1;",
+	})
+
+	diagnostics := Lint([]string{dir}, DefaultNaming())
+	assert := assert.New(t)
+	assert.Equal(map[string][]string{
+		"V1__a.sql":   {"error: version 1 is the same as version 1.0 of " + filepath.Join(dir, "V1.0__b.sql")},
+		"V4__c.sql":   {"warning: gap between version 1.0 and 4"},
+		"V4.3__e.sql": {"warning: gap between version 4.1 and 4.3"},
+	}, messages(diagnostics))
+}
+
+func Test_Lint_ReportsEncodingAndEmptyFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"V1__utf16.sql": "\xff\xfeS\x00",
+		"V2__bom.sql":   "\xef\xbb\xbfSELECT 1;",
+		"V3__latin.sql": "SELECT 1;\nSELECT 'caf\xe9';\n",
+		"V4__empty.sql": " \n",
+	})
+
+	diagnostics := Lint([]string{dir}, DefaultNaming())
+	assert := assert.New(t)
+	assert.Equal(map[string][]string{
+		"V1__utf16.sql": {"error: file is encoded as UTF-16, expected UTF-8"},
+		"V2__bom.sql":   {"warning: file starts with a UTF-8 byte order mark"},
+		"V3__latin.sql": {"error: invalid UTF-8, the file is not encoded as UTF-8"},
+		"V4__empty.sql": {"warning: empty migration file"},
+	}, messages(diagnostics))
+	for _, d := range diagnostics {
+		switch filepath.Base(d.File) {
+		case "V2__bom.sql":
+			assert.Equal(1, d.Line)
+		case "V3__latin.sql":
+			assert.Equal(2, d.Line)
+		default:
+			assert.Equal(0, d.Line)
+		}
+	}
+}
+
+func Test_Lint_SkipsUTF8ChecksForOtherEncodings(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"V1__latin.sql": "SELECT 'caf\xe9';"})
+	naming := DefaultNaming()
+	naming.Encoding = "ISO-8859-1"
+
+	assert.Empty(t, Lint([]string{dir}, naming))
+}
+
+func Test_Lint_ReportsMissingDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+
+	diagnostics := Lint([]string{dir}, DefaultNaming())
+	assert := assert.New(t)
+	assert.Len(diagnostics, 1)
+	assert.Equal(dir, diagnostics[0].File)
+	assert.Equal(Error, diagnostics[0].Severity)
+	assert.Contains(diagnostics[0].Message, "failed to read")
+}
+
+func Test_Diagnostic_String_IncludesLine(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("a.sql:3: error: bad", Diagnostic{File: "a.sql", Line: 3, Severity: Error, Message: "bad"}.String())
+	assert.Equal("a.sql: warning: bad", Diagnostic{File: "a.sql", Severity: Warning, Message: "bad"}.String())
+	assert.Equal("schemas[0]: warning: bad", Diagnostic{Path: "schemas[0]", Severity: Warning, Message: "bad"}.String())
+}
+
+func Test_Count_CountsSeverity(t *testing.T) {
+	diagnostics := []Diagnostic{{Severity: Error}, {Severity: Warning}, {Severity: Error}}
+
+	assert := assert.New(t)
+	assert.Equal(2, Count(diagnostics, Error))
+	assert.Equal(1, Count(diagnostics, Warning))
+}
@@ -0,0 +1,60 @@
+package migration_lint
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Prefix of the placeholders flyway provides itself, e.g ${flyway:defaultSchema}
+const builtinPlaceholderPrefix = "flyway:"
+
+// A reference to a placeholder in a migration file
+type PlaceholderReference struct {
+	File string
+	Line int
+	Name string
+}
+
+// Returns the references to placeholders in the migration and callback files
+// in the directories and their subdirectories, except those to the built-in
+// placeholders of flyway. Files that cannot be read are skipped, Lint reports them
+func Placeholders(dirs []string, naming Naming) []PlaceholderReference {
+	pattern := regexp.MustCompile(regexp.QuoteMeta(naming.PlaceholderPrefix) + `(.+?)` + regexp.QuoteMeta(naming.PlaceholderSuffix))
+	references := []PlaceholderReference{}
+
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error { //nolint:errcheck
+			if err != nil || d.IsDir() || naming.suffix(d.Name()) == "" {
+				return nil
+			}
+			references = append(references, placeholderReferences(path, pattern)...)
+			return nil
+		})
+	}
+
+	return references
+}
+
+func placeholderReferences(path string, pattern *regexp.Regexp) []PlaceholderReference {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	references := []PlaceholderReference{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		for _, match := range pattern.FindAllStringSubmatch(scanner.Text(), -1) {
+			if name := match[1]; !strings.HasPrefix(name, builtinPlaceholderPrefix) {
+				references = append(references, PlaceholderReference{File: path, Line: line, Name: name})
+			}
+		}
+	}
+	return references
+}
@@ -0,0 +1,38 @@
+package migration_lint
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Placeholders_FindsReferences(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"V1__init.sql":        "CREATE SCHEMA ${flyway:defaultSchema};\nINSERT INTO t VALUES ('${a}', '${b.c}');\n-- ${a}\n",
+		"nested/R__views.sql": "CREATE VIEW ${view} AS SELECT 1;",
+		"notes.txt":           "${ignored}",
+	})
+
+	assert.ElementsMatch(t, []PlaceholderReference{
+		{File: filepath.Join(dir, "V1__init.sql"), Line: 2, Name: "a"},
+		{File: filepath.Join(dir, "V1__init.sql"), Line: 2, Name: "b.c"},
+		{File: filepath.Join(dir, "V1__init.sql"), Line: 3, Name: "a"},
+		{File: filepath.Join(dir, "nested", "R__views.sql"), Line: 1, Name: "view"},
+	}, Placeholders([]string{dir}, DefaultNaming()))
+}
+
+func Test_Placeholders_UsesPrefixAndSuffix(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"V1__init.sql": "SELECT '${a}', '#[b]';"})
+	naming := DefaultNaming()
+	naming.PlaceholderPrefix = "#["
+	naming.PlaceholderSuffix = "]"
+
+	assert.Equal(t, []PlaceholderReference{
+		{File: filepath.Join(dir, "V1__init.sql"), Line: 1, Name: "b"},
+	}, Placeholders([]string{dir}, naming))
+}
+
+func Test_Placeholders_SkipsMissingDirectories(t *testing.T) {
+	assert.Empty(t, Placeholders([]string{filepath.Join(t.TempDir(), "missing")}, DefaultNaming()))
+}
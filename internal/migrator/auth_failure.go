@@ -0,0 +1,28 @@
+package migrator
+
+import (
+	"regexp"
+)
+
+// Messages printed by flyway when the database rejects the credentials
+var authFailurePatterns = []*regexp.Regexp{
+	// PostgreSQL
+	regexp.MustCompile(`(?i)password authentication failed for user`),
+	regexp.MustCompile(`(?i)SQL State\s*:\s*28(000|P01)`),
+	// MySQL and MariaDB
+	regexp.MustCompile(`(?i)Access denied for user`),
+	// SQL Server
+	regexp.MustCompile(`(?i)Login failed for user`),
+	// Oracle
+	regexp.MustCompile(`ORA-01017`),
+}
+
+// Returns true if the flyway output shows that the database rejected the credentials
+func isAuthFailure(output []byte) bool {
+	for _, p := range authFailurePatterns {
+		if p.Match(output) {
+			return true
+		}
+	}
+	return false
+}
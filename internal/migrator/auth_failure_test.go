@@ -0,0 +1,33 @@
+package migrator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_isAuthFailure_DetectsRejectedCredentials(t *testing.T) {
+	assert := assert.New(t)
+	for _, output := range []string{
+		`ERROR: Unable to obtain connection from database (jdbc:postgresql://localhost:5432/postgres) for user 'bob': FATAL: password authentication failed for user "bob"`,
+		"SQL State  : 28P01\nError Code : 0",
+		"SQL State  : 28000",
+		"Access denied for user 'bob'@'localhost' (using password: YES)",
+		"Login failed for user 'bob'.",
+		"ORA-01017: invalid username/password; logon denied",
+	} {
+		assert.True(isAuthFailure([]byte(output)), output)
+	}
+}
+
+func Test_isAuthFailure_IgnoresOtherFailures(t *testing.T) {
+	assert := assert.New(t)
+	for _, output := range []string{
+		"",
+		"ERROR: Migration V2__add_table.sql failed",
+		"SQL State  : 42P01",
+		`Connection to localhost:5432 refused`,
+	} {
+		assert.False(isAuthFailure([]byte(output)), output)
+	}
+}
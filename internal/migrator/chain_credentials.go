@@ -0,0 +1,87 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+
+	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
+
+// ChainCredentials is a list of credentials definitions that are tried in order.
+// The first definition that both validates and resolves is used.
+type ChainCredentials []*Credentials
+
+// Validate the structure of every credentials definition in the chain
+func (c *ChainCredentials) ValidateConfig() error {
+	if len(*c) == 0 {
+		return fmt.Errorf("%s credentials must contain at least one credentials definition", cp.ChainProviderType)
+	}
+
+	errs := make([]error, 0, len(*c))
+
+	for i, creds := range *c {
+		if creds == nil {
+			errs = append(errs, validation.Errorf(fmt.Sprintf("[%d]", i), "empty credentials definition"))
+			continue
+		}
+		if err := creds.ValidateConfig(); err != nil {
+			errs = append(errs, validation.AtPath(fmt.Sprintf("[%d]", i), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate that at least one of the credentials definitions in the chain is valid
+func (c *ChainCredentials) Validate() error {
+	if len(*c) == 0 {
+		return fmt.Errorf("%s credentials must contain at least one credentials definition", cp.ChainProviderType)
+	}
+
+	errs := make([]error, 0, len(*c))
+
+	for i, creds := range *c {
+		if creds == nil {
+			errs = append(errs, validation.Errorf(fmt.Sprintf("[%d]", i), "empty credentials definition"))
+			continue
+		}
+		err := creds.Validate()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, validation.AtPath(fmt.Sprintf("[%d]", i), err))
+	}
+
+	return errors.Join(append([]error{fmt.Errorf("none of the %s credentials are valid", cp.ChainProviderType)}, errs...)...)
+}
+
+// Returns the credentials of the first definition in the chain that resolves.
+//
+// If no definition resolves, the returned error contains every failed attempt
+func (c *ChainCredentials) GetCredentials() (*cp.DatabaseCredentials, error) {
+	errs := make([]error, 0, len(*c))
+
+	for i, creds := range *c {
+		if creds == nil {
+			errs = append(errs, validation.Errorf(fmt.Sprintf("[%d]", i), "empty credentials definition"))
+			continue
+		}
+		resolved, err := creds.FetchCredentials()
+		if err == nil {
+			return resolved, nil
+		}
+		errs = append(errs, validation.AtPath(fmt.Sprintf("[%d]", i), err))
+	}
+
+	return nil, errors.Join(append([]error{fmt.Errorf("none of the %s credentials could be resolved", cp.ChainProviderType)}, errs...)...)
+}
+
+// Drops the cached credentials of every definition in the chain
+func (c *ChainCredentials) Invalidate() {
+	for _, creds := range *c {
+		if creds != nil {
+			creds.Invalidate()
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package migrator
+
+import (
+	"testing"
+
+	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func testEnvCredentials() *Credentials {
+	return &Credentials{
+		Provider: string(cp.EnvProviderType),
+		CredentialProviders: CredentialProviders{
+			EnvProviderImpl: &cp.EnvDatabaseCredentials{
+				UsernameKey: "CHAIN_USER",
+				PasswordKey: "CHAIN_PASSWORD",
+				HostKey:     "CHAIN_HOST",
+				PortKey:     "CHAIN_PORT",
+				DatabaseKey: "CHAIN_DATABASE",
+			},
+		},
+	}
+}
+
+func Test_ChainCredentials_Validate_SucceedsWhenAnyEntryValid(t *testing.T) {
+	c := &ChainCredentials{testEnvCredentials(), validTestCredentials()}
+	assert := assert.New(t)
+	assert.NoError(c.Validate())
+}
+
+func Test_ChainCredentials_Validate_FailsWhenEmpty(t *testing.T) {
+	c := &ChainCredentials{}
+	assert := assert.New(t)
+	assert.Error(c.Validate())
+}
+
+func Test_ChainCredentials_Validate_FailsWhenNoEntryValid(t *testing.T) {
+	invalid := validTestCredentials()
+	invalid.TextProviderImpl.Database = ""
+	c := &ChainCredentials{testEnvCredentials(), invalid, nil}
+
+	assert := assert.New(t)
+	err := c.Validate()
+	assert.Error(err)
+	assert.Contains(err.Error(), "[0].env: ")
+	assert.Contains(err.Error(), "[1].text.database: ")
+	assert.Contains(err.Error(), "[2]: empty credentials definition")
+}
+
+func Test_ChainCredentials_GetCredentials_UsesFirstResolvingEntry(t *testing.T) {
+	t.Setenv("CHAIN_USER", "envuser")
+	t.Setenv("CHAIN_PASSWORD", "envpass")
+	t.Setenv("CHAIN_HOST", "envhost")
+	t.Setenv("CHAIN_PORT", "1234")
+	t.Setenv("CHAIN_DATABASE", "envdb")
+
+	c := &ChainCredentials{testEnvCredentials(), validTestCredentials()}
+	creds, err := c.GetCredentials()
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(cp.DatabaseCredentials{
+		Username: "envuser",
+		Password: "envpass",
+		Host:     "envhost",
+		Port:     1234,
+		Database: "envdb",
+	}, *creds)
+}
+
+func Test_ChainCredentials_GetCredentials_FallsBackToNextEntry(t *testing.T) {
+	c := &ChainCredentials{testEnvCredentials(), validTestCredentials()}
+	creds, err := c.GetCredentials()
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(validTestCredentials().TextProviderImpl.DatabaseCredentials, *creds)
+}
+
+func Test_ChainCredentials_GetCredentials_ReportsEveryFailedAttempt(t *testing.T) {
+	invalid := validTestCredentials()
+	invalid.TextProviderImpl.Database = ""
+	c := &ChainCredentials{testEnvCredentials(), invalid}
+
+	_, err := c.GetCredentials()
+	assert := assert.New(t)
+	assert.Error(err)
+	assert.Contains(err.Error(), "[0].env: environment variable CHAIN_USER")
+	assert.Contains(err.Error(), "[1].text.database: missing 'database' key")
+}
+
+func Test_Credentials_Validate_ChainCredentialsFromYaml(t *testing.T) {
+	data := `
+provider: chain
+chain:
+  - provider: env
+    env:
+      usernameKey: CHAIN_USER
+      passwordKey: CHAIN_PASSWORD
+      hostKey: CHAIN_HOST
+      portKey: CHAIN_PORT
+      databaseKey: CHAIN_DATABASE
+  - provider: text
+    text:
+      username: a
+      password: a
+      host: a
+      port: 5432
+      database: a
+`
+	c := &Credentials{}
+	assert := assert.New(t)
+	assert.NoError(yaml.Unmarshal([]byte(data), c))
+	assert.Len(*c.ChainProviderImpl, 2)
+
+	creds, err := c.FetchCredentials()
+	assert.NoError(err)
+	assert.Equal("a", creds.Username)
+}
+
+func Test_Credentials_Validate_ChainCredentialsFailsIfNoImpl(t *testing.T) {
+	c := Credentials{
+		Provider:            string(cp.ChainProviderType),
+		CredentialProviders: CredentialProviders{},
+	}
+	assert := assert.New(t)
+	assert.Error(c.Validate())
+}
+
+func Test_ChainCredentials_ValidateConfig_ReportsEveryInvalidEntry(t *testing.T) {
+	invalid := validTestCredentials()
+	invalid.TextProviderImpl.Database = ""
+	c := &ChainCredentials{testEnvCredentials(), invalid, nil}
+
+	assert := assert.New(t)
+	err := c.ValidateConfig()
+	assert.Error(err)
+	assert.NotContains(err.Error(), "[0]")
+	assert.Contains(err.Error(), "[1].text.database: ")
+	assert.Contains(err.Error(), "[2]: empty credentials definition")
+}
@@ -4,12 +4,16 @@ import (
 	"fmt"
 
 	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	js "github.com/sourcehawk/go-flyway/internal/json_schema"
+	"github.com/sourcehawk/go-flyway/internal/validation"
 )
 
 type CredentialProviders struct {
-	EnvProviderImpl   *cp.EnvDatabaseCredentials   `yaml:"env,omitempty"`
-	TextProviderImpl  *cp.TextDatabaseCredentials  `yaml:"text,omitempty"`
-	AwssmProviderImpl *cp.AWSSMDatabaseCredentials `yaml:"aws_sm,omitempty"`
+	EnvProviderImpl       *cp.EnvDatabaseCredentials       `yaml:"env,omitempty"`
+	TextProviderImpl      *cp.TextDatabaseCredentials      `yaml:"text,omitempty"`
+	AwssmProviderImpl     *cp.AWSSMDatabaseCredentials     `yaml:"aws_sm,omitempty"`
+	ChainProviderImpl     *ChainCredentials                `yaml:"chain,omitempty"`
+	CompositeProviderImpl *cp.CompositeDatabaseCredentials `yaml:"composite,omitempty"`
 }
 
 type Credentials struct {
@@ -19,9 +23,21 @@ type Credentials struct {
 	credentials         *cp.DatabaseCredentials
 }
 
-func (c *Credentials) Validate() error {
+// Restricts the provider to the types of the provider blocks
+func (c *Credentials) ExtendJSONSchema(s *js.Schema) {
+	s.Properties["provider"].Enum = []string{
+		string(cp.TextProviderType),
+		string(cp.EnvProviderType),
+		string(cp.AWSSMProviderType),
+		string(cp.ChainProviderType),
+		string(cp.CompositeProviderType),
+	}
+}
+
+// Selects the configured provider implementation
+func (c *Credentials) selectProvider() error {
 	if c.Provider == "" {
-		return fmt.Errorf("missing 'provider' key for database credentials")
+		return validation.Errorf("provider", "missing 'provider' key for database credentials")
 	}
 
 	p := cp.CredentialsProviderType(c.Provider)
@@ -29,27 +45,55 @@ func (c *Credentials) Validate() error {
 	switch p {
 	case cp.TextProviderType:
 		if c.TextProviderImpl == nil {
-			return fmt.Errorf("could not find credentials configuration for provider %s", c.Provider)
+			return validation.Errorf(c.Provider, "could not find credentials configuration for provider %s", c.Provider)
 		}
 		c.concreteProvider = c.TextProviderImpl
 	case cp.EnvProviderType:
 		if c.EnvProviderImpl == nil {
-			return fmt.Errorf("could not find credentials configuration for provider %s", c.Provider)
+			return validation.Errorf(c.Provider, "could not find credentials configuration for provider %s", c.Provider)
 		}
 		c.concreteProvider = c.EnvProviderImpl
 	case cp.AWSSMProviderType:
 		if c.AwssmProviderImpl == nil {
-			return fmt.Errorf("could not find credentials configuration for provider %s", c.Provider)
+			return validation.Errorf(c.Provider, "could not find credentials configuration for provider %s", c.Provider)
 		}
 		c.concreteProvider = c.AwssmProviderImpl
+	case cp.ChainProviderType:
+		if c.ChainProviderImpl == nil {
+			return validation.Errorf(c.Provider, "could not find credentials configuration for provider %s", c.Provider)
+		}
+		c.concreteProvider = c.ChainProviderImpl
+	case cp.CompositeProviderType:
+		if c.CompositeProviderImpl == nil {
+			return validation.Errorf(c.Provider, "could not find credentials configuration for provider %s", c.Provider)
+		}
+		c.concreteProvider = c.CompositeProviderImpl
 	default:
-		return fmt.Errorf("%s is not a valid credentials provider type", c.Provider)
+		return validation.Errorf("provider", "%s is not a valid credentials provider type", c.Provider)
 	}
 
-	if err := c.concreteProvider.Validate(); err != nil {
+	return nil
+}
+
+// Validates the structure of the credentials configuration without resolving
+// any values or contacting secret stores
+func (c *Credentials) ValidateConfig() error {
+	if err := c.selectProvider(); err != nil {
+		return err
+	}
+
+	return validation.AtPath(c.Provider, c.concreteProvider.ValidateConfig())
+}
+
+func (c *Credentials) Validate() error {
+	if err := c.selectProvider(); err != nil {
 		return err
 	}
 
+	if err := c.concreteProvider.Validate(); err != nil {
+		return validation.AtPath(c.Provider, err)
+	}
+
 	return nil
 }
 
@@ -79,3 +123,45 @@ func (c *Credentials) FetchCredentials() (*cp.DatabaseCredentials, error) {
 
 	return c.fetchCredentials()
 }
+
+// Drops the cached credentials, and those of the underlying provider,
+// so that they are fetched again on the next call to FetchCredentials
+func (c *Credentials) Invalidate() {
+	c.credentials = nil
+	if p, ok := c.concreteProvider.(cp.RefreshableCredentialsProvider); ok {
+		p.Invalidate()
+	}
+}
+
+// Returns credentials to retry with after the given credentials were rejected
+// by the database, e.g because the secret was rotated during the run.
+//
+// Refetches the credentials, and if they did not change, tries the fallback
+// credentials of the provider. Fails if no different credentials are found
+func (c *Credentials) refreshCredentials(rejected *cp.DatabaseCredentials) (*cp.DatabaseCredentials, error) {
+	c.Invalidate()
+
+	// the provider was validated when the rejected credentials were fetched
+	fresh, err := c.fetchCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh rejected credentials: %w", err)
+	}
+	if *fresh != *rejected {
+		return fresh, nil
+	}
+
+	if p, ok := c.concreteProvider.(cp.FallbackCredentialsProvider); ok {
+		fallbacks, err := p.FallbackCredentials()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch fallback credentials: %w", err)
+		}
+		for _, fallback := range fallbacks {
+			if *fallback != *rejected {
+				c.credentials = fallback
+				return fallback, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("credentials were rejected and no other credentials are available")
+}
@@ -38,6 +38,11 @@ func (m *MockCredentialsProvider) GetCredentials() (*cp.DatabaseCredentials, err
 	return args.Get(0).(*cp.DatabaseCredentials), args.Error(1)
 }
 
+func (m *MockCredentialsProvider) ValidateConfig() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
 func (m *MockCredentialsProvider) Validate() error {
 	args := m.Called()
 	return args.Error(0)
@@ -121,7 +126,7 @@ env:
 }
 
 func Test_Credentials_Validate_AWSSMDatabaseCredentials(t *testing.T) {
-	cp.NewAWSSecretsManager = func() (*sp.AWSSecretsManager, error) {
+	cp.NewAWSSecretsManager = func(sp.AWSClientConfig) (*sp.AWSSecretsManager, error) {
 		return &sp.AWSSecretsManager{}, nil
 	}
 	c := Credentials{
@@ -324,3 +329,139 @@ func Test_Credentials_FetchCredentials_FailsOnValidationError(t *testing.T) {
 	_, err := c.FetchCredentials()
 	assert.Error(err)
 }
+
+func Test_Credentials_Validate_CompositeDatabaseCredentialsFromYaml(t *testing.T) {
+	t.Setenv("COMPOSITE_USER", "user")
+	data := `
+provider: composite
+composite:
+  username:
+    env: COMPOSITE_USER
+  password:
+    value: pass
+  host:
+    value: localhost
+  port:
+    value: 5432
+  database:
+    value: postgres
+`
+
+	c := &Credentials{}
+	assert := assert.New(t)
+	assert.NoError(yaml.Unmarshal([]byte(data), c))
+	creds, err := c.FetchCredentials()
+	assert.NoError(err)
+	assert.Equal(cp.DatabaseCredentials{
+		Username: "user",
+		Password: "pass",
+		Host:     "localhost",
+		Port:     5432,
+		Database: "postgres",
+	}, *creds)
+}
+
+func Test_Credentials_Validate_CompositeDatabaseCredentialsFailsIfNoImpl(t *testing.T) {
+	c := Credentials{
+		Provider:            string(cp.CompositeProviderType),
+		CredentialProviders: CredentialProviders{},
+	}
+	assert := assert.New(t)
+	assert.Error(c.Validate())
+}
+
+type MockRefreshableCredentialsProvider struct {
+	MockCredentialsProvider
+}
+
+func (m *MockRefreshableCredentialsProvider) Invalidate() {
+	m.Called()
+}
+
+func (m *MockRefreshableCredentialsProvider) FallbackCredentials() ([]*cp.DatabaseCredentials, error) {
+	args := m.Called()
+	return args.Get(0).([]*cp.DatabaseCredentials), args.Error(1)
+}
+
+// Credentials backed by the given provider, with the rejected credentials already fetched
+func rejectedTestCredentials(t *testing.T, provider cp.DatabaseCredentialsProvider) (*Credentials, *cp.DatabaseCredentials) {
+	c := validTestCredentials()
+	assert.NoError(t, c.Validate())
+	c.concreteProvider = provider
+	rejected, err := c.fetchCredentials()
+	assert.NoError(t, err)
+	return c, rejected
+}
+
+func Test_Credentials_refreshCredentials_ReturnsRefetchedCredentials(t *testing.T) {
+	provider := new(MockRefreshableCredentialsProvider)
+	provider.On("GetCredentials").Return(&cp.DatabaseCredentials{Password: "old"}, nil).Once()
+	provider.On("GetCredentials").Return(&cp.DatabaseCredentials{Password: "new"}, nil).Once()
+	provider.On("Invalidate").Return()
+	c, rejected := rejectedTestCredentials(t, provider)
+
+	fresh, err := c.refreshCredentials(rejected)
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("new", fresh.Password)
+	provider.AssertCalled(t, "Invalidate")
+	provider.AssertNotCalled(t, "FallbackCredentials")
+
+	cached, err := c.fetchCredentials()
+	assert.NoError(err)
+	assert.Same(fresh, cached)
+}
+
+func Test_Credentials_refreshCredentials_TriesFallbackCredentialsWhenUnchanged(t *testing.T) {
+	provider := new(MockRefreshableCredentialsProvider)
+	provider.On("GetCredentials").Return(&cp.DatabaseCredentials{Password: "old"}, nil)
+	provider.On("Invalidate").Return()
+	provider.On("FallbackCredentials").Return([]*cp.DatabaseCredentials{{Password: "old"}, {Password: "pending"}}, nil)
+	c, rejected := rejectedTestCredentials(t, provider)
+
+	fresh, err := c.refreshCredentials(rejected)
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal("pending", fresh.Password)
+
+	cached, err := c.fetchCredentials()
+	assert.NoError(err)
+	assert.Same(fresh, cached)
+}
+
+func Test_Credentials_refreshCredentials_FailsWhenNoOtherCredentials(t *testing.T) {
+	provider := new(MockCredentialsProvider)
+	provider.On("GetCredentials").Return(&cp.DatabaseCredentials{Password: "old"}, nil)
+	c, rejected := rejectedTestCredentials(t, provider)
+
+	_, err := c.refreshCredentials(rejected)
+	assert := assert.New(t)
+	assert.EqualError(err, "credentials were rejected and no other credentials are available")
+	provider.AssertNumberOfCalls(t, "GetCredentials", 2)
+}
+
+func Test_Credentials_refreshCredentials_FailsWhenRefetchFails(t *testing.T) {
+	provider := new(MockRefreshableCredentialsProvider)
+	provider.On("GetCredentials").Return(&cp.DatabaseCredentials{Password: "old"}, nil).Once()
+	provider.On("GetCredentials").Return(&cp.DatabaseCredentials{}, fmt.Errorf("test error 123")).Once()
+	provider.On("Invalidate").Return()
+	c, rejected := rejectedTestCredentials(t, provider)
+
+	_, err := c.refreshCredentials(rejected)
+	assert := assert.New(t)
+	assert.ErrorContains(err, "test error 123")
+}
+
+func Test_Credentials_ValidateConfig_DoesNotResolveCredentials(t *testing.T) {
+	c := testEnvCredentials()
+	assert := assert.New(t)
+	assert.NoError(c.ValidateConfig())
+	assert.Error(c.Validate())
+}
+
+func Test_Credentials_ValidateConfig_FailsIfInvalidProviderSpecified(t *testing.T) {
+	c := validTestCredentials()
+	c.Provider = "unknown"
+	assert := assert.New(t)
+	assert.EqualError(c.ValidateConfig(), "provider: unknown is not a valid credentials provider type")
+}
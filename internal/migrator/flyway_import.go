@@ -0,0 +1,339 @@
+package migrator
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sourcehawk/go-flyway/internal/config"
+	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	fc "github.com/sourcehawk/go-flyway/internal/flyway_config"
+	"gopkg.in/yaml.v3"
+)
+
+// Default location of flyway migrations when none is configured
+const defaultFlywayLocation = "filesystem:sql"
+
+// Prefix of the environment variables of the imported credentials, the same
+// as used by flyway
+const importEnvPrefix = "FLYWAY_"
+
+var nonEnvCharacters = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// Converts a native flyway configuration into a migrator configuration.
+//
+// The environment flyway connects to becomes the configuration of a single
+// schema and the other environments become environment overrides. Connection
+// settings are replaced by composite credentials reading the user and password
+// from environment variables, the parameters of the url of each environment
+// become JDBC properties of the schema in that environment. Managed settings that the migrator cannot express
+// otherwise, e.g classpath locations, remain flyway settings allowed by the flyway policy
+func ImportFlywayConfig(c *fc.Config) (*Migrator, error) {
+	selected := c.Environment()
+
+	settings := FlywaySettings{}
+	for name, value := range c.Flyway {
+		if name != "environment" {
+			settings[name] = typedSetting(name, value)
+		}
+	}
+	if environment, ok := c.Environments[selected]; ok {
+		for name, value := range environmentSettings(environment) {
+			settings[name] = value
+		}
+	} else if _, ok := c.Flyway["environment"]; ok {
+		return nil, fmt.Errorf("flyway.environment: environment %s is not defined", selected)
+	}
+
+	credentials, properties, err := importCredentials(settings, importEnvPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := importSchema(settings)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range properties {
+		if schema.Flyway == nil {
+			schema.Flyway = FlywaySettings{}
+		}
+		schema.Flyway[name] = value
+	}
+
+	m := &Migrator{
+		Credentials:  credentials,
+		Schemas:      []*Schema{schema},
+		FlywayPolicy: importPolicy(schema.Flyway, settings),
+	}
+	if len(settings) > 0 {
+		m.Flyway = settings
+	}
+
+	for _, name := range c.EnvironmentNames() {
+		if name == selected {
+			continue
+		}
+		environment, err := importEnvironment(name, c.Environments[name], schema.Name, properties)
+		if err != nil {
+			return nil, fmt.Errorf("environments.%s: %w", name, err)
+		}
+		if m.Environments == nil {
+			m.Environments = map[string]*Environment{}
+		}
+		m.Environments[name] = environment
+	}
+
+	return m, nil
+}
+
+// Encodes the migrator configuration as YAML
+func EncodeMigrator(m *Migrator) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(m); err != nil {
+		return nil, err
+	}
+	providerFirst(&node)
+
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Takes the schema name, migrations path and placeholders out of the settings
+func importSchema(settings FlywaySettings) (*Schema, error) {
+	schema := &Schema{Flyway: FlywaySettings{}}
+
+	schemas := settingItems(settings["schemas"])
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("missing schemas, the migrator migrates a named schema")
+	}
+	schema.Name = schemas[0]
+	if len(schemas) > 1 {
+		schema.Flyway["schemas"] = settings["schemas"]
+	}
+	delete(settings, "schemas")
+
+	locations := []string{defaultFlywayLocation}
+	if _, ok := settings["locations"]; ok {
+		locations = settingItems(settings["locations"])
+	}
+	paths := []string{}
+	for _, location := range locations {
+		if path, ok := strings.CutPrefix(location, "filesystem:"); ok {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("none of the locations %s is a filesystem: location, the migrator migrates from a migrations path", strings.Join(locations, ", "))
+	}
+	schema.MigrationsPath = paths[0]
+	if len(paths) == len(locations) {
+		if len(paths) > 1 {
+			schema.MigrationsPaths = paths[1:]
+		}
+	} else {
+		schema.Flyway["locations"] = settings["locations"]
+	}
+	delete(settings, "locations")
+
+	for _, name := range settings.names() {
+		placeholder, ok := strings.CutPrefix(name, "placeholders.")
+		if !ok {
+			continue
+		}
+		value, err := formatScalar(settings[name])
+		if err != nil {
+			return nil, fmt.Errorf("flyway setting %s: %w", name, err)
+		}
+		schema.Placeholders = append(schema.Placeholders, importPlaceholder(placeholder, value))
+		delete(settings, name)
+	}
+
+	if len(schema.Flyway) == 0 {
+		schema.Flyway = nil
+	}
+	return schema, nil
+}
+
+// Matches a value that is a reference to an environment variable resolved by flyway
+var flywayEnvReference = regexp.MustCompile(`^\$\{env\.([^}]+)\}$`)
+
+// Converts a flyway placeholder. A value referencing an environment variable
+// is read from the variable, any other ${ is escaped so that it is not
+// expanded as a reference when the config is loaded
+func importPlaceholder(name string, value string) *Placeholder {
+	if match := flywayEnvReference.FindStringSubmatch(value); match != nil {
+		return &Placeholder{Name: name, ValueFromEnv: match[1]}
+	}
+	return &Placeholder{Name: name, Value: strings.ReplaceAll(value, "${", config.EscapedExpression)}
+}
+
+// Converts the settings of an environment that flyway does not connect to by
+// default into environment overrides. The JDBC properties of its url replace
+// the default JDBC properties of the schema, which connects to another url
+func importEnvironment(name string, environment map[string]any, schema string, defaultProperties FlywaySettings) (*Environment, error) {
+	settings := environmentSettings(environment)
+	prefix := importEnvPrefix + strings.Trim(nonEnvCharacters.ReplaceAllString(strings.ToUpper(name), "_"), "_") + "_"
+
+	e := &Environment{}
+	properties := FlywaySettings{}
+	if settings["url"] != nil || settings["user"] != nil || settings["password"] != nil {
+		_, hasURL := settings["url"]
+		credentials, urlProperties, err := importCredentials(settings, prefix)
+		if err != nil {
+			return nil, err
+		}
+		e.Credentials = credentials
+		for property := range defaultProperties {
+			if _, ok := urlProperties[property]; hasURL && !ok {
+				properties[property] = &yaml.Node{Kind: yaml.ScalarNode, Tag: config.DeleteDirective}
+			}
+		}
+		for property, value := range urlProperties {
+			properties[property] = value
+		}
+	}
+	if len(properties) > 0 {
+		e.Schemas = []*SchemaOverride{{Name: schema, Flyway: properties}}
+	}
+	if len(settings) > 0 {
+		e.Flyway = settings
+	}
+	e.FlywayPolicy = importPolicy(settings, properties)
+	return e, nil
+}
+
+// Returns the settings of an environment, including its flyway settings
+func environmentSettings(environment map[string]any) FlywaySettings {
+	settings := FlywaySettings{}
+	for name, value := range environment {
+		name = strings.TrimPrefix(name, "flyway.")
+		settings[name] = typedSetting(name, value)
+	}
+	return settings
+}
+
+// Takes the connection settings out of the settings and replaces them with
+// composite credentials. The host, port and database are taken from the url,
+// and the user and password from the environment variables with the prefix.
+// The parameters of the url are returned as JDBC properties
+func importCredentials(settings FlywaySettings, prefix string) (*Credentials, FlywaySettings, error) {
+	composite := &cp.CompositeDatabaseCredentials{
+		Username: &cp.ValueSource{Env: prefix + "USER"},
+		Password: &cp.ValueSource{Env: prefix + "PASSWORD"},
+		Host:     &cp.ValueSource{Env: prefix + "HOST"},
+		Port:     &cp.ValueSource{Env: prefix + "PORT"},
+		Database: &cp.ValueSource{Env: prefix + "DATABASE"},
+	}
+
+	properties := FlywaySettings{}
+	if jdbc, ok := settings["url"].(string); ok {
+		u, err := url.Parse(strings.TrimPrefix(jdbc, "jdbc:"))
+		if err != nil || !strings.HasPrefix(jdbc, "jdbc:") || u.Scheme != "postgresql" || u.Hostname() == "" {
+			return nil, nil, fmt.Errorf("url %s is not a PostgreSQL JDBC url, jdbc:postgresql://host:port/database", jdbc)
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = "5432"
+		}
+		composite.Host = &cp.ValueSource{Value: u.Hostname()}
+		composite.Port = &cp.ValueSource{Value: port}
+		composite.Database = &cp.ValueSource{Value: strings.TrimPrefix(u.Path, "/")}
+
+		// parameters of the url are JDBC properties
+		for key, values := range u.Query() {
+			properties["jdbcProperties."+key] = values[len(values)-1]
+		}
+	}
+
+	delete(settings, "url")
+	delete(settings, "user")
+	delete(settings, "password")
+
+	return &Credentials{
+		Provider:            string(cp.CompositeProviderType),
+		CredentialProviders: CredentialProviders{CompositeProviderImpl: composite},
+	}, properties, nil
+}
+
+// Returns the flyway policy that allows the managed and unsafe settings, or
+// nil if the settings have neither
+func importPolicy(settings ...FlywaySettings) *FlywayPolicy {
+	p := &FlywayPolicy{}
+	for _, s := range settings {
+		for _, name := range s.names() {
+			if managed, ok := managedFlywaySetting(name); ok && !slices.Contains(p.AllowOverrides, managed) {
+				p.AllowOverrides = append(p.AllowOverrides, managed)
+			}
+			value, _ := formatSetting(name, s[name])
+			if unsafe, ok := unsafeFlywaySettings[name]; ok && strings.EqualFold(value, unsafe) && !slices.Contains(p.AllowUnsafe, name) {
+				p.AllowUnsafe = append(p.AllowUnsafe, name)
+			}
+		}
+	}
+
+	if len(p.AllowOverrides) == 0 && len(p.AllowUnsafe) == 0 {
+		return nil
+	}
+	sort.Strings(p.AllowOverrides)
+	sort.Strings(p.AllowUnsafe)
+	return p
+}
+
+// Converts settings of flyway.conf files, which are all strings, to the type of the setting
+func typedSetting(name string, value any) any {
+	if s, ok := value.(string); ok {
+		return parseSetting(name, s)
+	}
+	return value
+}
+
+// Returns the items of a list setting, a list or a comma separated string
+func settingItems(value any) []string {
+	items := []string{}
+	switch v := value.(type) {
+	case []any:
+		for _, item := range v {
+			if formatted, err := formatScalar(item); err == nil {
+				items = append(items, formatted)
+			}
+		}
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// Moves the provider key of every credentials mapping to the front, so that
+// it is read before the provider blocks
+func providerFirst(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 2; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "provider" {
+				pair := []*yaml.Node{node.Content[i], node.Content[i+1]}
+				node.Content = append(pair, append(node.Content[:i:i], node.Content[i+2:]...)...)
+				break
+			}
+		}
+	}
+	for _, child := range node.Content {
+		providerFirst(child)
+	}
+}
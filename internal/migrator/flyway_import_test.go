@@ -0,0 +1,239 @@
+package migrator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcehawk/go-flyway/internal/config"
+	fc "github.com/sourcehawk/go-flyway/internal/flyway_config"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+const importedFlywayToml = `
+[environments.default]
+url = "jdbc:postgresql://localhost:6543/app?ssl=true"
+user = "admin"
+password = "secret"
+schemas = ["app", "audit"]
+connectRetries = 3
+
+[environments.prod-eu]
+url = "jdbc:postgresql://prod/app"
+user = "admin"
+
+[environments.prod-eu.flyway]
+locations = ["filesystem:./sql", "filesystem:./prod"]
+
+[flyway]
+locations = ["filesystem:./sql"]
+outOfOrder = true
+cleanDisabled = false
+
+[flyway.placeholders]
+owner = "app_owner"
+`
+
+func Test_ImportFlywayConfig_ConvertsFlywayToml(t *testing.T) {
+	c, err := fc.ParseToml([]byte(importedFlywayToml))
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	m, err := ImportFlywayConfig(c)
+	assert.NoError(err)
+
+	data, err := EncodeMigrator(m)
+	assert.NoError(err)
+	assert.Equal(`flyway:
+  cleanDisabled: false
+  connectRetries: 3
+  outOfOrder: true
+flywayPolicy:
+  allowOverrides:
+    - schemas
+  allowUnsafe:
+    - cleanDisabled
+credentials:
+  provider: composite
+  composite:
+    username:
+      env: FLYWAY_USER
+    password:
+      env: FLYWAY_PASSWORD
+    host:
+      value: localhost
+    port:
+      value: "6543"
+    database:
+      value: app
+schemas:
+  - name: app
+    migrationsPath: ./sql
+    flyway:
+      jdbcProperties.ssl: "true"
+      schemas:
+        - app
+        - audit
+    placeholders:
+      - name: owner
+        value: app_owner
+environments:
+  prod-eu:
+    flyway:
+      locations:
+        - filesystem:./sql
+        - filesystem:./prod
+    flywayPolicy:
+      allowOverrides:
+        - locations
+    credentials:
+      provider: composite
+      composite:
+        username:
+          env: FLYWAY_PROD_EU_USER
+        password:
+          env: FLYWAY_PROD_EU_PASSWORD
+        host:
+          value: prod
+        port:
+          value: "5432"
+        database:
+          value: app
+    schemas:
+      - name: app
+        flyway:
+          jdbcProperties.ssl: !delete
+`, string(data))
+
+	var node yaml.Node
+	assert.NoError(yaml.Unmarshal(data, &node))
+	assert.NoError(JSONSchema().Validate(&node))
+	decoded, err := DecodeMigrator(&node)
+	assert.NoError(err)
+	assert.NoError(decoded.validateStructure())
+}
+
+func Test_ImportFlywayConfig_ScopesUrlParametersToEnvironments(t *testing.T) {
+	c, err := fc.ParseToml([]byte(`
+[environments.default]
+url = "jdbc:postgresql://localhost/app?ssl=true&sslmode=require"
+schemas = ["app"]
+
+[environments.staging]
+url = "jdbc:postgresql://staging/app?sslmode=disable&connectTimeout=5"
+
+[environments.dev]
+user = "dev"
+`))
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	m, err := ImportFlywayConfig(c)
+	assert.NoError(err)
+	assert.Nil(m.Flyway)
+	assert.Equal(FlywaySettings{"jdbcProperties.ssl": "true", "jdbcProperties.sslmode": "require"}, m.Schemas[0].Flyway)
+	assert.Nil(m.Environments["dev"].Schemas)
+
+	data, err := EncodeMigrator(m)
+	assert.NoError(err)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(os.WriteFile(path, data, 0644))
+
+	for environment, expected := range map[string]FlywaySettings{
+		"staging": {"jdbcProperties.sslmode": "disable", "jdbcProperties.connectTimeout": "5"},
+		"dev":     {"jdbcProperties.ssl": "true", "jdbcProperties.sslmode": "require"},
+	} {
+		doc, err := config.LoadDocument([]string{path}, MergeKeys())
+		assert.NoError(err)
+		assert.NoError(doc.SelectEnvironment(environment))
+		selected, err := DecodeMigrator(doc.Root)
+		assert.NoError(err)
+		assert.Nil(selected.Flyway, environment)
+		assert.Equal(expected, selected.Schemas[0].Flyway, environment)
+	}
+}
+
+func Test_ImportFlywayConfig_KeepsPlaceholderValuesAcrossLoadAndExport(t *testing.T) {
+	t.Setenv("APP_OWNER", "app_owner")
+	c, err := fc.ParseToml([]byte(`
+[environments.default]
+url = "jdbc:postgresql://localhost/app"
+schemas = ["app"]
+
+[flyway]
+locations = ["filesystem:testdata/migrations"]
+
+[flyway.placeholders]
+alias = "${other}"
+owner = "${env.APP_OWNER}"
+path = "$HOME/${dir}"
+`))
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	m, err := ImportFlywayConfig(c)
+	assert.NoError(err)
+	assert.Equal([]*Placeholder{
+		{Name: "alias", Value: "$${other}"},
+		{Name: "owner", ValueFromEnv: "APP_OWNER"},
+		{Name: "path", Value: "$HOME/$${dir}"},
+	}, m.Schemas[0].Placeholders)
+
+	data, err := EncodeMigrator(m)
+	assert.NoError(err)
+	loaded := loadTestConfig(t, string(data), config.NewReferenceResolver())
+
+	exported, err := loaded.Schemas[0].ExportFlywayToml(nil)
+	assert.NoError(err)
+	assert.Contains(exported, "placeholders.alias = \"${other}\"\n")
+	assert.Contains(exported, "placeholders.owner = \"${env.APP_OWNER}\"\n")
+	assert.Contains(exported, "placeholders.path = \"$HOME/${dir}\"\n")
+
+	// the export without credentials does not resolve references
+	resolver := config.NewOfflineReferenceResolver()
+	loaded = loadTestConfig(t, string(data), resolver)
+	assert.NoError(loaded.PrepareExport(resolver.Unresolved()))
+	offline, err := loaded.Schemas[0].ExportFlywayToml(nil)
+	assert.NoError(err)
+	assert.Equal(exported, offline)
+}
+
+func Test_ImportFlywayConfig_ConvertsFlywayConf(t *testing.T) {
+	c, err := fc.ParseConf([]byte(`flyway.url=jdbc:postgresql://db/x
+flyway.schemas=main
+flyway.locations=filesystem:sql,filesystem:callbacks
+flyway.baselineOnMigrate=true
+flyway.connectRetries=5
+flyway.placeholders.team=core
+`))
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	m, err := ImportFlywayConfig(c)
+	assert.NoError(err)
+	assert.Equal(FlywaySettings{"baselineOnMigrate": true, "connectRetries": 5}, m.Flyway)
+	assert.Nil(m.FlywayPolicy)
+	assert.Len(m.Schemas, 1)
+	assert.Equal("main", m.Schemas[0].Name)
+	assert.Equal("sql", m.Schemas[0].MigrationsPath)
+	assert.Equal([]string{"callbacks"}, m.Schemas[0].MigrationsPaths)
+	assert.Nil(m.Schemas[0].Flyway)
+	assert.Equal([]*Placeholder{{Name: "team", Value: "core"}}, m.Schemas[0].Placeholders)
+	assert.Nil(m.Environments)
+}
+
+func Test_ImportFlywayConfig_FailsOnUnsupportedConfigs(t *testing.T) {
+	for config, expected := range map[string]string{
+		"flyway.url=jdbc:mysql://db/x\nflyway.schemas=a":            "url jdbc:mysql://db/x is not a PostgreSQL JDBC url, jdbc:postgresql://host:port/database",
+		"flyway.url=jdbc:postgresql://db/x":                         "missing schemas, the migrator migrates a named schema",
+		"flyway.schemas=a\nflyway.locations=classpath:db/migration": "none of the locations classpath:db/migration is a filesystem: location, the migrator migrates from a migrations path",
+		"flyway.schemas=a\nflyway.environment=prod":                 "flyway.environment: environment prod is not defined",
+	} {
+		c, err := fc.ParseConf([]byte(config))
+		assert.NoError(t, err)
+
+		_, err = ImportFlywayConfig(c)
+		assert.EqualError(t, err, expected)
+	}
+}
@@ -0,0 +1,121 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	js "github.com/sourcehawk/go-flyway/internal/json_schema"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
+
+// The flyway settings that the migrator passes to flyway for every schema,
+// and the settings that load other configurations, which could replace them.
+// placeholders and environments stand for all placeholders.<name> and
+// environments.<name> settings
+var managedFlywaySettings = []string{"url", "user", "password", "schemas", "locations", "placeholders", "configFiles", "environment", "environments"}
+
+// Flyway settings with the value that makes them unsafe, e.g because it
+// allows flyway to drop all objects of the schema
+var unsafeFlywaySettings = map[string]string{
+	"cleanDisabled":          "false",
+	"cleanOnValidationError": "true",
+}
+
+// FlywayPolicy relaxes the restrictions on the flyway settings of the configuration
+type FlywayPolicy struct {
+	// Settings managed by the migrator that flyway settings and arguments may
+	// override, e.g locations
+	AllowOverrides []string `yaml:"allowOverrides,omitempty"`
+	// Settings that may have an unsafe value, e.g cleanDisabled
+	AllowUnsafe []string `yaml:"allowUnsafe,omitempty"`
+}
+
+// Restricts the allowed settings to the managed and unsafe settings
+func (p *FlywayPolicy) ExtendJSONSchema(s *js.Schema) {
+	s.Properties["allowOverrides"].Items.Enum = managedFlywaySettings
+	s.Properties["allowUnsafe"].Items.Enum = unsafeSettingNames()
+}
+
+// Validates that the policy only refers to managed and unsafe settings
+func (p *FlywayPolicy) Validate() error {
+	errs := []error{}
+
+	for i, name := range p.AllowOverrides {
+		if !slices.Contains(managedFlywaySettings, name) {
+			errs = append(errs, validation.Errorf(fmt.Sprintf("allowOverrides[%d]", i),
+				"%s is not managed by the migrator, expected one of %s", name, strings.Join(managedFlywaySettings, ", ")))
+		}
+	}
+	for i, name := range p.AllowUnsafe {
+		if _, ok := unsafeFlywaySettings[name]; !ok {
+			errs = append(errs, validation.Errorf(fmt.Sprintf("allowUnsafe[%d]", i),
+				"%s is not an unsafe setting, expected one of %s", name, strings.Join(unsafeSettingNames(), ", ")))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Checks that the flyway arguments and settings neither override settings
+// managed by the migrator nor use unsafe values, unless the policy allows it.
+// A nil policy allows neither
+func (p *FlywayPolicy) Check(args []string, settings FlywaySettings) error {
+	errs := []error{}
+
+	for i, arg := range args {
+		name, value, ok := cutFlywayArg(arg)
+		if !ok {
+			continue
+		}
+		errs = append(errs, validation.AtPath(fmt.Sprintf("flywayArgs[%d]", i), p.check(name, value)))
+	}
+
+	for _, name := range settings.names() {
+		value, err := formatSetting(name, settings[name])
+		if err != nil {
+			continue
+		}
+		errs = append(errs, validation.AtPath("flyway."+name, p.check(name, value)))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *FlywayPolicy) check(name string, value string) error {
+	if managed, ok := managedFlywaySetting(name); ok && !p.allowsOverride(managed) {
+		return fmt.Errorf("flyway setting %s is managed by the migrator, allow overriding it with flywayPolicy.allowOverrides", name)
+	}
+	if unsafe, ok := unsafeFlywaySettings[name]; ok && strings.EqualFold(value, unsafe) && !p.allowsUnsafe(name) {
+		return fmt.Errorf("flyway setting %s=%s is unsafe, allow it with flywayPolicy.allowUnsafe", name, value)
+	}
+	return nil
+}
+
+func (p *FlywayPolicy) allowsOverride(managed string) bool {
+	return p != nil && slices.Contains(p.AllowOverrides, managed)
+}
+
+func (p *FlywayPolicy) allowsUnsafe(name string) bool {
+	return p != nil && slices.Contains(p.AllowUnsafe, name)
+}
+
+// Returns the managed setting that the flyway setting belongs to, if any
+func managedFlywaySetting(name string) (string, bool) {
+	for _, namespace := range []string{"placeholders", "environments"} {
+		if strings.HasPrefix(name, namespace+".") {
+			return namespace, true
+		}
+	}
+	return name, slices.Contains(managedFlywaySettings, name)
+}
+
+func unsafeSettingNames() []string {
+	names := make([]string, 0, len(unsafeFlywaySettings))
+	for name := range unsafeFlywaySettings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
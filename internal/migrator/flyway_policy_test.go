@@ -0,0 +1,100 @@
+package migrator
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FlywayPolicy_Check_RejectsManagedAndUnsafeSettings(t *testing.T) {
+	var policy *FlywayPolicy
+	args := []string{"-schemas=other", "-placeholders.owner=me", "-cleanDisabled=false", "-cleanDisabled=true", "-table=history"}
+	settings := FlywaySettings{"locations": []any{"filesystem:./other"}, "cleanOnValidationError": true}
+
+	errs := validation.Errors(policy.Check(args, settings))
+	assert := assert.New(t)
+	assert.Len(errs, 5)
+	assert.EqualError(errs[0], "flywayArgs[0]: flyway setting schemas is managed by the migrator, allow overriding it with flywayPolicy.allowOverrides")
+	assert.EqualError(errs[1], "flywayArgs[1]: flyway setting placeholders.owner is managed by the migrator, allow overriding it with flywayPolicy.allowOverrides")
+	assert.EqualError(errs[2], "flywayArgs[2]: flyway setting cleanDisabled=false is unsafe, allow it with flywayPolicy.allowUnsafe")
+	assert.EqualError(errs[3], "flyway.cleanOnValidationError: flyway setting cleanOnValidationError=true is unsafe, allow it with flywayPolicy.allowUnsafe")
+	assert.EqualError(errs[4], "flyway.locations: flyway setting locations is managed by the migrator, allow overriding it with flywayPolicy.allowOverrides")
+
+	policy = &FlywayPolicy{
+		AllowOverrides: []string{"schemas", "placeholders", "locations"},
+		AllowUnsafe:    []string{"cleanDisabled", "cleanOnValidationError"},
+	}
+	assert.NoError(policy.Check(args, settings))
+}
+
+func Test_FlywayPolicy_Check_RejectsSettingsLoadingOtherConfigurations(t *testing.T) {
+	var policy *FlywayPolicy
+	args := []string{"-configFiles=/tmp/other.toml", "-environment=prod"}
+	settings := FlywaySettings{"environments.prod.url": "jdbc:postgresql://other/db"}
+
+	errs := validation.Errors(policy.Check(args, settings))
+	assert := assert.New(t)
+	assert.Len(errs, 3)
+	assert.EqualError(errs[0], "flywayArgs[0]: flyway setting configFiles is managed by the migrator, allow overriding it with flywayPolicy.allowOverrides")
+	assert.EqualError(errs[1], "flywayArgs[1]: flyway setting environment is managed by the migrator, allow overriding it with flywayPolicy.allowOverrides")
+	assert.EqualError(errs[2], "flyway.environments.prod.url: flyway setting environments.prod.url is managed by the migrator, allow overriding it with flywayPolicy.allowOverrides")
+
+	policy = &FlywayPolicy{AllowOverrides: []string{"configFiles", "environment", "environments"}}
+	assert.NoError(policy.Check(args, settings))
+}
+
+func Test_Migrator_ValidateConfig_RejectsConfigFilesArgument(t *testing.T) {
+	m := validMockMigrator()
+	m.FlywayConfigMode = FlywayConfigToml
+	m.Schemas[0].FlywayArgs = []string{"-configFiles=/tmp/other.toml"}
+
+	assert.ErrorContains(t, m.ValidateConfig(nil), "schemas[0].flywayArgs[0]: flyway setting configFiles is managed by the migrator")
+}
+
+func Test_FlywayPolicy_Validate_FailsOnUnknownSettings(t *testing.T) {
+	policy := &FlywayPolicy{AllowOverrides: []string{"locations", "table"}, AllowUnsafe: []string{"outOfOrder"}}
+
+	errs := validation.Errors(policy.Validate())
+	assert := assert.New(t)
+	assert.Len(errs, 2)
+	assert.EqualError(errs[0], "allowOverrides[1]: table is not managed by the migrator, expected one of url, user, password, schemas, locations, placeholders, configFiles, environment, environments")
+	assert.EqualError(errs[1], "allowUnsafe[0]: outOfOrder is not an unsafe setting, expected one of cleanDisabled, cleanOnValidationError")
+}
+
+func Test_Migrator_Validate_AppliesFlywayPolicyToSchemas(t *testing.T) {
+	m := validMockMigrator()
+	m.Schemas[1].Flyway = FlywaySettings{"locations": "filesystem:./other"}
+
+	assert := assert.New(t)
+	assert.ErrorContains(m.Validate(), "schemas[1].flyway.locations: flyway setting locations is managed by the migrator")
+
+	m.FlywayPolicy = &FlywayPolicy{AllowOverrides: []string{"locations"}}
+	assert.NoError(m.Validate())
+}
+
+func Test_Schema_Migrate_ReplacesOverriddenManagedArgs(t *testing.T) {
+	s := validTestSchema()
+	s.policy = &FlywayPolicy{AllowOverrides: []string{"locations", "placeholders"}}
+	s.FlywayArgs = []string{"-placeholders.p1=overridden"}
+	s.Flyway = FlywaySettings{"locations": "filesystem:./other"}
+	s.Placeholders = []*Placeholder{{Name: "p1", Value: "v1"}, {Name: "p2", Value: "v2"}}
+
+	calls := [][]string{}
+	err := s.Migrate(func(name string, arg ...string) *exec.Cmd {
+		calls = append(calls, arg)
+		return exec.Command("echo", "testing")
+	})
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	args := calls[1]
+	assert.Contains(args, "-locations=filesystem:./other")
+	assert.NotContains(args, "-locations=filesystem:testdata/migrations")
+	assert.Contains(args, "-placeholders.p1=overridden")
+	assert.NotContains(args, "-placeholders.p1=v1")
+	assert.Contains(args, "-placeholders.p2=v2")
+	assert.Contains(args, "-schemas=name")
+	assert.Equal("migrate", args[len(args)-1])
+}
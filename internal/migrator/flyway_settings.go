@@ -0,0 +1,339 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	js "github.com/sourcehawk/go-flyway/internal/json_schema"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
+
+// Type of the value of a flyway setting
+type settingType int
+
+const (
+	settingString settingType = iota
+	settingBool
+	settingInt
+	// seconds, or a duration such as 30s
+	settingDuration
+	// a list, or a comma separated string
+	settingList
+)
+
+// The flyway settings known to the migrator, with the type of their value
+var flywaySettingTypes = map[string]settingType{
+	"baselineDescription":          settingString,
+	"baselineOnMigrate":            settingBool,
+	"baselineVersion":              settingString,
+	"callbacks":                    settingList,
+	"cherryPick":                   settingList,
+	"cleanDisabled":                settingBool,
+	"cleanOnValidationError":       settingBool,
+	"communityDBSupportEnabled":    settingBool,
+	"configFiles":                  settingList,
+	"connectRetries":               settingInt,
+	"connectRetriesInterval":       settingDuration,
+	"createSchemas":                settingBool,
+	"defaultSchema":                settingString,
+	"detectEncoding":               settingBool,
+	"driver":                       settingString,
+	"encoding":                     settingString,
+	"environment":                  settingString,
+	"errorOverrides":               settingList,
+	"executeInTransaction":         settingBool,
+	"failOnMissingLocations":       settingBool,
+	"group":                        settingBool,
+	"ignoreMigrationPatterns":      settingList,
+	"initSql":                      settingString,
+	"installedBy":                  settingString,
+	"locations":                    settingList,
+	"lockRetryCount":               settingInt,
+	"loggers":                      settingList,
+	"mixed":                        settingBool,
+	"outOfOrder":                   settingBool,
+	"outputQueryResults":           settingBool,
+	"password":                     settingString,
+	"placeholderPrefix":            settingString,
+	"placeholderReplacement":       settingBool,
+	"placeholderSeparator":         settingString,
+	"placeholderSuffix":            settingString,
+	"repeatableSqlMigrationPrefix": settingString,
+	"resolvers":                    settingList,
+	"schemas":                      settingList,
+	"scriptPlaceholderPrefix":      settingString,
+	"scriptPlaceholderSuffix":      settingString,
+	"skipDefaultCallbacks":         settingBool,
+	"skipDefaultResolvers":         settingBool,
+	"skipExecutingMigrations":      settingBool,
+	"sqlMigrationPrefix":           settingString,
+	"sqlMigrationSeparator":        settingString,
+	"sqlMigrationSuffixes":         settingList,
+	"table":                        settingString,
+	"tablespace":                   settingString,
+	"target":                       settingString,
+	"undoSqlMigrationPrefix":       settingString,
+	"url":                          settingString,
+	"user":                         settingString,
+	"validateMigrationNaming":      settingBool,
+	"validateOnMigrate":            settingBool,
+}
+
+// Prefixes of flyway settings whose names are not known in advance,
+// e.g placeholders.name or jdbcProperties.ssl
+var flywaySettingNamespaces = []string{"placeholders.", "jdbcProperties.", "environments.", "postgresql."}
+
+// Flyway settings by name, e.g connectRetries: 3, passed to flyway as -name=value.
+//
+// Known settings are validated against the type of their value, unknown
+// settings are passed to flyway as is
+type FlywaySettings map[string]any
+
+// Validates the value of every setting
+func (f FlywaySettings) Validate() error {
+	errs := []error{}
+	for _, name := range f.names() {
+		if _, err := formatSetting(name, f[name]); err != nil {
+			errs = append(errs, validation.AtPath(name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the settings as flyway arguments, ordered by name
+func (f FlywaySettings) Args() ([]string, error) {
+	args := make([]string, 0, len(f))
+	for _, name := range f.names() {
+		value, err := formatSetting(name, f[name])
+		if err != nil {
+			return nil, fmt.Errorf("flyway setting %s: %w", name, err)
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", name, value))
+	}
+	return args, nil
+}
+
+func (f FlywaySettings) names() []string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describes the types of the known settings, allowing any other setting
+func (FlywaySettings) ExtendJSONSchema(s *js.Schema) {
+	s.Description = "Flyway settings by name, settings that are not listed are passed to flyway as is"
+	s.Properties = make(map[string]*js.Schema, len(flywaySettingTypes))
+	for name, t := range flywaySettingTypes {
+		s.Properties[name] = t.jsonSchema()
+	}
+}
+
+func (t settingType) jsonSchema() *js.Schema {
+	switch t {
+	case settingBool:
+		return js.OrReference(&js.Schema{Type: "boolean"})
+	case settingInt:
+		return js.OrReference(&js.Schema{Type: "integer"})
+	case settingDuration:
+		return &js.Schema{
+			AnyOf:       []*js.Schema{{Type: "integer"}, {Type: "string"}},
+			Description: "seconds or a duration such as 30s",
+		}
+	case settingList:
+		return &js.Schema{
+			AnyOf:       []*js.Schema{{Type: "array", Items: &js.Schema{}}, {Type: "string"}},
+			Description: "list or comma separated string",
+		}
+	default:
+		return &js.Schema{AnyOf: []*js.Schema{{Type: "string"}, {Type: "number"}}}
+	}
+}
+
+// Returns the value of the setting as passed to flyway
+func formatSetting(name string, value any) (string, error) {
+	if value == nil {
+		return "", fmt.Errorf("missing value of flyway setting %s", name)
+	}
+
+	t, known := flywaySettingTypes[name]
+	if !known {
+		if list, ok := value.([]any); ok {
+			return formatList(list)
+		}
+		return formatScalar(value)
+	}
+
+	switch t {
+	case settingBool:
+		if b, ok := value.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+		return "", fmt.Errorf("expected boolean, found %s", describeValue(value))
+	case settingInt:
+		if i, ok := value.(int); ok {
+			return strconv.Itoa(i), nil
+		}
+		return "", fmt.Errorf("expected integer, found %s", describeValue(value))
+	case settingDuration:
+		return formatSeconds(value)
+	case settingList:
+		if list, ok := value.([]any); ok {
+			return formatList(list)
+		}
+		return formatScalar(value)
+	default:
+		return formatScalar(value)
+	}
+}
+
+// Parses the value of a setting given as a string, e.g in a flyway argument,
+// into the type of the setting. Values that do not parse are kept as strings
+func parseSetting(name string, value string) any {
+	switch flywaySettingTypes[name] {
+	case settingBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case settingInt, settingDuration:
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return value
+}
+
+func formatScalar(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("expected a single value, found %s", describeValue(value))
+	}
+}
+
+func formatList(list []any) (string, error) {
+	items := make([]string, len(list))
+	for i, item := range list {
+		formatted, err := formatScalar(item)
+		if err != nil {
+			return "", validation.AtPath(fmt.Sprintf("[%d]", i), err)
+		}
+		items[i] = formatted
+	}
+	return strings.Join(items, ","), nil
+}
+
+// Formats seconds, or a duration such as 30s, as whole seconds
+func formatSeconds(value any) (string, error) {
+	switch v := value.(type) {
+	case int:
+		if v >= 0 {
+			return strconv.Itoa(v), nil
+		}
+	case string:
+		d, err := time.ParseDuration(v)
+		if err == nil && d >= 0 && d%time.Second == 0 {
+			return strconv.Itoa(int(d / time.Second)), nil
+		}
+	}
+	return "", fmt.Errorf("expected seconds or a duration of whole seconds such as 30s, found %v", value)
+}
+
+// Describes the type of a decoded YAML value for error messages
+func describeValue(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case int:
+		return "integer"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+
+	// nested mappings are decoded into the type of the outer mapping
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice:
+		return "list"
+	case reflect.Map:
+		return "mapping"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// Splits a flyway argument of the form -key=value at the first '=', so that
+// values may contain '=', e.g -initSql=SET search_path=x
+func cutFlywayArg(arg string) (string, string, bool) {
+	key, value, found := strings.Cut(arg, "=")
+	if !found || len(key) < 2 || !strings.HasPrefix(key, "-") || value == "" {
+		return "", "", false
+	}
+	return key[1:], value, true
+}
+
+// Returns a warning if the flyway setting is not known to the migrator, e.g
+// because it is a typo, or nil otherwise
+func unknownFlywaySetting(path string, name string) error {
+	if _, ok := flywaySettingTypes[name]; ok {
+		return nil
+	}
+	for _, namespace := range flywaySettingNamespaces {
+		if strings.HasPrefix(name, namespace) {
+			return nil
+		}
+	}
+
+	known := make([]string, 0, len(flywaySettingTypes))
+	for setting := range flywaySettingTypes {
+		known = append(known, setting)
+	}
+	if suggestion := validation.ClosestKey(name, known); suggestion != "" {
+		return validation.Errorf(path, "unknown flyway setting %s is passed to flyway as is, did you mean %s?", name, suggestion)
+	}
+	return validation.Errorf(path, "unknown flyway setting %s is passed to flyway as is", name)
+}
+
+// Returns warnings for the flyway arguments and settings at the configuration
+// path that are not known to the migrator
+func flywayWarnings(path string, args []string, settings FlywaySettings) []error {
+	warnings := []error{}
+
+	for i, arg := range args {
+		if name, _, ok := cutFlywayArg(arg); ok {
+			warnings = append(warnings, unknownFlywaySetting(validation.JoinPath(path, fmt.Sprintf("flywayArgs[%d]", i)), name))
+		}
+	}
+	for _, name := range settings.names() {
+		warnings = append(warnings, unknownFlywaySetting(validation.JoinPath(path, "flyway."+name), name))
+	}
+
+	return warnings
+}
+
+// Checks that no setting is defined both as a flyway argument and a flyway setting
+func checkFlywayConflicts(args []string, settings FlywaySettings) error {
+	errs := []error{}
+	for _, arg := range args {
+		name, _, ok := cutFlywayArg(arg)
+		if _, defined := settings[name]; ok && defined {
+			errs = append(errs, validation.Errorf("flyway."+name, "flyway setting %s is also defined in flywayArgs", name))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,108 @@
+package migrator
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func decodeFlywaySettings(t *testing.T, data string) FlywaySettings {
+	settings := FlywaySettings{}
+	assert.NoError(t, yaml.Unmarshal([]byte(data), &settings))
+	return settings
+}
+
+func Test_FlywaySettings_Args_FormatsValuesByType(t *testing.T) {
+	settings := decodeFlywaySettings(t, `
+validateOnMigrate: false
+connectRetries: 3
+connectRetriesInterval: 2m
+lockRetryCount: -1
+callbacks: [com.example.A, com.example.B]
+sqlMigrationSuffixes: .sql,.pgsql
+baselineVersion: 1.10
+initSql: SET search_path=x
+unknownList: [1, true]
+`)
+
+	assert := assert.New(t)
+	assert.NoError(settings.Validate())
+
+	args, err := settings.Args()
+	assert.NoError(err)
+	assert.Equal([]string{
+		"-baselineVersion=1.1",
+		"-callbacks=com.example.A,com.example.B",
+		"-connectRetries=3",
+		"-connectRetriesInterval=120",
+		"-initSql=SET search_path=x",
+		"-lockRetryCount=-1",
+		"-sqlMigrationSuffixes=.sql,.pgsql",
+		"-unknownList=1,true",
+		"-validateOnMigrate=false",
+	}, args)
+}
+
+func Test_FlywaySettings_Validate_ReportsInvalidValuesAtTheirPath(t *testing.T) {
+	settings := decodeFlywaySettings(t, `
+validateOnMigrate: "yes"
+connectRetries: 1.5
+connectRetriesInterval: 1500ms
+callbacks: [a, {b: c}]
+initSql: {nested: value}
+table:
+`)
+
+	errs := validation.Errors(settings.Validate())
+	assert := assert.New(t)
+	assert.Len(errs, 6)
+	assert.EqualError(errs[0], "callbacks[1]: expected a single value, found mapping")
+	assert.EqualError(errs[1], "connectRetries: expected integer, found number")
+	assert.EqualError(errs[2], "connectRetriesInterval: expected seconds or a duration of whole seconds such as 30s, found 1500ms")
+	assert.EqualError(errs[3], "initSql: expected a single value, found mapping")
+	assert.EqualError(errs[4], "table: missing value of flyway setting table")
+	assert.EqualError(errs[5], "validateOnMigrate: expected boolean, found string")
+}
+
+func Test_flywayWarnings_WarnsAboutUnknownSettings(t *testing.T) {
+	warnings := flywayWarnings("schemas[0]",
+		[]string{"-conectRetries=3", "-placeholders.name=value", "-table=history", "invalid"},
+		FlywaySettings{"validateOnMigrat": true, "mystery": 1, "jdbcProperties.ssl": true},
+	)
+
+	errs := validation.Errors(errors.Join(warnings...))
+	assert := assert.New(t)
+	assert.Len(errs, 3)
+	assert.EqualError(errs[0], "schemas[0].flywayArgs[0]: unknown flyway setting conectRetries is passed to flyway as is, did you mean connectRetries?")
+	assert.EqualError(errs[1], "schemas[0].flyway.mystery: unknown flyway setting mystery is passed to flyway as is")
+	assert.EqualError(errs[2], "schemas[0].flyway.validateOnMigrat: unknown flyway setting validateOnMigrat is passed to flyway as is, did you mean validateOnMigrate?")
+}
+
+func Test_checkFlywayConflicts_FailsOnSettingDefinedTwice(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(checkFlywayConflicts([]string{"-table=a"}, FlywaySettings{"target": "1"}))
+	assert.EqualError(
+		checkFlywayConflicts([]string{"-table=a"}, FlywaySettings{"table": "b"}),
+		"flyway.table: flyway setting table is also defined in flywayArgs",
+	)
+}
+
+func Test_Schema_Migrate_PassesFlywaySettingsAfterFlywayArgs(t *testing.T) {
+	s := validTestSchema()
+	s.FlywayArgs = []string{"-initSql=SET search_path=x"}
+	s.Flyway = FlywaySettings{"validateOnMigrate": false, "connectRetries": 3}
+
+	calls := [][]string{}
+	err := s.Migrate(func(name string, arg ...string) *exec.Cmd {
+		calls = append(calls, arg)
+		return exec.Command("echo", "testing")
+	})
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"-initSql=SET search_path=x", "-connectRetries=3", "-validateOnMigrate=false"}, calls[1][:3])
+}
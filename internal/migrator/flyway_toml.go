@@ -0,0 +1,359 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	js "github.com/sourcehawk/go-flyway/internal/json_schema"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
+
+// How the configuration of a schema is passed to flyway
+type FlywayConfigMode string
+
+const (
+	// As command line arguments, the default
+	FlywayConfigArgs FlywayConfigMode = "args"
+	// As a private temporary flyway.toml file, passed with -configFiles
+	FlywayConfigToml FlywayConfigMode = "toml"
+)
+
+// Restricts the mode to the known modes
+func (FlywayConfigMode) ExtendJSONSchema(s *js.Schema) {
+	s.Enum = []string{string(FlywayConfigArgs), string(FlywayConfigToml)}
+}
+
+// Validates that the mode is known, an empty mode is the default mode
+func (m FlywayConfigMode) Validate() error {
+	switch m {
+	case "", FlywayConfigArgs, FlywayConfigToml:
+		return nil
+	default:
+		return fmt.Errorf("%s is not one of %s, %s", m, FlywayConfigArgs, FlywayConfigToml)
+	}
+}
+
+// Name of the environment of the generated flyway.toml
+const flywayTomlEnvironment = "default"
+
+// Settings that flyway reads from the environment of a TOML config rather
+// than from its flyway section
+var flywayEnvironmentSettings = []string{
+	"connectRetries", "connectRetriesInterval", "driver", "initSql", "jdbcProperties",
+	"password", "schemas", "url", "user",
+}
+
+var bareTomlKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Environment variables that exported flyway.toml files read the connection
+// settings from, the same as flyway itself uses
+const (
+	exportURLEnv      = "FLYWAY_URL"
+	exportUserEnv     = "FLYWAY_USER"
+	exportPasswordEnv = "FLYWAY_PASSWORD"
+)
+
+// Renders the flyway.toml of the schema, holding the settings managed by the
+// migrator for the given credentials and the flyway settings of the schema.
+//
+// Flyway arguments are not part of it, they are passed on the command line
+func (s *Schema) renderFlywayToml(creds *cp.DatabaseCredentials) (string, error) {
+	settings, err := s.flywayTomlSettings(jdbcURL(creds), creds.Username, creds.Password, true)
+	if err != nil {
+		return "", err
+	}
+	return renderFlywayToml(settings)
+}
+
+// Renders a flyway.toml holding the whole configuration of the schema, including
+// its flyway arguments, for use with plain flyway or Flyway Desktop.
+//
+// Without credentials, flyway reads the url, user and password from the
+// FLYWAY_URL, FLYWAY_USER and FLYWAY_PASSWORD environment variables, and the
+// placeholders from secrets, files and commands from FLYWAY_PLACEHOLDERS_<NAME>.
+// Placeholders with a type or constraints are then only exported with a literal value
+func (s *Schema) ExportFlywayToml(creds *cp.DatabaseCredentials) (string, error) {
+	var settings FlywaySettings
+	var err error
+	if creds != nil {
+		settings, err = s.flywayTomlSettings(jdbcURL(creds), creds.Username, creds.Password, true)
+	} else {
+		settings, err = s.flywayTomlSettings(envReference(exportURLEnv), envReference(exportUserEnv), envReference(exportPasswordEnv), false)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, arg := range s.FlywayArgs {
+		if name, value, ok := cutFlywayArg(arg); ok {
+			settings[name] = parseSetting(name, value)
+		}
+	}
+
+	config, err := renderFlywayToml(settings)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("# Exported by go-flyway for schema %s\n\n%s", s.Name, config), nil
+}
+
+// Paths of the values with references that are not exported, since the
+// credentials are not part of an export without them
+var exportSkippedPath = regexp.MustCompile(`^(schemas\[\d+\]\.)?credentials([.\[]|$)|^secretCache([.\[]|$)`)
+
+// Paths of the values of placeholders, e.g schemas[0].placeholders[1].valueFromSecret.secretName
+var exportPlaceholderPath = regexp.MustCompile(`^schemas\[(\d+)\]\.placeholders\[(\d+)\]\.(value|valueFromFile|key|valueFromEnv|valueFromSecret|valueFromCommand)([.\[]|$)`)
+
+// Prepares an export without credentials of a configuration loaded without
+// resolving its references. unresolved holds the configuration paths of the
+// values that contain references, e.g schemas[0].placeholders[1].value.
+//
+// Flyway reads the placeholders with such values from FLYWAY_PLACEHOLDERS_<NAME>,
+// any other reference would be written to the files and fails the export
+func (m *Migrator) PrepareExport(unresolved map[string]bool) error {
+	paths := []string{}
+	for path := range unresolved {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	errs := []error{}
+	for _, path := range paths {
+		if exportSkippedPath.MatchString(path) {
+			continue
+		}
+		match := exportPlaceholderPath.FindStringSubmatch(path)
+		if match == nil {
+			errs = append(errs, validation.Errorf(path, "value contains references, which are only resolved when exporting with credentials"))
+			continue
+		}
+		schema, _ := strconv.Atoi(match[1])
+		placeholder, _ := strconv.Atoi(match[2])
+		if schema < len(m.Schemas) && placeholder < len(m.Schemas[schema].Placeholders) {
+			m.Schemas[schema].Placeholders[placeholder].unresolved = true
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the settings of the flyway.toml of the schema with the given connection.
+//
+// Without secrets, only placeholders with a literal value are resolved, the
+// others are references to environment variables. Placeholders with a type or
+// constraints need a literal value then, flyway does not check the variables
+func (s *Schema) flywayTomlSettings(url, user, password string, withSecrets bool) (FlywaySettings, error) {
+	locations := []any{}
+	for _, location := range s.locations() {
+		locations = append(locations, location)
+	}
+
+	settings := FlywaySettings{
+		"url":       url,
+		"user":      user,
+		"password":  password,
+		"schemas":   []any{s.Name},
+		"locations": locations,
+	}
+	for _, p := range s.Placeholders {
+		// flyway puts values from environment variables into the SQL as they are
+		if !withSecrets && p.constrained() && (p.unresolved || p.Value == "") {
+			return nil, fmt.Errorf("placeholder %s has a type or constraints that flyway does not apply to values from environment variables, it is only exported with credentials", p.Name)
+		}
+		if !withSecrets && p.unresolved {
+			settings["placeholders."+p.Name] = envReference(exportPlaceholderEnv(p.Name))
+			continue
+		}
+		if !withSecrets && p.ValueFromEnv != "" {
+			settings["placeholders."+p.Name] = envReference(p.ValueFromEnv)
+			continue
+		}
+		// values from secrets, files and commands are typically secrets too,
+		// and commands are not run on export
+		if !withSecrets && p.Value == "" {
+			settings["placeholders."+p.Name] = envReference(exportPlaceholderEnv(p.Name))
+			continue
+		}
+		value, err := p.resolveValue()
+		if err != nil {
+			return nil, err
+		}
+		settings["placeholders."+p.Name] = value
+	}
+	// managed settings are only defined by the schema if the policy allows it
+	for name, value := range s.Flyway {
+		settings[name] = value
+	}
+	return settings, nil
+}
+
+// Renders the settings as a flyway.toml with a single environment
+func renderFlywayToml(settings FlywaySettings) (string, error) {
+	var environment, flyway strings.Builder
+	fmt.Fprintf(&environment, "[environments.%s]\n", flywayTomlEnvironment)
+	fmt.Fprintf(&flyway, "[flyway]\nenvironment = %s\n", tomlString(flywayTomlEnvironment))
+
+	for _, name := range settings.names() {
+		value, err := tomlValue(name, settings[name])
+		if err != nil {
+			return "", fmt.Errorf("flyway setting %s: %w", name, err)
+		}
+
+		section := &flyway
+		if root, _, _ := strings.Cut(name, "."); slices.Contains(flywayEnvironmentSettings, root) {
+			section = &environment
+		}
+		fmt.Fprintf(section, "%s = %s\n", tomlKey(name), value)
+	}
+
+	return environment.String() + "\n" + flyway.String(), nil
+}
+
+// Returns a reference to the environment variable, resolved by flyway
+func envReference(name string) string {
+	return "${env." + name + "}"
+}
+
+// Returns the environment variable of an exported placeholder whose value is
+// not written to the file, e.g FLYWAY_PLACEHOLDERS_APP_PW for app_pw
+func exportPlaceholderEnv(name string) string {
+	return "FLYWAY_PLACEHOLDERS_" + strings.Trim(nonEnvCharacters.ReplaceAllString(strings.ToUpper(name), "_"), "_")
+}
+
+// Writes the flyway.toml of the schema to a temporary file that only the
+// current user can read and returns its path. The caller removes the file
+func (s *Schema) writeFlywayToml(creds *cp.DatabaseCredentials) (string, error) {
+	config, err := s.renderFlywayToml(creds)
+	if err != nil {
+		return "", err
+	}
+
+	// created with mode 0600
+	f, err := os.CreateTemp("", "go-flyway-*.toml")
+	if err != nil {
+		return "", fmt.Errorf("failed to create flyway config file: %w", err)
+	}
+
+	_, err = f.WriteString(config)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write flyway config file: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+// Returns the TOML key of the setting. The names of placeholders and JDBC
+// properties are quoted as a single key, since they may contain dots
+func tomlKey(name string) string {
+	root, rest, namespaced := strings.Cut(name, ".")
+	if !namespaced {
+		return quoteTomlKey(name)
+	}
+	if root == "placeholders" || root == "jdbcProperties" {
+		return root + "." + quoteTomlKey(rest)
+	}
+
+	segments := strings.Split(name, ".")
+	for i, segment := range segments {
+		segments[i] = quoteTomlKey(segment)
+	}
+	return strings.Join(segments, ".")
+}
+
+func quoteTomlKey(key string) string {
+	if bareTomlKey.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+// Returns the TOML value of the setting, typed by the type of the setting
+// if it is known, or by the type of its value otherwise. Placeholders and
+// JDBC properties are always strings
+func tomlValue(name string, value any) (string, error) {
+	formatted, err := formatSetting(name, value)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(name, "placeholders.") || strings.HasPrefix(name, "jdbcProperties.") {
+		return tomlString(formatted), nil
+	}
+
+	t, known := flywaySettingTypes[name]
+	if !known {
+		switch v := value.(type) {
+		case []any:
+			return tomlList(v)
+		case string:
+			return tomlString(v), nil
+		default:
+			return formatted, nil
+		}
+	}
+
+	switch t {
+	case settingBool, settingInt, settingDuration:
+		return formatted, nil
+	case settingList:
+		if list, ok := value.([]any); ok {
+			return tomlList(list)
+		}
+		items := []any{}
+		for _, item := range strings.Split(formatted, ",") {
+			items = append(items, item)
+		}
+		return tomlList(items)
+	default:
+		return tomlString(formatted), nil
+	}
+}
+
+func tomlList(list []any) (string, error) {
+	items := make([]string, len(list))
+	for i, item := range list {
+		formatted, err := formatScalar(item)
+		if err != nil {
+			return "", err
+		}
+		items[i] = tomlString(formatted)
+	}
+	return "[" + strings.Join(items, ", ") + "]", nil
+}
+
+// Quotes the string as a TOML basic string
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
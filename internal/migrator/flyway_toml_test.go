@@ -0,0 +1,303 @@
+package migrator
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sourcehawk/go-flyway/internal/config"
+	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	fc "github.com/sourcehawk/go-flyway/internal/flyway_config"
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Schema_renderFlywayToml_RendersEnvironmentAndSettings(t *testing.T) {
+	s := validTestSchema()
+	s.Placeholders = []*Placeholder{{Name: "grants", Value: "GRANT \"r\";\nGRANT w;"}, {Name: "a.b", Value: "c"}}
+	s.Flyway = FlywaySettings{
+		"connectRetries":                3,
+		"jdbcProperties.ssl":            true,
+		"outOfOrder":                    true,
+		"sqlMigrationSuffixes":          ".sql,.psql",
+		"postgresql.transactional.lock": false,
+	}
+	creds := &cp.DatabaseCredentials{Host: "db", Port: 5432, Database: "app", Username: "u", Password: "p\\w"}
+
+	config, err := s.renderFlywayToml(creds)
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(`[environments.default]
+connectRetries = 3
+jdbcProperties.ssl = "true"
+password = "p\\w"
+schemas = ["name"]
+url = "jdbc:postgresql://db:5432/app"
+user = "u"
+
+[flyway]
+environment = "default"
+locations = ["filesystem:testdata/migrations"]
+outOfOrder = true
+placeholders."a.b" = "c"
+placeholders.grants = "GRANT \"r\";\nGRANT w;"
+postgresql.transactional.lock = false
+sqlMigrationSuffixes = [".sql", ".psql"]
+`, config)
+}
+
+func Test_Schema_renderFlywayToml_ReplacesOverriddenManagedSettings(t *testing.T) {
+	s := validTestSchema()
+	s.Placeholders = []*Placeholder{{Name: "p1", Value: "v1"}}
+	s.Flyway = FlywaySettings{"locations": []any{"filesystem:./a", "filesystem:./b"}, "placeholders.p1": "overridden"}
+
+	config, err := s.renderFlywayToml(&cp.DatabaseCredentials{Host: "a", Port: 5432, Database: "a", Username: "a", Password: "a"})
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Contains(config, "locations = [\"filesystem:./a\", \"filesystem:./b\"]\n")
+	assert.Contains(config, "placeholders.p1 = \"overridden\"\n")
+	assert.NotContains(config, "v1")
+}
+
+func Test_tomlString_EscapesControlCharacters(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(`"a\tb\r\n\u0000\u007F é"`, tomlString("a\tb\r\n\x00\x7f é"))
+}
+
+func Test_Schema_Migrate_PassesTemporaryFlywayTomlInTomlMode(t *testing.T) {
+	s := validTestSchema()
+	s.configMode = FlywayConfigToml
+	s.Placeholders = []*Placeholder{{Name: "p1", Value: "line1\nline2"}}
+
+	calls := [][]string{}
+	var configFile, config string
+	err := s.Migrate(func(name string, arg ...string) *exec.Cmd {
+		calls = append(calls, arg)
+		if len(arg) > 0 {
+			configFile = strings.TrimPrefix(arg[0], "-configFiles=")
+			info, err := os.Stat(configFile)
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+			content, _ := os.ReadFile(configFile)
+			config = string(content)
+		}
+		return exec.Command("echo", "testing")
+	})
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal([]string{"-configFiles=" + configFile, "-key1=val1", "-key2=val2", "migrate"}, calls[1])
+	assert.Contains(config, "password = \"a\"\n")
+	assert.Contains(config, "placeholders.p1 = \"line1\\nline2\"\n")
+	assert.NoFileExists(configFile)
+}
+
+func Test_Migrator_Validate_FailsOnUnknownFlywayConfigMode(t *testing.T) {
+	m := validMockMigrator()
+	m.FlywayConfigMode = "json"
+
+	assert := assert.New(t)
+	assert.ErrorContains(m.Validate(), "flywayConfigMode: json is not one of args, toml")
+
+	m.FlywayConfigMode = FlywayConfigToml
+	assert.NoError(m.Validate())
+	assert.Equal(FlywayConfigToml, m.Schemas[0].configMode)
+}
+
+func Test_Schema_ExportFlywayToml_ReferencesConnectionEnvironmentVariables(t *testing.T) {
+	s := validTestSchema()
+	s.FlywayArgs = []string{"-outOfOrder=true", "-table=history"}
+	s.Placeholders = []*Placeholder{{Name: "p1", Value: "v1"}}
+
+	config, err := s.ExportFlywayToml(nil)
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Equal(`# Exported by go-flyway for schema name
+
+[environments.default]
+password = "${env.FLYWAY_PASSWORD}"
+schemas = ["name"]
+url = "${env.FLYWAY_URL}"
+user = "${env.FLYWAY_USER}"
+
+[flyway]
+environment = "default"
+locations = ["filesystem:testdata/migrations"]
+outOfOrder = true
+placeholders.p1 = "v1"
+table = "history"
+`, config)
+
+	parsed, err := fc.ParseToml([]byte(config))
+	assert.NoError(err)
+	assert.Equal(true, parsed.Flyway["outOfOrder"])
+	assert.Equal("${env.FLYWAY_URL}", parsed.Environments["default"]["url"])
+}
+
+func Test_Schema_ExportFlywayToml_WritesCredentials(t *testing.T) {
+	s := validTestSchema()
+
+	config, err := s.ExportFlywayToml(&cp.DatabaseCredentials{Host: "db", Port: 5432, Database: "app", Username: "u", Password: "p"})
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Contains(config, "password = \"p\"\n")
+	assert.Contains(config, "url = \"jdbc:postgresql://db:5432/app\"\n")
+	assert.Contains(config, "key1 = \"val1\"\n")
+}
+
+func Test_Schema_ExportFlywayToml_ReferencesPlaceholdersWithoutLiteralValues(t *testing.T) {
+	useFakeSecretsProvider(t, fakeSecretsProvider{"app": "s3cret"})
+	t.Setenv("APP_OWNER", "owner")
+	s := validTestSchema()
+	marker := filepath.Join(t.TempDir(), "ran")
+	vars := filepath.Join(t.TempDir(), "vars.txt")
+	if err := os.WriteFile(vars, []byte("from-file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.Placeholders = []*Placeholder{
+		{Name: "app_pw", ValueFromSecret: &sp.SecretRef{SecretName: "app"}},
+		{Name: "owner", ValueFromEnv: "APP_OWNER"},
+		{Name: "token", ValueFromCommand: &PlaceholderCommand{Command: []string{"sh", "-c", "touch " + marker + "; echo from-command"}}},
+		{Name: "vars", ValueFromFile: vars},
+		{Name: "label", Value: "literal"},
+	}
+
+	assert := assert.New(t)
+	config, err := s.ExportFlywayToml(nil)
+	assert.NoError(err)
+	assert.Contains(config, "placeholders.app_pw = \"${env.FLYWAY_PLACEHOLDERS_APP_PW}\"\n")
+	assert.Contains(config, "placeholders.owner = \"${env.APP_OWNER}\"\n")
+	assert.Contains(config, "placeholders.token = \"${env.FLYWAY_PLACEHOLDERS_TOKEN}\"\n")
+	assert.Contains(config, "placeholders.vars = \"${env.FLYWAY_PLACEHOLDERS_VARS}\"\n")
+	assert.Contains(config, "placeholders.label = \"literal\"\n")
+	assert.NotContains(config, "from-")
+	assert.NoFileExists(marker)
+
+	config, err = s.ExportFlywayToml(&cp.DatabaseCredentials{Host: "db", Port: 5432, Database: "app", Username: "u", Password: "p"})
+	assert.NoError(err)
+	assert.Contains(config, "placeholders.app_pw = \"s3cret\"\n")
+	assert.Contains(config, "placeholders.owner = \"owner\"\n")
+	assert.Contains(config, "placeholders.token = \"from-command\"\n")
+	assert.Contains(config, "placeholders.vars = \"from-file\"\n")
+	assert.FileExists(marker)
+}
+
+// Decodes the configuration with its references expanded by the resolver
+func loadTestConfig(t *testing.T, data string, resolver *config.ReferenceResolver) *Migrator {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := config.LoadDocument([]string{path}, MergeKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolver.Expand(doc.Root); err != nil {
+		t.Fatal(err)
+	}
+	m, err := DecodeMigrator(doc.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.validateStructure(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func Test_Migrator_PrepareExport_ReferencesPlaceholdersWithUnresolvedValues(t *testing.T) {
+	resolver := config.NewOfflineReferenceResolver()
+	m := loadTestConfig(t, `
+credentials:
+  provider: composite
+  composite:
+    username: {value: "${secret:aws_sm://db#user}"}
+    password: {value: "${secret:aws_sm://db#password}"}
+    host: {value: db}
+    port: {value: "5432"}
+    database: {value: app}
+schemas:
+  - name: app
+    migrationsPath: testdata/migrations
+    placeholders:
+      - name: app_pw
+        value: "${secret:aws_sm://app#password}"
+      - name: dsn
+        value: "user=app password=${env:APP_PASSWORD}"
+      - name: owner
+        valueFromEnv: "${env:OWNER_VARIABLE}"
+      - name: label
+        value: literal
+`, resolver)
+
+	assert := assert.New(t)
+	assert.NoError(m.PrepareExport(resolver.Unresolved()))
+	config, err := m.Schemas[0].ExportFlywayToml(nil)
+	assert.NoError(err)
+	assert.Contains(config, "placeholders.app_pw = \"${env.FLYWAY_PLACEHOLDERS_APP_PW}\"\n")
+	assert.Contains(config, "placeholders.dsn = \"${env.FLYWAY_PLACEHOLDERS_DSN}\"\n")
+	assert.Contains(config, "placeholders.owner = \"${env.FLYWAY_PLACEHOLDERS_OWNER}\"\n")
+	assert.Contains(config, "placeholders.label = \"literal\"\n")
+	assert.NotContains(config, "secret:")
+	assert.NotContains(config, "APP_PASSWORD")
+}
+
+func Test_Migrator_PrepareExport_FailsOnOtherUnresolvedReferences(t *testing.T) {
+	resolver := config.NewOfflineReferenceResolver()
+	m := loadTestConfig(t, `
+flywayArgs:
+  - -table=${env:HISTORY_TABLE}
+credentials:
+  provider: composite
+  composite:
+    username: {value: app}
+    password: {env: DB_PASSWORD}
+    host: {value: db}
+    port: {value: "5432"}
+    database: {value: app}
+schemas:
+  - name: app
+    migrationsPath: testdata/migrations
+    flyway:
+      initSql: "SET ROLE ${secret:aws_sm://app#role}"
+`, resolver)
+
+	err := m.PrepareExport(resolver.Unresolved())
+	assert := assert.New(t)
+	assert.ErrorContains(err, "flywayArgs[0]: value contains references, which are only resolved when exporting with credentials")
+	assert.ErrorContains(err, "schemas[0].flyway.initSql: value contains references")
+}
+
+func Test_Schema_ExportFlywayToml_RequiresCredentialsForConstrainedPlaceholders(t *testing.T) {
+	t.Setenv("APP_LABEL", "it's")
+	assert := assert.New(t)
+
+	for _, p := range []*Placeholder{
+		{Name: "label", ValueFromEnv: "APP_LABEL", Type: PlaceholderString},
+		{Name: "label", ValueFromEnv: "APP_LABEL", Pattern: "[a-z]+"},
+		{Name: "label", ValueFromEnv: "APP_LABEL", Enum: []string{"a"}},
+		{Name: "label", ValueFromEnv: "APP_LABEL", Trim: PlaceholderTrimSpace},
+		{Name: "label", Value: "1", Type: PlaceholderInteger, unresolved: true},
+	} {
+		s := validTestSchema()
+		s.Placeholders = []*Placeholder{p}
+		_, err := s.ExportFlywayToml(nil)
+		assert.ErrorContains(err, "placeholder label has a type or constraints that flyway does not apply to values from environment variables, it is only exported with credentials")
+	}
+
+	s := validTestSchema()
+	s.Placeholders = []*Placeholder{
+		{Name: "label", ValueFromEnv: "APP_LABEL", Type: PlaceholderString},
+		{Name: "owner", Value: "app_owner", Type: PlaceholderIdentifier},
+	}
+	config, err := s.ExportFlywayToml(&cp.DatabaseCredentials{Host: "db", Port: 5432, Database: "app", Username: "u", Password: "p"})
+	assert.NoError(err)
+	assert.Contains(config, "placeholders.label = \"'it''s'\"\n")
+
+	s.Placeholders = s.Placeholders[1:]
+	config, err = s.ExportFlywayToml(nil)
+	assert.NoError(err)
+	assert.Contains(config, "placeholders.owner = \"\\\"app_owner\\\"\"\n")
+}
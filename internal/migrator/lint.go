@@ -0,0 +1,152 @@
+package migrator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	ml "github.com/sourcehawk/go-flyway/internal/migration_lint"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
+
+// Lints the migration files of every enabled schema and their use of the
+// placeholders of the schema without connecting to the database. The
+// configuration must have been validated with ValidateConfig.
+//
+// unresolved holds the configuration paths of directories that contain
+// unresolved references, which are not linted. Files in the shared locations
+// are reported once
+func (m *Migrator) Lint(unresolved map[string]bool) []ml.Diagnostic {
+	diagnostics := []ml.Diagnostic{}
+
+	shared := []string{}
+	skippedShared := false
+	for i, dir := range m.SharedLocations {
+		if unresolved[fmt.Sprintf("sharedLocations[%d]", i)] {
+			skippedShared = true
+		} else {
+			shared = append(shared, dir)
+		}
+	}
+
+	for i, s := range m.Schemas {
+		if !s.IsEnabled() {
+			continue
+		}
+		for _, d := range s.lint(fmt.Sprintf("schemas[%d]", i), unresolved, shared, skippedShared) {
+			if !slices.Contains(diagnostics, d) {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+	}
+
+	return diagnostics
+}
+
+// Lints the migration files in the directories of the schema and the shared
+// directories, named as configured by the flyway settings of the schema
+func (s *Schema) lint(configPath string, unresolved map[string]bool, shared []string, skippedShared bool) []ml.Diagnostic {
+	dirs := []string{}
+	skipped := false
+	if s.MigrationsPath != "" {
+		if unresolved[validation.JoinPath(configPath, "migrationsPath")] {
+			skipped = true
+		} else {
+			dirs = append(dirs, s.MigrationsPath)
+		}
+	}
+	for i, dir := range s.MigrationsPaths {
+		if unresolved[validation.JoinPath(configPath, fmt.Sprintf("migrationsPaths[%d]", i))] {
+			skipped = true
+		} else {
+			dirs = append(dirs, dir)
+		}
+	}
+	dirs = append(dirs, shared...)
+
+	naming := s.migrationNaming()
+	diagnostics := ml.Lint(dirs, naming)
+	if value, ok := s.flywaySetting("placeholderReplacement"); !ok || !strings.EqualFold(value, "false") {
+		diagnostics = append(diagnostics, s.lintPlaceholders(configPath, ml.Placeholders(dirs, naming), skipped || skippedShared)...)
+	}
+	return diagnostics
+}
+
+// Reports the references to placeholders the schema does not define as errors
+// and the placeholders of the schema that no migration references as warnings,
+// unless some of its directories were skipped. Placeholders defined as flyway
+// settings may be meant for other schemas and are not reported when unused
+func (s *Schema) lintPlaceholders(configPath string, references []ml.PlaceholderReference, skipped bool) []ml.Diagnostic {
+	defined := map[string]bool{}
+	for _, p := range s.Placeholders {
+		defined[p.Name] = true
+	}
+	for _, arg := range s.FlywayArgs {
+		if key, _, ok := cutFlywayArg(arg); ok && strings.HasPrefix(key, "placeholders.") {
+			defined[strings.TrimPrefix(key, "placeholders.")] = true
+		}
+	}
+	for name := range s.Flyway {
+		if placeholder, ok := strings.CutPrefix(name, "placeholders."); ok {
+			defined[placeholder] = true
+		}
+	}
+
+	diagnostics := []ml.Diagnostic{}
+	used := map[string]bool{}
+	for _, r := range references {
+		used[r.Name] = true
+		if !defined[r.Name] {
+			diagnostics = append(diagnostics, ml.Diagnostic{
+				File: r.File, Line: r.Line, Severity: ml.Error,
+				Message: fmt.Sprintf("placeholder %s is not defined for schema %s", r.Name, s.Name),
+			})
+		}
+	}
+	for i, p := range s.Placeholders {
+		if !used[p.Name] && !skipped {
+			diagnostics = append(diagnostics, ml.Diagnostic{
+				Path: validation.JoinPath(configPath, fmt.Sprintf("placeholders[%d]", i)), Severity: ml.Warning,
+				Message: fmt.Sprintf("placeholder %s of schema %s is not used by any migration", p.Name, s.Name),
+			})
+		}
+	}
+	return diagnostics
+}
+
+// Returns the naming of the migration files of the schema
+func (s *Schema) migrationNaming() ml.Naming {
+	naming := ml.DefaultNaming()
+	for name, field := range map[string]*string{
+		"sqlMigrationPrefix":           &naming.SQLMigrationPrefix,
+		"undoSqlMigrationPrefix":       &naming.UndoSQLMigrationPrefix,
+		"repeatableSqlMigrationPrefix": &naming.RepeatableSQLMigrationPrefix,
+		"sqlMigrationSeparator":        &naming.SQLMigrationSeparator,
+		"encoding":                     &naming.Encoding,
+		"placeholderPrefix":            &naming.PlaceholderPrefix,
+		"placeholderSuffix":            &naming.PlaceholderSuffix,
+	} {
+		if value, ok := s.flywaySetting(name); ok {
+			*field = value
+		}
+	}
+	if value, ok := s.flywaySetting("sqlMigrationSuffixes"); ok {
+		naming.SQLMigrationSuffixes = strings.Split(value, ",")
+	}
+	return naming
+}
+
+// Returns the value of the flyway setting as passed to flyway, whether it is
+// defined as a flyway argument or a flyway setting
+func (s *Schema) flywaySetting(name string) (string, bool) {
+	for _, arg := range s.FlywayArgs {
+		if key, value, ok := cutFlywayArg(arg); ok && key == name {
+			return value, true
+		}
+	}
+	if value, ok := s.Flyway[name]; ok {
+		formatted, err := formatSetting(name, value)
+		return formatted, err == nil
+	}
+	return "", false
+}
@@ -0,0 +1,91 @@
+package migrator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ml "github.com/sourcehawk/go-flyway/internal/migration_lint"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Migrator_Lint_SucceedsOnValidMigrations(t *testing.T) {
+	assert.Empty(t, validMockMigrator().Lint(nil))
+}
+
+func Test_Migrator_Lint_ReportsSharedFilesOnce(t *testing.T) {
+	shared := t.TempDir()
+	if err := os.WriteFile(filepath.Join(shared, "init.sql"), []byte("SELECT 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := validMockMigrator()
+	m.SharedLocations = []string{shared}
+
+	diagnostics := m.Lint(nil)
+	assert := assert.New(t)
+	assert.Len(diagnostics, 1)
+	assert.Equal(filepath.Join(shared, "init.sql"), diagnostics[0].File)
+}
+
+func Test_Migrator_Lint_SkipsUnresolvedAndDisabledSchemas(t *testing.T) {
+	m := validMockMigrator()
+	m.Schemas[0].MigrationsPath = "${env.MIGRATIONS}"
+	disabled := false
+	m.Schemas[1].Enabled = &disabled
+	m.SharedLocations = []string{"${env.SHARED}"}
+
+	assert.Empty(t, m.Lint(map[string]bool{"schemas[0].migrationsPath": true, "sharedLocations[0]": true}))
+}
+
+func Test_Schema_migrationNaming_UsesFlywaySettings(t *testing.T) {
+	s := validTestSchema()
+	s.FlywayArgs = []string{"-sqlMigrationPrefix=M", "-sqlMigrationSuffixes=.sql,.psql"}
+	s.Flyway = FlywaySettings{"sqlMigrationSeparator": "-", "sqlMigrationPrefix": "X", "encoding": "ISO-8859-1"}
+
+	naming := s.migrationNaming()
+	assert := assert.New(t)
+	assert.Equal(ml.Naming{
+		SQLMigrationPrefix:           "M",
+		UndoSQLMigrationPrefix:       "U",
+		RepeatableSQLMigrationPrefix: "R",
+		SQLMigrationSeparator:        "-",
+		SQLMigrationSuffixes:         []string{".sql", ".psql"},
+		Encoding:                     "ISO-8859-1",
+		PlaceholderPrefix:            "${",
+		PlaceholderSuffix:            "}",
+	}, naming)
+}
+
+func Test_Migrator_Lint_ReportsUndefinedAndUnusedPlaceholders(t *testing.T) {
+	dir := t.TempDir()
+	sql := "SELECT '${used}', '${typo}', '${flyway:defaultSchema}', '${from_arg}', '${from_setting}';"
+	if err := os.WriteFile(filepath.Join(dir, "V1__init.sql"), []byte(sql), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := validMockMigrator()
+	m.Schemas = m.Schemas[:1]
+	s := m.Schemas[0]
+	s.MigrationsPath = dir
+	s.FlywayArgs = []string{"-placeholders.from_arg=a"}
+	s.Flyway = FlywaySettings{"placeholders.from_setting": "b", "placeholders.other": "c"}
+	s.Placeholders = []*Placeholder{{Name: "used", Value: "a"}, {Name: "unused", Value: "b"}}
+
+	assert.Equal(t, []ml.Diagnostic{
+		{File: filepath.Join(dir, "V1__init.sql"), Line: 1, Severity: ml.Error, Message: "placeholder typo is not defined for schema foo"},
+		{Path: "schemas[0].placeholders[1]", Severity: ml.Warning, Message: "placeholder unused of schema foo is not used by any migration"},
+	}, m.Lint(nil))
+}
+
+func Test_Migrator_Lint_SkipsPlaceholdersWithoutReplacement(t *testing.T) {
+	m := validMockMigrator()
+	m.Schemas[0].Flyway = FlywaySettings{"placeholderReplacement": false}
+
+	assert.Empty(t, m.Lint(nil))
+}
+
+func Test_Migrator_Lint_SkipsUnusedPlaceholdersOfSkippedDirectories(t *testing.T) {
+	m := validMockMigrator()
+	m.SharedLocations = []string{"${env.SHARED}"}
+
+	assert.Empty(t, m.Lint(map[string]bool{"sharedLocations[0]": true}))
+}
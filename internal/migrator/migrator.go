@@ -1,53 +1,311 @@
 package migrator
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"log"
 	"os/exec"
+	"sync"
+	"time"
 
+	"github.com/sourcehawk/go-flyway/internal/config"
+	js "github.com/sourcehawk/go-flyway/internal/json_schema"
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
 	"gopkg.in/yaml.v3"
 )
 
+type SecretCacheConfig struct {
+	// Duration after which cached secrets are fetched again, e.g 10m.
+	// Secrets are cached for the whole run if not set
+	TTL string `yaml:"ttl,omitempty"`
+}
+
+func (c *SecretCacheConfig) Validate() error {
+	if c.TTL == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(c.TTL)
+	if err != nil {
+		return validation.Errorf("ttl", "invalid 'ttl' in secretCache: %w", err)
+	}
+	if ttl < 0 {
+		return validation.Errorf("ttl", "invalid 'ttl' in secretCache: must not be negative")
+	}
+	return nil
+}
+
+// Applies the configuration to the secret cache shared during the run
+func (c *SecretCacheConfig) apply(cache *sp.SecretCache) {
+	ttl, _ := time.ParseDuration(c.TTL)
+	cache.SetTTL(ttl)
+}
+
 type Migrator struct {
 	// Flyway arguments applied globally
 	FlywayArgs []string `yaml:"flywayArgs,omitempty"`
+	// Flyway settings by name applied globally
+	Flyway FlywaySettings `yaml:"flyway,omitempty"`
+	// Managed and unsafe flyway settings that the configuration may use
+	FlywayPolicy *FlywayPolicy `yaml:"flywayPolicy,omitempty"`
+	// How the configuration of every schema is passed to flyway, as command
+	// line arguments (args) or a temporary flyway.toml (toml). Defaults to args
+	FlywayConfigMode FlywayConfigMode `yaml:"flywayConfigMode,omitempty"`
+	// Directories appended to the flyway locations of every schema, e.g
+	// shared afterMigrate callbacks
+	SharedLocations []string `yaml:"sharedLocations,omitempty"`
 	// Credentials applied globally to schemas unless they explicitly specify their own
 	Credentials *Credentials `yaml:"credentials,omitempty"`
+	// Configuration of the secret cache shared by all credentials during the run
+	SecretCache *SecretCacheConfig `yaml:"secretCache,omitempty"`
 	// List of schemas to migrate
-	Schemas     []*Schema `yaml:"schemas"`
+	Schemas []*Schema `yaml:"schemas"`
+	// Overrides of the configuration by environment name. They are applied
+	// when the config is loaded and the environment is selected
+	Environments map[string]*Environment `yaml:"environments,omitempty"`
+	// Config files and globs merged before the file that includes them. They
+	// are resolved when the config is loaded
+	Include     []string `yaml:"include,omitempty"`
 	cmdExecFunc CommandFuncType
 }
 
-// Validate that the migrator configuration is valid
-// Note that this only validates the structure of the configuration,
-// it does not mean that the migration command will succceed
-func (m *Migrator) Validate() error {
+// Overrides of the configuration for an environment. They are merged into
+// the configuration the same way as the configuration files are merged
+type Environment struct {
+	FlywayArgs       []string           `yaml:"flywayArgs,omitempty"`
+	Flyway           FlywaySettings     `yaml:"flyway,omitempty"`
+	FlywayPolicy     *FlywayPolicy      `yaml:"flywayPolicy,omitempty"`
+	FlywayConfigMode FlywayConfigMode   `yaml:"flywayConfigMode,omitempty"`
+	SharedLocations  []string           `yaml:"sharedLocations,omitempty"`
+	Credentials      *Credentials       `yaml:"credentials,omitempty"`
+	SecretCache      *SecretCacheConfig `yaml:"secretCache,omitempty"`
+	Schemas          []*SchemaOverride  `yaml:"schemas,omitempty"`
+}
+
+// Overrides of the schema with the same name in an environment
+type SchemaOverride struct {
+	Name            string         `yaml:"name"`
+	MigrationsPath  string         `yaml:"migrationsPath,omitempty"`
+	MigrationsPaths []string       `yaml:"migrationsPaths,omitempty"`
+	FlywayArgs      []string       `yaml:"flywayArgs,omitempty"`
+	Flyway          FlywaySettings `yaml:"flyway,omitempty"`
+	Placeholders    []*Placeholder `yaml:"placeholders,omitempty"`
+	Credentials     *Credentials   `yaml:"credentials,omitempty"`
+	Enabled         *bool          `yaml:"enabled,omitempty"`
+}
+
+// Returns the default credentials and the credentials of every schema, each once,
+// keyed by the configuration path where they are first defined
+func (m *Migrator) uniqueCredentials() ([]*Credentials, []string) {
+	unique := []*Credentials{}
+	paths := []string{}
+	seen := map[*Credentials]bool{}
+
 	if m.Credentials != nil {
-		if err := m.Credentials.Validate(); err != nil {
-			return err
+		unique = append(unique, m.Credentials)
+		paths = append(paths, "credentials")
+		seen[m.Credentials] = true
+	}
+
+	for i, s := range m.Schemas {
+		if s.IsEnabled() && s.Credentials != nil && !seen[s.Credentials] {
+			unique = append(unique, s.Credentials)
+			paths = append(paths, fmt.Sprintf("schemas[%d].credentials", i))
+			seen[s.Credentials] = true
 		}
-		// prefetch credentials to fail fast instead of during migration process
-		if _, err := m.Credentials.FetchCredentials(); err != nil {
-			return err
+	}
+
+	return unique, paths
+}
+
+// Fetches the credentials of all schemas concurrently so that the migration
+// fails fast on bad credentials and secrets are fetched in parallel
+func (m *Migrator) prefetchCredentials() error {
+	unique, paths := m.uniqueCredentials()
+	errs := make([]error, len(unique))
+	var wg sync.WaitGroup
+
+	for i, c := range unique {
+		wg.Add(1)
+		go func(i int, c *Credentials) {
+			defer wg.Done()
+			_, err := c.FetchCredentials()
+			errs[i] = validation.AtPath(paths[i], err)
+		}(i, c)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Resolves the placeholders of every enabled schema, so that a missing or
+// invalid value fails before any schema is migrated
+func (m *Migrator) prefetchPlaceholders() error {
+	errs := []error{}
+	for i, s := range m.Schemas {
+		if !s.IsEnabled() {
+			continue
+		}
+		for j, p := range s.Placeholders {
+			if _, err := p.resolveValue(); err != nil {
+				errs = append(errs, validation.AtPath(fmt.Sprintf("schemas[%d].placeholders[%d]", i, j), err))
+			}
 		}
 	}
+	return errors.Join(errs...)
+}
+
+// Returns warnings about the configuration as it was loaded, such as flyway
+// settings that are not known to the migrator and are passed to flyway as is.
+// Each warning is reported at its configuration path
+func (m *Migrator) Warnings() error {
+	warnings := flywayWarnings("", m.FlywayArgs, m.Flyway)
+
+	for i, s := range m.Schemas {
+		if !s.IsEnabled() {
+			continue
+		}
+		warnings = append(warnings, flywayWarnings(fmt.Sprintf("schemas[%d]", i), s.FlywayArgs, s.Flyway)...)
+	}
+
+	return errors.Join(warnings...)
+}
+
+// Validates the structure of the configuration, reporting every problem with
+// its configuration path. Schemas without credentials are given the default
+// credentials, and the default flyway arguments are added to the schemas
+func (m *Migrator) validateStructure() error {
+	errs := []error{}
+
+	if m.SecretCache != nil {
+		errs = append(errs, validation.AtPath("secretCache", m.SecretCache.Validate()))
+	}
+
+	if m.FlywayPolicy != nil {
+		errs = append(errs, validation.AtPath("flywayPolicy", m.FlywayPolicy.Validate()))
+	}
+
+	errs = append(errs, validation.AtPath("flywayConfigMode", m.FlywayConfigMode.Validate()))
+
+	for i, dir := range m.SharedLocations {
+		if dir == "" {
+			errs = append(errs, validation.Errorf(fmt.Sprintf("sharedLocations[%d]", i), "empty path in 'sharedLocations'"))
+		}
+	}
+
+	defaultArgsErr := errors.Join(
+		validation.AtPath("flywayArgs", validateFlywayArgs(m.FlywayArgs)),
+		validation.AtPath("flyway", m.Flyway.Validate()),
+		checkFlywayConflicts(m.FlywayArgs, m.Flyway),
+		m.FlywayPolicy.Check(m.FlywayArgs, m.Flyway),
+	)
+	errs = append(errs, defaultArgsErr)
+
+	for i, s := range m.Schemas {
+		if !s.IsEnabled() {
+			continue
+		}
+		path := fmt.Sprintf("schemas[%d]", i)
 
-	for _, s := range m.Schemas {
 		if s.Credentials == nil {
 			if m.Credentials == nil {
-				return fmt.Errorf("missing 'credentials' field in migrator config for schema %s", s.Name)
+				errs = append(errs, validation.Errorf(path+".credentials", "missing 'credentials' field in migrator config for schema %s", s.Name))
+				continue
 			}
 			s.Credentials = m.Credentials
 		}
 
-		if len(m.FlywayArgs) != 0 {
+		s.policy = m.FlywayPolicy
+		s.configMode = m.FlywayConfigMode
+		s.sharedLocations = m.SharedLocations
+		if err := s.validateStructure(); err != nil {
+			errs = append(errs, validation.AtPath(path, err))
+			continue
+		}
+
+		if defaultArgsErr == nil {
 			if err := s.SetDefaultFlywayArgs(m.FlywayArgs); err != nil {
-				return err
+				errs = append(errs, validation.AtPath(path, err))
 			}
+			s.SetDefaultFlywaySettings(m.Flyway)
 		}
+	}
+
+	unique, paths := m.uniqueCredentials()
+	for i, c := range unique {
+		errs = append(errs, validation.AtPath(paths[i], c.ValidateConfig()))
+	}
 
+	return errors.Join(errs...)
+}
+
+// Validates the structure of the migrator configuration without resolving
+// credentials or contacting secret stores, and checks that the migration
+// paths and placeholder files exist.
+//
+// unresolved holds the configuration paths, e.g schemas[0].migrationsPath,
+// of values that contain unresolved references and are therefore not checked on disk.
+// All problems are reported, not only the first
+func (m *Migrator) ValidateConfig(unresolved map[string]bool) error {
+	errs := []error{m.validateStructure(), m.checkSharedLocations(unresolved)}
+
+	for i, s := range m.Schemas {
+		if !s.IsEnabled() {
+			continue
+		}
+		path := fmt.Sprintf("schemas[%d]", i)
+		errs = append(errs, validation.AtPath(path, s.checkFiles(path, unresolved)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Checks that the shared locations are existing directories, except for
+// those with unresolved references
+func (m *Migrator) checkSharedLocations(unresolved map[string]bool) error {
+	errs := []error{}
+	for i, dir := range m.SharedLocations {
+		path := fmt.Sprintf("sharedLocations[%d]", i)
+		if dir == "" || unresolved[path] {
+			continue
+		}
+		if err := checkDirectory(dir); err != nil {
+			errs = append(errs, validation.Errorf(path, "invalid 'sharedLocations' entry: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate that the migrator configuration is valid
+// Note that this only validates the structure of the configuration,
+// it does not mean that the migration command will succceed
+func (m *Migrator) Validate() error {
+	if err := m.validateStructure(); err != nil {
+		return err
+	}
+
+	if err := m.checkSharedLocations(nil); err != nil {
+		return err
+	}
+
+	if m.SecretCache != nil {
+		m.SecretCache.apply(sp.DefaultSecretCache)
+	}
+
+	// prefetch credentials to fail fast instead of during migration process
+	if err := m.prefetchCredentials(); err != nil {
+		return err
+	}
+	if err := m.prefetchPlaceholders(); err != nil {
+		return err
+	}
+
+	for i, s := range m.Schemas {
+		if !s.IsEnabled() {
+			continue
+		}
 		if err := s.Validate(); err != nil {
-			return err
+			return validation.AtPath(fmt.Sprintf("schemas[%d]", i), err)
 		}
 	}
 
@@ -61,6 +319,10 @@ func (m *Migrator) Migrate() error {
 	}
 
 	for _, s := range m.Schemas {
+		if !s.IsEnabled() {
+			log.Printf("skipping disabled schema %s", s.Name)
+			continue
+		}
 		if err := s.Migrate(m.cmdExecFunc); err != nil {
 			return err
 		}
@@ -69,13 +331,22 @@ func (m *Migrator) Migrate() error {
 	return nil
 }
 
-func newMigrator(configFile string, cmdExecFn CommandFuncType) (*Migrator, error) {
-	data, err := os.ReadFile(configFile)
+func loadMigrator(configFile string, cmdExecFn CommandFuncType) (*Migrator, error) {
+	doc, err := config.LoadDocument([]string{configFile}, MergeKeys())
 
 	if err != nil {
 		return nil, fmt.Errorf("unable to read config file %s: %w", configFile, err)
 	}
 
+	migrator, err := decodeMigrator(doc.Root, cmdExecFn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal config file %s to migrator: %w", configFile, err)
+	}
+
+	return migrator, nil
+}
+
+func decodeMigrator(node *yaml.Node, cmdExecFn CommandFuncType) (*Migrator, error) {
 	var execFn CommandFuncType = exec.Command
 
 	if cmdExecFn != nil {
@@ -86,8 +357,64 @@ func newMigrator(configFile string, cmdExecFn CommandFuncType) (*Migrator, error
 		cmdExecFunc: execFn,
 	}
 
-	if err := yaml.Unmarshal(data, migrator); err != nil {
-		return nil, fmt.Errorf("unable to unmarshal config file %s to migrator: %w", configFile, err)
+	// unknown keys are most likely typos, e.g migrationPath, that would
+	// otherwise silently be ignored
+	if err := validation.KnownFields(node, migrator); err != nil {
+		return nil, err
+	}
+
+	if err := node.Decode(migrator); err != nil {
+		return nil, err
+	}
+
+	return migrator, nil
+}
+
+// Returns the JSON Schema of the migrator configuration file
+func JSONSchema() *js.Schema {
+	return js.Generate(&Migrator{}, "go-flyway configuration")
+}
+
+// Returns how the sequences of the configuration are merged across config files.
+// Schemas and placeholders are merged by name, flyway arguments by key
+func MergeKeys() config.MergeKeys {
+	byName := config.FieldMergeKey("name")
+	byArgKey := config.PrefixMergeKey("=")
+
+	return config.MergeKeys{
+		"flywayArgs":             byArgKey,
+		"schemas":                byName,
+		"schemas[].flywayArgs":   byArgKey,
+		"schemas[].placeholders": byName,
+		// the names of the allowed settings are their own merge keys
+		"flywayPolicy.allowOverrides": byName,
+		"flywayPolicy.allowUnsafe":    byName,
+	}
+}
+
+// Returns the patterns of the configuration fields that hold file system paths
+func PathFields() []string {
+	return []string{
+		"schemas[].migrationsPath",
+		"schemas[].migrationsPaths[]",
+		"sharedLocations[]",
+		"schemas[].placeholders[].valueFromFile",
+		"credentials.composite.*.file",
+		"credentials.chain[].composite.*.file",
+		"schemas[].credentials.composite.*.file",
+		"schemas[].credentials.chain[].composite.*.file",
+	}
+}
+
+// Decode a migrator from a YAML node without validating it, failing on unknown keys
+func DecodeMigrator(node *yaml.Node) (*Migrator, error) {
+	return decodeMigrator(node, nil)
+}
+
+func newMigrator(configFile string, cmdExecFn CommandFuncType) (*Migrator, error) {
+	migrator, err := loadMigrator(configFile, cmdExecFn)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := migrator.Validate(); err != nil {
@@ -97,6 +424,11 @@ func newMigrator(configFile string, cmdExecFn CommandFuncType) (*Migrator, error
 	return migrator, nil
 }
 
+// Load a migrator from a config file without validating it
+func LoadMigrator(configFile string) (*Migrator, error) {
+	return loadMigrator(configFile, nil)
+}
+
 // Create a new migrator from a config file
 func NewMigrator(configFile string) (*Migrator, error) {
 	return newMigrator(configFile, nil)
@@ -1,12 +1,18 @@
 package migrator
 
 import (
+	"encoding/json"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 
+	"github.com/sourcehawk/go-flyway/internal/config"
 	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 func validMockMigrator() *Migrator {
@@ -29,11 +35,11 @@ func validMockMigrator() *Migrator {
 		Schemas: []*Schema{
 			{
 				Name:           "foo",
-				MigrationsPath: "./data/foo",
+				MigrationsPath: "./testdata/foo",
 				Placeholders:   []*Placeholder{{Name: "p1", Value: "v1"}}},
 			{
 				Name:           "bar",
-				MigrationsPath: "./data/bar",
+				MigrationsPath: "./testdata/bar",
 			},
 		},
 		cmdExecFunc: func(name string, arg ...string) *exec.Cmd {
@@ -85,6 +91,32 @@ func Test_Migrator_Migrate_SucceedsWithValidConfig(t *testing.T) {
 	assert.NoError(m.Migrate())
 }
 
+func Test_Migrator_Migrate_SkipsDisabledSchemas(t *testing.T) {
+	m := validMockMigrator()
+	disabled := false
+	m.Schemas[1] = &Schema{
+		Name:    "bar",
+		Enabled: &disabled,
+		Credentials: &Credentials{
+			Provider: string(cp.EnvProviderType),
+			CredentialProviders: CredentialProviders{
+				EnvProviderImpl: &cp.EnvDatabaseCredentials{UsernameKey: "NOT_SET"},
+			},
+		},
+	}
+	migrated := []string{}
+	m.cmdExecFunc = func(name string, arg ...string) *exec.Cmd {
+		migrated = append(migrated, arg...)
+		return exec.Command("echo", "testing")
+	}
+
+	assert := assert.New(t)
+	assert.NoError(m.Validate())
+	assert.NoError(m.Migrate())
+	assert.Contains(migrated, "-schemas=foo")
+	assert.NotContains(migrated, "-schemas=bar")
+}
+
 func Test_Migrator_Migrate_FailsWhenValidationError(t *testing.T) {
 	m := validMockMigrator()
 	m.Schemas[1] = &Schema{}
@@ -129,7 +161,7 @@ credentials:
     database: x
 schemas:
   - name: schema_1
-    migrationsPath: ./data/schema_1
+    migrationsPath: ./testdata/foo
     flywayArgs:
       - "-key=val2"
       - "-foo=bar"
@@ -142,7 +174,7 @@ schemas:
         port: 6543
         database: y
   - name: schema_2
-    migrationsPath: ./data/schema_2
+    migrationsPath: ./testdata/bar
     flywayArgs:
       - "-baselineOnMigrate=true"
     placeholders:
@@ -160,7 +192,7 @@ schemas:
 
 	schema1 := m.Schemas[0]
 	assert.Equal(schema1.Name, "schema_1")
-	assert.Equal(schema1.MigrationsPath, "./data/schema_1")
+	assert.Equal(schema1.MigrationsPath, "./testdata/foo")
 	assert.Nil(schema1.Placeholders)
 	assert.Contains(schema1.FlywayArgs, "-key=val2")
 	assert.Contains(schema1.FlywayArgs, "-foo=bar")
@@ -176,7 +208,8 @@ schemas:
 	assert.Equal(schema2.Name, "schema_2")
 	assert.Contains(schema2.FlywayArgs, "-key=val")
 	assert.Contains(schema2.FlywayArgs, "-baselineOnMigrate=true")
-	assert.Equal(*schema2.Placeholders[0], Placeholder{Name: "test_placeholder", Value: "test_value"})
+	assert.Equal("test_placeholder", schema2.Placeholders[0].Name)
+	assert.Equal("test_value", schema2.Placeholders[0].Value)
 	assert.Equal(schema2.Credentials.TextProviderImpl.DatabaseCredentials, cp.DatabaseCredentials{
 		Username: "x",
 		Password: "x",
@@ -202,3 +235,345 @@ func Test_NewMigrator_FailsWhenConfigFileNotYamlParsable(t *testing.T) {
 	_, err = NewMigrator(path)
 	assert.Error(err)
 }
+
+func Test_NewMigrator_FailsOnUnknownKeys(t *testing.T) {
+	path, err := writeTestFile("testfile", []byte(`
+schemas:
+  - name: schema_1
+    migrationPath: ./data/schema_1
+`))
+	defer os.Remove(path) //nolint:errcheck
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	_, err = NewMigrator(path)
+	assert.ErrorContains(err, "schemas[0].migrationPath: unknown key migrationPath, did you mean migrationsPath?")
+}
+
+func Test_DecodeMigrator_ReportsEveryUnknownKey(t *testing.T) {
+	var node yaml.Node
+	assert := assert.New(t)
+	assert.NoError(yaml.Unmarshal([]byte(`
+secretCache:
+  tll: 5m
+credentials:
+  provider: aws_sm
+  aws_sm:
+    username:
+      secretName: db
+      secretKey: username
+      verisonStage: AWSPREVIOUS
+schemas:
+  - name: schema_1
+    migrationsPath: ./data/schema_1
+`), &node))
+
+	_, err := DecodeMigrator(&node)
+	errs := validation.Errors(err)
+	assert.Len(errs, 2)
+	assert.EqualError(errs[0], "secretCache.tll: unknown key tll, did you mean ttl?")
+	assert.EqualError(errs[1], "credentials.aws_sm.username.verisonStage: unknown key verisonStage, did you mean versionStage?")
+}
+
+func Test_Migrator_Validate_FailsWhenInvalidSecretCacheTTL(t *testing.T) {
+	m := validMockMigrator()
+	assert := assert.New(t)
+
+	m.SecretCache = &SecretCacheConfig{TTL: "notaduration"}
+	assert.Error(m.Validate())
+
+	m.SecretCache = &SecretCacheConfig{TTL: "-1m"}
+	assert.Error(m.Validate())
+}
+
+func Test_Migrator_Validate_AppliesSecretCacheTTL(t *testing.T) {
+	m := validMockMigrator()
+	m.SecretCache = &SecretCacheConfig{TTL: "10m"}
+	defer sp.DefaultSecretCache.SetTTL(0)
+
+	assert := assert.New(t)
+	assert.NoError(m.Validate())
+}
+
+func Test_Migrator_Validate_PrefetchesEachCredentialsOnce(t *testing.T) {
+	m := validMockMigrator()
+	schemaCredentials := validTestCredentials()
+	m.Schemas[0].Credentials = schemaCredentials
+	m.Schemas = append(m.Schemas, &Schema{Name: "baz", MigrationsPath: "./testdata/migrations", Credentials: schemaCredentials})
+
+	assert := assert.New(t)
+	assert.NoError(m.Validate())
+	assert.NotNil(m.Credentials.credentials)
+	assert.NotNil(schemaCredentials.credentials)
+}
+
+func Test_Migrator_Validate_ReportsAllFailedCredentials(t *testing.T) {
+	m := validMockMigrator()
+	m.Credentials.TextProviderImpl.Database = ""
+	schemaCredentials := validTestCredentials()
+	schemaCredentials.TextProviderImpl.Host = ""
+	m.Schemas[0].Credentials = schemaCredentials
+
+	err := m.Validate()
+	assert := assert.New(t)
+	assert.Error(err)
+	assert.Contains(err.Error(), "missing 'database'")
+	assert.Contains(err.Error(), "missing 'host'")
+}
+
+func Test_Migrator_Migrate_ResolvesAllPlaceholdersBeforeMigrating(t *testing.T) {
+	t.Setenv("PLACEHOLDER_PORT", "x")
+	m := validMockMigrator()
+	m.Schemas[0].Placeholders = append(m.Schemas[0].Placeholders, &Placeholder{Name: "port", ValueFromEnv: "PLACEHOLDER_PORT", Type: PlaceholderInteger})
+	m.Schemas[1].Placeholders = []*Placeholder{{Name: "owner", ValueFromEnv: "PLACEHOLDER_TEST_NOT_SET"}}
+	migrations := 0
+	m.cmdExecFunc = func(name string, arg ...string) *exec.Cmd {
+		migrations++
+		return exec.Command("echo", "testing")
+	}
+
+	err := m.Migrate()
+	assert := assert.New(t)
+	assert.ErrorContains(err, "schemas[0].placeholders[1]: invalid value of placeholder port: value is not an integer")
+	assert.ErrorContains(err, "schemas[1].placeholders[0]: failed to resolve placeholder owner: environment variable PLACEHOLDER_TEST_NOT_SET not set")
+	assert.Equal(0, migrations)
+}
+
+func Test_Migrator_ValidateConfig_DoesNotContactSecretStores(t *testing.T) {
+	m := validMockMigrator()
+	m.Credentials = &Credentials{
+		Provider: string(cp.AWSSMProviderType),
+		CredentialProviders: CredentialProviders{
+			AwssmProviderImpl: &cp.AWSSMDatabaseCredentials{
+				Username: &sp.SecretRef{SecretName: "db", SecretKey: "username"},
+				Password: &sp.SecretRef{SecretName: "db", SecretKey: "password"},
+				Host:     &sp.SecretRef{SecretName: "db", SecretKey: "host"},
+				Port:     &sp.SecretRef{SecretName: "db", SecretKey: "port"},
+				Database: &sp.SecretRef{SecretName: "db", SecretKey: "database"},
+			},
+		},
+	}
+	m.Schemas[0].MigrationsPath = t.TempDir()
+	m.Schemas[1].MigrationsPath = t.TempDir()
+	cp.NewAWSSecretsManager = func(sp.AWSClientConfig) (*sp.AWSSecretsManager, error) {
+		t.Fatal("secrets manager client created during config validation")
+		return nil, nil
+	}
+	defer func() { cp.NewAWSSecretsManager = sp.SharedAWSSecretsManager }()
+
+	assert := assert.New(t)
+	assert.NoError(m.ValidateConfig(nil))
+	assert.Contains(m.Schemas[1].FlywayArgs, "-mykey=myvalue")
+}
+
+func Test_Migrator_Validate_AppendsSharedLocationsToSchemas(t *testing.T) {
+	m := validMockMigrator()
+	m.SharedLocations = []string{"./testdata/migrations"}
+
+	assert := assert.New(t)
+	assert.NoError(m.Validate())
+	for _, s := range m.Schemas {
+		assert.Equal("filesystem:./testdata/migrations", s.locations()[1])
+	}
+
+	m.SharedLocations = []string{"./testdata/missing", "${env:SHARED}"}
+	err := m.ValidateConfig(map[string]bool{"sharedLocations[1]": true})
+	assert.EqualError(err, "sharedLocations[0]: invalid 'sharedLocations' entry: stat ./testdata/missing: no such file or directory")
+	assert.EqualError(m.Validate(), "sharedLocations[0]: invalid 'sharedLocations' entry: stat ./testdata/missing: no such file or directory\nsharedLocations[1]: invalid 'sharedLocations' entry: stat ${env:SHARED}: no such file or directory")
+}
+
+func Test_Migrator_ValidateConfig_ReportsAllProblems(t *testing.T) {
+	m := validMockMigrator()
+	m.Credentials.TextProviderImpl.Database = ""
+	m.Schemas[0].Placeholders = []*Placeholder{{Name: "p"}}
+	m.Schemas[0].MigrationsPath = "./testdata/missing"
+	m.Schemas[1].MigrationsPath = "./testdata/missing"
+	m.Schemas = append(m.Schemas, &Schema{Name: "baz", MigrationsPath: t.TempDir(), FlywayArgs: []string{"nodash=value"}})
+
+	assert := assert.New(t)
+	err := m.ValidateConfig(map[string]bool{"schemas[1].migrationsPath": true})
+	assert.Error(err)
+	assert.ErrorContains(err, "missing 'database' key in text credentials")
+	assert.ErrorContains(err, "all empty for p")
+	assert.ErrorContains(err, "invalid 'migrationsPath' in schema foo")
+	assert.NotContains(err.Error(), "invalid 'migrationsPath' in schema bar")
+	assert.ErrorContains(err, "nodash=value cannot be interpreted")
+	assert.Equal(1, strings.Count(err.Error(), "missing 'database' key"), "shared credentials are reported once")
+}
+
+func Test_Migrator_ValidateConfig_ReportsConfigPaths(t *testing.T) {
+	m := validMockMigrator()
+	m.FlywayArgs = []string{"-ok=1", "bad"}
+	m.SecretCache = &SecretCacheConfig{TTL: "soon"}
+	m.Schemas[0].MigrationsPath = t.TempDir()
+	m.Schemas[1].MigrationsPath = t.TempDir()
+	m.Schemas[1].Name = ""
+	m.Schemas[1].Placeholders = []*Placeholder{{Name: "ok", Value: "v"}, {Value: "v"}}
+	m.Schemas = append(m.Schemas, &Schema{
+		Name:           "baz",
+		MigrationsPath: t.TempDir(),
+		Credentials: &Credentials{
+			Provider: string(cp.EnvProviderType),
+			CredentialProviders: CredentialProviders{
+				EnvProviderImpl: &cp.EnvDatabaseCredentials{UsernameKey: "U", PasswordKey: "P", HostKey: "H", DatabaseKey: "D"},
+			},
+		},
+	})
+
+	errs := validation.Errors(m.ValidateConfig(nil))
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, strings.SplitN(err.Error(), ": ", 2)[0])
+	}
+
+	assert := assert.New(t)
+	assert.Equal([]string{
+		"secretCache.ttl",
+		"flywayArgs[1]",
+		"schemas[1].name",
+		"schemas[1].placeholders[1].name",
+		"schemas[2].credentials.env.portKey",
+	}, messages)
+}
+
+func Test_Migrator_Validate_ReportsConfigPathOfFailedCredentials(t *testing.T) {
+	m := validMockMigrator()
+	m.Schemas[1].Credentials = &Credentials{
+		Provider: string(cp.EnvProviderType),
+		CredentialProviders: CredentialProviders{
+			EnvProviderImpl: &cp.EnvDatabaseCredentials{UsernameKey: "NOT_SET", PasswordKey: "P", HostKey: "H", PortKey: "P", DatabaseKey: "D"},
+		},
+	}
+
+	assert := assert.New(t)
+	err := m.Validate()
+	assert.ErrorContains(err, "schemas[1].credentials.env: environment variable NOT_SET")
+}
+
+func Test_JSONSchema_MatchesShippedSchemaFile(t *testing.T) {
+	shipped, err := os.ReadFile("../../config.schema.json")
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	generated, err := json.MarshalIndent(JSONSchema(), "", "  ")
+	assert.NoError(err)
+	assert.Equal(string(shipped), string(generated)+"\n", "config.schema.json is out of date, regenerate it with: go run . schema -o config.schema.json")
+}
+
+func Test_JSONSchema_AcceptsValidConfigAndRejectsTypos(t *testing.T) {
+	var node yaml.Node
+	assert := assert.New(t)
+	assert.NoError(yaml.Unmarshal([]byte(`
+credentials:
+  provider: chain
+  chain:
+    - provider: aws_sm
+      aws_sm:
+        region: eu-west-1
+        username: {secretName: db, secretKey: username}
+        port: {secretName: db, secretKey: port}
+    - provider: text
+      text:
+        username: x
+        port: ${env:DB_PORT}
+schemas:
+  - name: schema_1
+    migrationsPath: ./data/schema_1
+    placeholders:
+      - name: p
+        valueFromFile: ./p.txt
+`), &node))
+	assert.NoError(JSONSchema().Validate(&node))
+
+	node = yaml.Node{}
+	assert.NoError(yaml.Unmarshal([]byte(`
+credentials:
+  provider: vault
+  text:
+    port: notaport
+schemas:
+  - name: schema_1
+    migrationPath: ./data/schema_1
+`), &node))
+	errs := validation.Errors(JSONSchema().Validate(&node))
+	assert.Len(errs, 3)
+	assert.EqualError(errs[0], "credentials.provider: vault is not one of text, env, aws_sm, chain, composite")
+	assert.EqualError(errs[1], "credentials.text.port: expected integer or reference or environment variable, found string")
+	assert.EqualError(errs[2], "schemas[0].migrationPath: unknown key migrationPath, did you mean migrationsPath?")
+}
+
+func Test_MergeKeys_MergesSchemasAcrossConfigFiles(t *testing.T) {
+	base, err := writeTestFile("base", []byte(`
+flywayArgs:
+  - -connectRetries=3
+schemas:
+  - name: test_1
+    migrationsPath: ./data/test_1
+  - name: test_2
+    migrationsPath: ./data/test_2
+    flywayArgs:
+      - -baselineOnMigrate=true
+      - -cleanDisabled=true
+    placeholders:
+      - name: a
+        value: "1"
+      - name: b
+        value: "2"
+`))
+	defer os.Remove(base) //nolint:errcheck
+	assert := assert.New(t)
+	assert.NoError(err)
+
+	override, err := writeTestFile("override", []byte(`
+schemas:
+  - name: test_2
+    flywayArgs:
+      - -cleanDisabled=false
+      - !delete -baselineOnMigrate
+    placeholders:
+      - name: b
+        value: "22"
+`))
+	defer os.Remove(override) //nolint:errcheck
+	assert.NoError(err)
+
+	doc, err := config.LoadDocument([]string{base, override}, MergeKeys())
+	assert.NoError(err)
+	m, err := DecodeMigrator(doc.Root)
+	assert.NoError(err)
+
+	assert.Equal([]string{"-connectRetries=3"}, m.FlywayArgs)
+	assert.Len(m.Schemas, 2)
+	assert.Equal("./data/test_1", m.Schemas[0].MigrationsPath)
+	assert.Equal("./data/test_2", m.Schemas[1].MigrationsPath)
+	assert.Equal([]string{"-cleanDisabled=false"}, m.Schemas[1].FlywayArgs)
+	assert.Equal([]*Placeholder{{Name: "a", Value: "1"}, {Name: "b", Value: "22"}}, m.Schemas[1].Placeholders)
+}
+
+func Test_Migrator_Validate_AppliesDefaultFlywaySettings(t *testing.T) {
+	m := validMockMigrator()
+	m.Flyway = FlywaySettings{"connectRetries": 3, "table": "history"}
+	m.Schemas[0].Flyway = FlywaySettings{"connectRetries": 5}
+	m.Schemas[1].FlywayArgs = []string{"-table=own_history"}
+
+	assert := assert.New(t)
+	assert.NoError(m.Validate())
+	assert.Equal(FlywaySettings{"connectRetries": 5, "table": "history"}, m.Schemas[0].Flyway)
+	assert.Equal(FlywaySettings{"connectRetries": 3}, m.Schemas[1].Flyway)
+	assert.Equal([]string{"-mykey=myvalue", "-table=own_history"}, m.Schemas[1].FlywayArgs)
+
+	m.FlywayArgs = []string{"-table=other"}
+	assert.ErrorContains(m.Validate(), "flyway.table: flyway setting table is also defined in flywayArgs")
+}
+
+func Test_Migrator_Warnings_ReportsUnknownFlywaySettings(t *testing.T) {
+	m := validMockMigrator()
+	m.Flyway = FlywaySettings{"conectRetries": 3}
+	m.Schemas[1].FlywayArgs = []string{"-validateOnMigrate=false"}
+
+	errs := validation.Errors(m.Warnings())
+	assert := assert.New(t)
+	assert.Len(errs, 2)
+	assert.EqualError(errs[0], "flywayArgs[0]: unknown flyway setting mykey is passed to flyway as is")
+	assert.EqualError(errs[1], "flyway.conectRetries: unknown flyway setting conectRetries is passed to flyway as is, did you mean connectRetries?")
+}
@@ -1,10 +1,24 @@
 package migrator
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"gopkg.in/yaml.v3"
 )
 
+// Time after which a placeholder command is killed if it sets no timeout
+const defaultPlaceholderCommandTimeout = 30 * time.Second
+
 type Placeholder struct {
 	// Name of the placeholder value that shall be replaced
 	Name string `yaml:"name"`
@@ -12,21 +26,132 @@ type Placeholder struct {
 	Value string `yaml:"value,omitempty"`
 	// Optionally, the user can load the value from a given file path
 	ValueFromFile string `yaml:"valueFromFile,omitempty"`
+	// Key of the value in a JSON or YAML valueFromFile, e.g roles.app.password.
+	// The whole file is used if the key is not set
+	Key string `yaml:"key,omitempty"`
+	// Name of an environment variable holding the value
+	ValueFromEnv string `yaml:"valueFromEnv,omitempty"`
+	// Reference to a key in a secret of any registered secrets provider
+	ValueFromSecret *sp.SecretRef `yaml:"valueFromSecret,omitempty"`
+	// A command printing the value, trailing newlines are removed
+	ValueFromCommand *PlaceholderCommand `yaml:"valueFromCommand,omitempty"`
+	// How the value is put into the SQL, raw by default
+	Type PlaceholderType `yaml:"type,omitempty"`
+	// Regular expression the whole value must match
+	Pattern string `yaml:"pattern,omitempty"`
+	// The values the placeholder may have
+	Enum []string `yaml:"enum,omitempty"`
+	// Whitespace removed from the value, none for raw placeholders and space
+	// for the other types by default
+	Trim PlaceholderTrim `yaml:"trim,omitempty"`
+	// the value contains references that were not resolved for an export
+	unresolved bool
+	// the resolved value, so that e.g the command only runs once per run
+	resolved *string
+}
+
+// A command whose output is the value of a placeholder
+type PlaceholderCommand struct {
+	// The program and its arguments, run in the working directory without a shell
+	Command []string `yaml:"command"`
+	// Duration after which the command is killed, e.g 10s. Defaults to 30s
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+func (c *PlaceholderCommand) Validate() error {
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return validation.Errorf("command", "'command' cannot be empty")
+	}
+	if _, err := c.timeout(); err != nil {
+		return validation.Errorf("timeout", "invalid 'timeout': %w", err)
+	}
+	return nil
+}
+
+func (c *PlaceholderCommand) timeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return defaultPlaceholderCommandTimeout, nil
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err == nil && timeout <= 0 {
+		err = fmt.Errorf("%s is not positive", c.Timeout)
+	}
+	return timeout, err
+}
+
+// Runs the command and returns its output without trailing newlines
+func (c *PlaceholderCommand) run() (string, error) {
+	timeout, err := c.timeout()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("command %s timed out after %s", c.Command[0], timeout)
+		}
+		return "", fmt.Errorf("command %s failed: %w: %s", c.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	value := strings.TrimRight(stdout.String(), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("command %s printed no value", c.Command[0])
+	}
+	return value, nil
 }
 
 func (p *Placeholder) Validate() error {
+	errs := []error{}
+
 	if p.Name == "" {
-		return fmt.Errorf("'name' cannot be empty in placeholder value")
+		errs = append(errs, validation.Errorf("name", "'name' cannot be empty in placeholder value"))
 	}
 
-	if p.Value == "" && p.ValueFromFile == "" {
-		return fmt.Errorf("must specify either 'value' or 'valueFromFile', both empty for %s", p.Name)
+	set := 0
+	for _, isSet := range []bool{p.Value != "", p.ValueFromFile != "", p.ValueFromEnv != "", p.ValueFromSecret != nil, p.ValueFromCommand != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set == 0 {
+		errs = append(errs, validation.Errorf("value", "must specify one of 'value', 'valueFromFile', 'valueFromEnv', 'valueFromSecret' or 'valueFromCommand', all empty for %s", p.Name))
+	}
+	if set > 1 {
+		errs = append(errs, validation.Errorf("value", "only one of 'value', 'valueFromFile', 'valueFromEnv', 'valueFromSecret' or 'valueFromCommand' can be specified for %s", p.Name))
 	}
 
-	return nil
+	if err := p.validateConstraints(); err != nil {
+		errs = append(errs, err)
+	} else if p.Value != "" {
+		// the value can only be checked against valid constraints
+		if _, err := p.encode(p.Value); err != nil {
+			errs = append(errs, validation.Errorf("value", "invalid 'value' of placeholder %s: %w", p.Name, err))
+		}
+	}
+
+	if p.Key != "" && p.ValueFromFile == "" {
+		errs = append(errs, validation.Errorf("key", "'key' of placeholder %s requires 'valueFromFile'", p.Name))
+	}
+	if p.ValueFromSecret != nil {
+		errs = append(errs, validation.AtPath("valueFromSecret", p.ValueFromSecret.Validate()))
+	}
+	if p.ValueFromCommand != nil {
+		errs = append(errs, validation.AtPath("valueFromCommand", p.ValueFromCommand.Validate()))
+	}
+
+	return errors.Join(errs...)
 }
 
-func (p *Placeholder) loadValueFromFile() error {
+// Returns the content of the file, or the value at the key of the JSON or
+// YAML file if a key is set
+func (p *Placeholder) loadValueFromFile() (string, error) {
 	if p.ValueFromFile == "" {
 		panic("ValueFromFile not set, cannot read file")
 	}
@@ -34,29 +159,91 @@ func (p *Placeholder) loadValueFromFile() error {
 	data, err := os.ReadFile(p.ValueFromFile)
 
 	if err != nil {
-		return fmt.Errorf("could not read file: %w", err)
+		return "", fmt.Errorf("could not read file: %w", err)
 	}
 
-	p.Value = string(data)
+	value := string(data)
+	if p.Key != "" {
+		if value, err = lookupFileKey(data, p.Key); err != nil {
+			return "", fmt.Errorf("key %s in file %s: %w", p.Key, p.ValueFromFile, err)
+		}
+	}
 
-	if p.Value == "" {
-		return fmt.Errorf("value empty after loading from file %s", p.ValueFromFile)
+	if value == "" {
+		return "", fmt.Errorf("value empty after loading from file %s", p.ValueFromFile)
 	}
 
-	return nil
+	return value, nil
 }
 
-func (p *Placeholder) ToFlywayArg() (string, error) {
+// Returns the scalar at the key path of a JSON or YAML document, with the
+// same key paths as JSON secrets, e.g db.roles[0].password
+func lookupFileKey(data []byte, key string) (string, error) {
+	var document any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return "", fmt.Errorf("file is neither JSON nor YAML: %w", err)
+	}
+
+	value, err := (&sp.Secret{JSON: document}).Get(key)
+	if err != nil {
+		return "", err
+	}
+	return sp.CoerceString(value)
+}
+
+// Fetches the value of the secret reference, caching the secret for the run
+func (p *Placeholder) fetchSecret() (string, error) {
+	provider, err := cp.NewSecretsProvider(p.ValueFromSecret)
+	if err != nil {
+		return "", err
+	}
+	key := p.ValueFromSecret.ProviderKey()
+	source := &cp.ValueSource{Secret: p.ValueFromSecret}
+	return source.Resolve(map[string]sp.SecretsProvider{key: sp.DefaultSecretCache.Wrap(key, provider)})
+}
+
+// Returns the value of the placeholder, loading it from its source if set,
+// encoded as the type of the placeholder. The value is only resolved once
+func (p *Placeholder) resolveValue() (string, error) {
+	if p.resolved != nil {
+		return *p.resolved, nil
+	}
+
 	err := p.Validate()
 	if err != nil {
 		return "", err
 	}
 
-	if p.ValueFromFile != "" {
-		if err := p.loadValueFromFile(); err != nil {
-			return "", err
-		}
+	var value string
+	switch {
+	case p.ValueFromFile != "":
+		value, err = p.loadValueFromFile()
+	case p.ValueFromEnv != "":
+		value, err = (&cp.ValueSource{Env: p.ValueFromEnv}).Resolve(nil)
+	case p.ValueFromSecret != nil:
+		value, err = p.fetchSecret()
+	case p.ValueFromCommand != nil:
+		value, err = p.ValueFromCommand.run()
+	default:
+		value = p.Value
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve placeholder %s: %w", p.Name, err)
+	}
+
+	encoded, err := p.encode(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid value of placeholder %s: %w", p.Name, err)
+	}
+	p.resolved = &encoded
+	return encoded, nil
+}
+
+func (p *Placeholder) ToFlywayArg() (string, error) {
+	value, err := p.resolveValue()
+	if err != nil {
+		return "", err
 	}
 
-	return fmt.Sprintf("-placeholders.%s=%s", p.Name, p.Value), nil
+	return fmt.Sprintf("-placeholders.%s=%s", p.Name, value), nil
 }
@@ -3,12 +3,37 @@ package migrator
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
+	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 )
 
+// Secrets provider serving the secrets by name
+type fakeSecretsProvider map[string]string
+
+func (f fakeSecretsProvider) GetSecret(name string) (*sp.Secret, error) {
+	value, ok := f[name]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", name)
+	}
+	return sp.NewSecret(value), nil
+}
+
+// Makes secret references use the fake secrets provider during the test
+func useFakeSecretsProvider(t *testing.T, secrets fakeSecretsProvider) {
+	sp.DefaultSecretCache.Clear()
+	cp.NewSecretsProvider = func(ref *sp.SecretRef) (sp.SecretsProvider, error) { return secrets, nil }
+	t.Cleanup(func() {
+		cp.NewSecretsProvider = sp.NewSecretsProvider
+		sp.DefaultSecretCache.Clear()
+	})
+}
+
 func Test_Placeholder_Validate_ReturnsWithoutErrorWithValueSet(t *testing.T) {
 	p := Placeholder{
 		Name:  "test",
@@ -109,8 +134,9 @@ func Test_Placeholder_loadValueFromFile_Succeeds(t *testing.T) {
 		ValueFromFile: path,
 	}
 
-	assert.NoError(p.loadValueFromFile())
-	assert.Equal(p.Value, "test-data")
+	value, err := p.loadValueFromFile()
+	assert.NoError(err)
+	assert.Equal(value, "test-data")
 }
 
 func Test_Placeholder_loadValueFromFile_FailsIfValueFromFileNotSet(t *testing.T) {
@@ -139,7 +165,8 @@ func Test_Placeholder_loadValueFromFile_FailsIfFileEmpty(t *testing.T) {
 		ValueFromFile: path,
 	}
 
-	assert.Error(p.loadValueFromFile())
+	_, err = p.loadValueFromFile()
+	assert.Error(err)
 }
 
 func Test_Placeholder_loadValueFromFile_FailsIfFileDoesNotExist(t *testing.T) {
@@ -150,7 +177,8 @@ func Test_Placeholder_loadValueFromFile_FailsIfFileDoesNotExist(t *testing.T) {
 	}
 
 	assert := assert.New(t)
-	assert.Error(p.loadValueFromFile())
+	_, err := p.loadValueFromFile()
+	assert.Error(err)
 }
 
 func Test_Placeholder_ToFlywayEnv_GetsArgRepresentationFromValue(t *testing.T) {
@@ -222,3 +250,120 @@ func Test_Placeholder_ToFlywayEnv_FailsOnFileLoadError(t *testing.T) {
 	_, err := p.ToFlywayArg()
 	assert.Error(err)
 }
+
+func Test_Placeholder_Validate_FailsWithSeveralSources(t *testing.T) {
+	p := &Placeholder{Name: "test", Value: "a", ValueFromEnv: "B"}
+
+	assert := assert.New(t)
+	assert.ErrorContains(p.Validate(), "value: only one of")
+}
+
+func Test_Placeholder_Validate_ReportsEveryProblemAtItsPath(t *testing.T) {
+	p := &Placeholder{Value: "a", ValueFromEnv: "B", Key: "k", Type: "text", Enum: []string{"a", "b"}, ValueFromCommand: &PlaceholderCommand{}}
+
+	paths := []string{}
+	for _, err := range validation.Errors(p.Validate()) {
+		var fieldErr *validation.FieldError
+		if assert.ErrorAs(t, err, &fieldErr) {
+			paths = append(paths, fieldErr.Path)
+		}
+	}
+	assert.Equal(t, []string{"name", "value", "type", "key", "valueFromCommand.command"}, paths)
+}
+
+func Test_Placeholder_Validate_FailsWithKeyWithoutFile(t *testing.T) {
+	p := &Placeholder{Name: "test", ValueFromEnv: "B", Key: "a"}
+
+	assert := assert.New(t)
+	assert.ErrorContains(p.Validate(), "'key' of placeholder test requires 'valueFromFile'")
+}
+
+func Test_Placeholder_Validate_FailsWithInvalidSecretAndCommand(t *testing.T) {
+	assert := assert.New(t)
+	assert.Error((&Placeholder{Name: "test", ValueFromSecret: &sp.SecretRef{}}).Validate())
+	assert.ErrorContains((&Placeholder{Name: "test", ValueFromCommand: &PlaceholderCommand{}}).Validate(), "'command' cannot be empty")
+	assert.ErrorContains((&Placeholder{Name: "test", ValueFromCommand: &PlaceholderCommand{Command: []string{"echo"}, Timeout: "-1s"}}).Validate(), "invalid 'timeout'")
+}
+
+func Test_Placeholder_resolveValue_ReadsEnv(t *testing.T) {
+	t.Setenv("PLACEHOLDER_TEST", "from-env")
+
+	assert := assert.New(t)
+	value, err := (&Placeholder{Name: "test", ValueFromEnv: "PLACEHOLDER_TEST"}).resolveValue()
+	assert.NoError(err)
+	assert.Equal("from-env", value)
+
+	_, err = (&Placeholder{Name: "test", ValueFromEnv: "PLACEHOLDER_TEST_MISSING"}).resolveValue()
+	assert.ErrorContains(err, "failed to resolve placeholder test: environment variable PLACEHOLDER_TEST_MISSING not set")
+}
+
+func Test_Placeholder_resolveValue_FetchesSecret(t *testing.T) {
+	useFakeSecretsProvider(t, fakeSecretsProvider{"app": `{"roles": {"app": {"password": "s3cret"}}}`, "plain": "text"})
+
+	assert := assert.New(t)
+	value, err := (&Placeholder{Name: "app_pw", ValueFromSecret: &sp.SecretRef{SecretName: "app", SecretKey: "roles.app.password"}}).resolveValue()
+	assert.NoError(err)
+	assert.Equal("s3cret", value)
+
+	value, err = (&Placeholder{Name: "plain", ValueFromSecret: &sp.SecretRef{SecretName: "plain"}}).resolveValue()
+	assert.NoError(err)
+	assert.Equal("text", value)
+
+	_, err = (&Placeholder{Name: "missing", ValueFromSecret: &sp.SecretRef{SecretName: "missing"}}).resolveValue()
+	assert.ErrorContains(err, "secret missing not found")
+}
+
+func Test_Placeholder_resolveValue_RunsCommand(t *testing.T) {
+	assert := assert.New(t)
+	value, err := (&Placeholder{Name: "test", ValueFromCommand: &PlaceholderCommand{Command: []string{"echo", "from command"}}}).resolveValue()
+	assert.NoError(err)
+	assert.Equal("from command", value)
+}
+
+func Test_Placeholder_resolveValue_FailsOnFailingCommand(t *testing.T) {
+	assert := assert.New(t)
+	_, err := (&Placeholder{Name: "test", ValueFromCommand: &PlaceholderCommand{Command: []string{"sh", "-c", "echo oops >&2; exit 3"}}}).resolveValue()
+	assert.ErrorContains(err, "command sh failed: exit status 3: oops")
+
+	_, err = (&Placeholder{Name: "test", ValueFromCommand: &PlaceholderCommand{Command: []string{"true"}}}).resolveValue()
+	assert.ErrorContains(err, "command true printed no value")
+}
+
+func Test_Placeholder_resolveValue_FailsOnCommandTimeout(t *testing.T) {
+	p := &Placeholder{Name: "test", ValueFromCommand: &PlaceholderCommand{Command: []string{"sleep", "5"}, Timeout: "50ms"}}
+
+	assert := assert.New(t)
+	_, err := p.resolveValue()
+	assert.ErrorContains(err, "command sleep timed out after 50ms")
+}
+
+func Test_Placeholder_resolveValue_ReadsKeyOfStructuredFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"vars.json": `{"roles": [{"name": "app", "password": "json-pw"}], "port": 5432}`,
+		"vars.yaml": "roles:\n  - name: app\n    password: yaml-pw\nport: 5432\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert := assert.New(t)
+	for name, expected := range map[string]string{"vars.json": "json-pw", "vars.yaml": "yaml-pw"} {
+		path := filepath.Join(dir, name)
+		value, err := (&Placeholder{Name: "pw", ValueFromFile: path, Key: "roles[0].password"}).resolveValue()
+		assert.NoError(err)
+		assert.Equal(expected, value)
+
+		value, err = (&Placeholder{Name: "port", ValueFromFile: path, Key: "port"}).resolveValue()
+		assert.NoError(err)
+		assert.Equal("5432", value)
+
+		_, err = (&Placeholder{Name: "roles", ValueFromFile: path, Key: "roles"}).resolveValue()
+		assert.ErrorContains(err, "cannot use array as a string")
+
+		_, err = (&Placeholder{Name: "missing", ValueFromFile: path, Key: "missing"}).resolveValue()
+		assert.ErrorContains(err, "key missing in file "+path)
+	}
+}
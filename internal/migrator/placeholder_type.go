@@ -0,0 +1,175 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	js "github.com/sourcehawk/go-flyway/internal/json_schema"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
+
+// How the value of a placeholder is put into the SQL of the migrations
+type PlaceholderType string
+
+const (
+	// The value as is, the default
+	PlaceholderRaw PlaceholderType = "raw"
+	// A quoted identifier, e.g "app_owner"
+	PlaceholderIdentifier PlaceholderType = "identifier"
+	// A quoted string literal, e.g 'it''s'
+	PlaceholderString PlaceholderType = "string"
+	// A decimal integer
+	PlaceholderInteger PlaceholderType = "integer"
+	// true or false
+	PlaceholderBoolean PlaceholderType = "boolean"
+)
+
+var placeholderTypes = []PlaceholderType{PlaceholderRaw, PlaceholderIdentifier, PlaceholderString, PlaceholderInteger, PlaceholderBoolean}
+
+// PostgreSQL truncates longer identifiers
+const maxIdentifierLength = 63
+
+// Restricts the type to the known types
+func (PlaceholderType) ExtendJSONSchema(s *js.Schema) {
+	for _, t := range placeholderTypes {
+		s.Enum = append(s.Enum, string(t))
+	}
+}
+
+// Validates that the type is known, an empty type is the raw type
+func (t PlaceholderType) Validate() error {
+	if t == "" || slices.Contains(placeholderTypes, t) {
+		return nil
+	}
+	return fmt.Errorf("%s is not one of raw, identifier, string, integer, boolean", t)
+}
+
+// Encodes the value as the type for use in SQL
+func (t PlaceholderType) encode(value string) (string, error) {
+	if t != PlaceholderRaw && t != "" && strings.ContainsRune(value, 0) {
+		return "", fmt.Errorf("value contains a NUL character")
+	}
+
+	switch t {
+	case PlaceholderIdentifier:
+		if value == "" {
+			return "", fmt.Errorf("value is not an identifier, it is empty")
+		}
+		if len(value) > maxIdentifierLength {
+			return "", fmt.Errorf("value is not an identifier, it is longer than %d bytes", maxIdentifierLength)
+		}
+		return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`, nil
+	case PlaceholderString:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'", nil
+	case PlaceholderInteger:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("value is not an integer")
+		}
+		return strconv.FormatInt(i, 10), nil
+	case PlaceholderBoolean:
+		switch strings.ToLower(value) {
+		case "true", "false":
+			return strings.ToLower(value), nil
+		default:
+			return "", fmt.Errorf("value is not a boolean, expected true or false")
+		}
+	default:
+		return value, nil
+	}
+}
+
+// Which whitespace is removed from the value of a placeholder
+type PlaceholderTrim string
+
+const (
+	// Keeps the value as is, the default of raw placeholders
+	PlaceholderTrimNone PlaceholderTrim = "none"
+	// Removes trailing newlines, e.g the last line break of a file
+	PlaceholderTrimNewlines PlaceholderTrim = "newlines"
+	// Removes leading and trailing whitespace, the default of typed placeholders
+	PlaceholderTrimSpace PlaceholderTrim = "space"
+)
+
+// Restricts the trim to the known modes
+func (PlaceholderTrim) ExtendJSONSchema(s *js.Schema) {
+	s.Enum = []string{string(PlaceholderTrimNone), string(PlaceholderTrimNewlines), string(PlaceholderTrimSpace)}
+}
+
+// Validates that the trim is known, an empty trim is the default of the type
+func (t PlaceholderTrim) Validate() error {
+	switch t {
+	case "", PlaceholderTrimNone, PlaceholderTrimNewlines, PlaceholderTrimSpace:
+		return nil
+	default:
+		return fmt.Errorf("%s is not one of %s, %s, %s", t, PlaceholderTrimNone, PlaceholderTrimNewlines, PlaceholderTrimSpace)
+	}
+}
+
+func (t PlaceholderTrim) apply(value string, placeholderType PlaceholderType) string {
+	if t == "" && placeholderType != "" && placeholderType != PlaceholderRaw {
+		t = PlaceholderTrimSpace
+	}
+	switch t {
+	case PlaceholderTrimNewlines:
+		return strings.TrimRight(value, "\r\n")
+	case PlaceholderTrimSpace:
+		return strings.TrimSpace(value)
+	default:
+		return value
+	}
+}
+
+// Validates the type, trim and constraints of the placeholder
+func (p *Placeholder) validateConstraints() error {
+	errs := []error{}
+
+	if err := p.Type.Validate(); err != nil {
+		errs = append(errs, validation.Errorf("type", "invalid 'type' of placeholder %s: %w", p.Name, err))
+	}
+	if err := p.Trim.Validate(); err != nil {
+		errs = append(errs, validation.Errorf("trim", "invalid 'trim' of placeholder %s: %w", p.Name, err))
+	}
+	if p.Pattern != "" {
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			errs = append(errs, validation.Errorf("pattern", "invalid 'pattern' of placeholder %s: %w", p.Name, err))
+		}
+	}
+	for i, value := range p.Enum {
+		if _, err := p.Type.encode(value); err != nil {
+			errs = append(errs, validation.Errorf(fmt.Sprintf("enum[%d]", i), "invalid 'enum' of placeholder %s: %w", p.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns whether the value is trimmed, checked or encoded before it is used
+func (p *Placeholder) constrained() bool {
+	return (p.Type != "" && p.Type != PlaceholderRaw) || p.Trim != "" || p.Pattern != "" || len(p.Enum) > 0
+}
+
+// Trims the value, checks it against the constraints of the placeholder and
+// encodes it as its type. Values are not part of the errors, as they may be secrets
+func (p *Placeholder) encode(value string) (string, error) {
+	value = p.Trim.apply(value, p.Type)
+
+	if p.Pattern != "" {
+		pattern, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+		if err != nil {
+			return "", err
+		}
+		if !pattern.MatchString(value) {
+			return "", fmt.Errorf("value does not match the pattern %s", p.Pattern)
+		}
+	}
+	if len(p.Enum) > 0 && !slices.Contains(p.Enum, value) {
+		return "", fmt.Errorf("value is not one of %s", strings.Join(p.Enum, ", "))
+	}
+
+	return p.Type.encode(value)
+}
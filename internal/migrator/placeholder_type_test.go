@@ -0,0 +1,108 @@
+package migrator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PlaceholderType_Validate_RejectsUnknownTypes(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(PlaceholderType("").Validate())
+	assert.NoError(PlaceholderIdentifier.Validate())
+	assert.EqualError(PlaceholderType("text").Validate(), "text is not one of raw, identifier, string, integer, boolean")
+}
+
+func Test_PlaceholderType_encode_QuotesIdentifiersAndLiterals(t *testing.T) {
+	assert := assert.New(t)
+	for _, c := range []struct {
+		placeholderType PlaceholderType
+		value           string
+		expected        string
+	}{
+		{PlaceholderRaw, "'; DROP TABLE x; --", "'; DROP TABLE x; --"},
+		{"", "a b", "a b"},
+		{PlaceholderIdentifier, "app_owner", `"app_owner"`},
+		{PlaceholderIdentifier, `x"; DROP TABLE t; --`, `"x""; DROP TABLE t; --"`},
+		{PlaceholderString, "it's", "'it''s'"},
+		{PlaceholderString, `a\b`, `'a\b'`},
+		{PlaceholderString, "", "''"},
+		{PlaceholderInteger, "-042", "-42"},
+		{PlaceholderBoolean, "TRUE", "true"},
+		{PlaceholderBoolean, "false", "false"},
+	} {
+		encoded, err := c.placeholderType.encode(c.value)
+		assert.NoError(err)
+		assert.Equal(c.expected, encoded)
+	}
+}
+
+func Test_PlaceholderType_encode_RejectsInvalidValues(t *testing.T) {
+	assert := assert.New(t)
+	for _, c := range []struct {
+		placeholderType PlaceholderType
+		value           string
+		err             string
+	}{
+		{PlaceholderIdentifier, "", "value is not an identifier, it is empty"},
+		{PlaceholderIdentifier, string(make([]byte, 64)), "value contains a NUL character"},
+		{PlaceholderIdentifier, "a234567890123456789012345678901234567890123456789012345678901234", "longer than 63 bytes"},
+		{PlaceholderString, "a\x00b", "value contains a NUL character"},
+		{PlaceholderInteger, "1; DROP TABLE t", "value is not an integer"},
+		{PlaceholderInteger, "1.5", "value is not an integer"},
+		{PlaceholderBoolean, "yes", "value is not a boolean, expected true or false"},
+	} {
+		_, err := c.placeholderType.encode(c.value)
+		assert.ErrorContains(err, c.err, c.value)
+	}
+}
+
+func Test_PlaceholderTrim_apply_DefaultsByType(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(" a\n", PlaceholderTrim("").apply(" a\n", PlaceholderRaw))
+	assert.Equal("a", PlaceholderTrim("").apply(" a\n", PlaceholderString))
+	assert.Equal(" a\n", PlaceholderTrimNone.apply(" a\n", PlaceholderString))
+	assert.Equal(" a", PlaceholderTrimNewlines.apply(" a\r\n\n", PlaceholderRaw))
+	assert.Equal("a", PlaceholderTrimSpace.apply("\t a \n", PlaceholderRaw))
+}
+
+func Test_Placeholder_Validate_ChecksConstraints(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError((&Placeholder{Name: "p", Value: "app", Type: PlaceholderIdentifier, Pattern: "[a-z_]+", Enum: []string{"app", "web"}}).Validate())
+	assert.ErrorContains((&Placeholder{Name: "p", Value: "a", Type: "text"}).Validate(), "invalid 'type' of placeholder p")
+	assert.ErrorContains((&Placeholder{Name: "p", Value: "a", Trim: "all"}).Validate(), "invalid 'trim' of placeholder p")
+	assert.ErrorContains((&Placeholder{Name: "p", Value: "a", Pattern: "("}).Validate(), "invalid 'pattern' of placeholder p")
+	assert.ErrorContains((&Placeholder{Name: "p", Value: "1", Type: PlaceholderInteger, Enum: []string{"1", "two"}}).Validate(), "invalid 'enum' of placeholder p: value is not an integer")
+	assert.ErrorContains((&Placeholder{Name: "p", Value: "App", Pattern: "[a-z]+"}).Validate(), "invalid 'value' of placeholder p: value does not match the pattern [a-z]+")
+	assert.ErrorContains((&Placeholder{Name: "p", Value: "apps", Pattern: "app"}).Validate(), "does not match the pattern app")
+	assert.ErrorContains((&Placeholder{Name: "p", Value: "db", Enum: []string{"app", "web"}}).Validate(), "value is not one of app, web")
+	assert.ErrorContains((&Placeholder{Name: "p", Value: "x", Type: PlaceholderInteger}).Validate(), "value is not an integer")
+}
+
+func Test_Placeholder_resolveValue_EncodesValuesFromSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "owner.txt")
+	if err := os.WriteFile(path, []byte("app_owner\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PLACEHOLDER_PORT", " 5432 ")
+	t.Setenv("PLACEHOLDER_ROLE", "admin")
+
+	assert := assert.New(t)
+	value, err := (&Placeholder{Name: "owner", ValueFromFile: path, Type: PlaceholderIdentifier}).resolveValue()
+	assert.NoError(err)
+	assert.Equal(`"app_owner"`, value)
+
+	value, err = (&Placeholder{Name: "owner", ValueFromFile: path, Trim: PlaceholderTrimNewlines}).resolveValue()
+	assert.NoError(err)
+	assert.Equal("app_owner", value)
+
+	value, err = (&Placeholder{Name: "port", ValueFromEnv: "PLACEHOLDER_PORT", Type: PlaceholderInteger}).resolveValue()
+	assert.NoError(err)
+	assert.Equal("5432", value)
+
+	_, err = (&Placeholder{Name: "role", ValueFromEnv: "PLACEHOLDER_ROLE", Enum: []string{"reader", "writer"}}).resolveValue()
+	assert.EqualError(err, "invalid value of placeholder role: value is not one of reader, writer")
+	assert.NotContains(err.Error(), "admin")
+}
@@ -1,10 +1,17 @@
 package migrator
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"os/exec"
 	"strings"
+
+	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
 )
 
 type CommandFuncType func(name string, arg ...string) *exec.Cmd
@@ -13,112 +20,245 @@ type Schema struct {
 	// Name of the schema
 	Name string `yaml:"name"`
 	// Path where the migration files live
-	MigrationsPath string `yaml:"migrationsPath"`
+	MigrationsPath string `yaml:"migrationsPath,omitempty"`
+	// Further paths where migration files live, passed to flyway after migrationsPath
+	MigrationsPaths []string `yaml:"migrationsPaths,omitempty"`
 	// Arguments to pass to flyway
 	FlywayArgs []string `yaml:"flywayArgs,omitempty"`
+	// Settings to pass to flyway by name
+	Flyway FlywaySettings `yaml:"flyway,omitempty"`
 	// Placeholders for the migrations in the schema
 	Placeholders []*Placeholder `yaml:"placeholders,omitempty"`
 	// Database credentials
 	Credentials *Credentials `yaml:"credentials,omitempty"`
+	// Whether the schema is migrated, defaults to true
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// Policy of the migrator for the flyway settings of the schema
+	policy *FlywayPolicy
+	// How the migrator passes the configuration of the schema to flyway
+	configMode FlywayConfigMode
+	// Directories of the migrator appended to the locations of the schema
+	sharedLocations []string
 }
 
-func (s *Schema) Validate() error {
+// Returns whether the schema is migrated. Disabled schemas are neither
+// validated nor migrated
+func (s *Schema) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// Validates the structure of the schema configuration without resolving
+// credentials or contacting secret stores
+func (s *Schema) ValidateConfig() error {
+	if err := s.validateStructure(); err != nil {
+		return err
+	}
+
+	return validation.AtPath("credentials", s.Credentials.ValidateConfig())
+}
+
+// Validates the schema configuration except for the credentials configuration
+func (s *Schema) validateStructure() error {
+	errs := []error{}
+
 	if s.Name == "" {
-		return fmt.Errorf("missing 'name' in schema")
+		errs = append(errs, validation.Errorf("name", "missing 'name' in schema"))
 	}
 
-	if s.MigrationsPath == "" {
-		return fmt.Errorf("missing 'migrationsPath' in schema")
+	if s.MigrationsPath == "" && len(s.MigrationsPaths) == 0 {
+		errs = append(errs, validation.Errorf("migrationsPath", "missing 'migrationsPath' or 'migrationsPaths' in schema"))
+	}
+	for i, path := range s.MigrationsPaths {
+		if path == "" {
+			errs = append(errs, validation.Errorf(fmt.Sprintf("migrationsPaths[%d]", i), "empty path in 'migrationsPaths' of schema %s", s.Name))
+		}
 	}
 
 	if s.Credentials == nil {
-		return fmt.Errorf("missing credentials for schema %s", s.Name)
+		errs = append(errs, validation.Errorf("credentials", "missing credentials for schema %s", s.Name))
 	}
 
-	// prefetch so that we get an error at config load time
-	// in case of problematic config
-	if _, err := s.Credentials.FetchCredentials(); err != nil {
-		return err
+	errs = append(errs, validation.AtPath("flywayArgs", validateFlywayArgs(s.FlywayArgs)))
+	errs = append(errs, validation.AtPath("flyway", s.Flyway.Validate()))
+	errs = append(errs, checkFlywayConflicts(s.FlywayArgs, s.Flyway))
+	errs = append(errs, s.policy.Check(s.FlywayArgs, s.Flyway))
+
+	for i, p := range s.Placeholders {
+		errs = append(errs, validation.AtPath(fmt.Sprintf("placeholders[%d]", i), p.Validate()))
 	}
 
-	for _, arg := range s.FlywayArgs {
-		kv := strings.Split(arg, "=")
-		if len(kv) != 2 {
-			return fmt.Errorf(
-				"flyway argument %s cannot be interpreted. "+
-					"Ensure format is key=value with no extra '='",
-				arg,
-			)
-		}
-		if len(kv[0]) < 2 || len(kv[1]) < 1 {
-			return fmt.Errorf(
-				"flyway argument %s cannot be interpreted. "+
-					"Ensure format is -key=value",
-				arg,
-			)
+	return errors.Join(errs...)
+}
+
+// Validates the syntax of every flyway argument
+func validateFlywayArgs(args []string) error {
+	errs := []error{}
+
+	for i, arg := range args {
+		if _, _, ok := cutFlywayArg(arg); ok {
+			continue
 		}
-		if kv[0][:1] != "-" {
-			return fmt.Errorf(
+		path := fmt.Sprintf("[%d]", i)
+		if strings.Contains(arg, "=") && !strings.HasPrefix(arg, "-") {
+			errs = append(errs, validation.Errorf(path,
 				"flyway argument %s cannot be interpreted. "+
 					"Must start with a dash (-)",
 				arg,
-			)
+			))
+			continue
 		}
+		errs = append(errs, validation.Errorf(path,
+			"flyway argument %s cannot be interpreted. "+
+				"Ensure format is -key=value",
+			arg,
+		))
 	}
 
-	for _, p := range s.Placeholders {
-		if err := p.Validate(); err != nil {
-			return err
+	return errors.Join(errs...)
+}
+
+func (s *Schema) Validate() error {
+	if err := s.ValidateConfig(); err != nil {
+		return err
+	}
+
+	if err := s.checkFiles("", nil); err != nil {
+		return err
+	}
+	for _, dir := range s.sharedLocations {
+		if err := checkDirectory(dir); err != nil {
+			return fmt.Errorf("invalid shared location of schema %s: %w", s.Name, err)
 		}
 	}
 
+	// prefetch so that we get an error at config load time
+	// in case of problematic config
+	if _, err := s.Credentials.FetchCredentials(); err != nil {
+		return validation.AtPath("credentials", err)
+	}
+
 	return nil
 }
 
-// Add arguments to the current flyway args, retaining the current ones if there is a key clash
+// Checks that the files referred to by the schema exist.
 //
-// Ignores any invalid arguments
-func (s *Schema) SetDefaultFlywayArgs(args []string) error {
-	// Note that it is important that the flyway args are the tail of the array
-	// Otherwise the default args would overwrite the concrete ones for the schema
-	allArgs := append(args, s.FlywayArgs...)
+// configPath is the path of the schema in the configuration, e.g schemas[0],
+// and unresolved holds the configuration paths of values that are not checked
+// because they contain unresolved references
+func (s *Schema) checkFiles(configPath string, unresolved map[string]bool) error {
+	errs := []error{}
 
-	set := make(map[string]string, len(s.FlywayArgs))
+	if s.MigrationsPath != "" && !unresolved[validation.JoinPath(configPath, "migrationsPath")] {
+		if err := checkDirectory(s.MigrationsPath); err != nil {
+			errs = append(errs, validation.Errorf("migrationsPath", "invalid 'migrationsPath' in schema %s: %w", s.Name, err))
+		}
+	}
 
-	for _, arg := range allArgs {
-		kv := strings.Split(arg, "=")
-		if len(kv) != 2 {
-			return fmt.Errorf(
-				"flyway argument %s cannot be interpreted. "+
-					"Ensure format is key=value with no extra '='",
-				arg,
-			)
+	for i, dir := range s.MigrationsPaths {
+		path := fmt.Sprintf("migrationsPaths[%d]", i)
+		if dir == "" || unresolved[validation.JoinPath(configPath, path)] {
+			continue
 		}
-		for _, arg := range s.FlywayArgs {
-			kv := strings.Split(arg, "=")
-			if len(kv) != 2 {
-				return fmt.Errorf(
-					"flyway argument %s cannot be interpreted. "+
-						"Ensure format is -key=value with no extra '='",
-					arg,
-				)
-			}
+		if err := checkDirectory(dir); err != nil {
+			errs = append(errs, validation.Errorf(path, "invalid 'migrationsPaths' in schema %s: %w", s.Name, err))
 		}
-		set[kv[0]] = kv[1]
 	}
 
-	newArgs := make([]string, len(set))
-	idx := 0
+	for i, p := range s.Placeholders {
+		path := fmt.Sprintf("placeholders[%d].valueFromFile", i)
+		if p.ValueFromFile == "" || unresolved[validation.JoinPath(configPath, path)] {
+			continue
+		}
+		if _, err := os.Stat(p.ValueFromFile); err != nil {
+			errs = append(errs, validation.Errorf(path, "invalid 'valueFromFile' of placeholder %s in schema %s: %w", p.Name, s.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Checks that the path is an existing directory
+func checkDirectory(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
 
-	for key, value := range set {
-		newArgs[idx] = fmt.Sprintf("%s=%s", key, value)
-		idx++
+// Returns the flyway locations of the schema, its migrations paths followed
+// by the shared locations of the migrator
+func (s *Schema) locations() []string {
+	dirs := []string{}
+	if s.MigrationsPath != "" {
+		dirs = append(dirs, s.MigrationsPath)
 	}
+	dirs = append(dirs, s.MigrationsPaths...)
+	dirs = append(dirs, s.sharedLocations...)
 
-	s.FlywayArgs = newArgs
+	locations := make([]string, len(dirs))
+	for i, dir := range dirs {
+		locations[i] = "filesystem:" + dir
+	}
+	return locations
+}
+
+// Adds the default flyway arguments whose keys the schema does not define,
+// either as an argument or as a setting, in their order before the arguments
+// of the schema
+func (s *Schema) SetDefaultFlywayArgs(args []string) error {
+	defined := make(map[string]bool, len(s.FlywayArgs)+len(s.Flyway))
+	for _, arg := range s.FlywayArgs {
+		key, _, ok := cutFlywayArg(arg)
+		if !ok {
+			return fmt.Errorf("flyway argument %s cannot be interpreted. Ensure format is -key=value", arg)
+		}
+		defined[key] = true
+	}
+	for name := range s.Flyway {
+		defined[name] = true
+	}
+
+	defaults := []string{}
+	for _, arg := range args {
+		key, _, ok := cutFlywayArg(arg)
+		if !ok {
+			return fmt.Errorf("flyway argument %s cannot be interpreted. Ensure format is -key=value", arg)
+		}
+		if !defined[key] {
+			defaults = append(defaults, arg)
+			defined[key] = true
+		}
+	}
+
+	s.FlywayArgs = append(defaults, s.FlywayArgs...)
 	return nil
 }
 
+// Adds the default flyway settings that the schema does not define, either
+// as a setting or as an argument
+func (s *Schema) SetDefaultFlywaySettings(settings FlywaySettings) {
+	defined := map[string]bool{}
+	for _, arg := range s.FlywayArgs {
+		if key, _, ok := cutFlywayArg(arg); ok {
+			defined[key] = true
+		}
+	}
+
+	for name, value := range settings {
+		if _, ok := s.Flyway[name]; ok || defined[name] {
+			continue
+		}
+		if s.Flyway == nil {
+			s.Flyway = FlywaySettings{}
+		}
+		s.Flyway[name] = value
+	}
+}
+
 func (s *Schema) ensureFlyway(commandExecutor CommandFuncType) error {
 	cmd := commandExecutor("flyway")
 	if err := cmd.Run(); err != nil {
@@ -141,33 +281,110 @@ func (s *Schema) Migrate(commandExecutor CommandFuncType) error {
 		return err
 	}
 
+	output, err := s.runMigration(commandExecutor, creds)
+
+	// the secret may have been rotated since the credentials were fetched
+	if err != nil && isAuthFailure(output) {
+		log.Printf("flyway could not authenticate for schema %s, refreshing credentials and retrying", s.Name)
+		fresh, refreshErr := s.Credentials.refreshCredentials(creds)
+		if refreshErr != nil {
+			return fmt.Errorf("flyway migration failed: %w", errors.Join(err, refreshErr))
+		}
+		_, err = s.runMigration(commandExecutor, fresh)
+	}
+
+	if err != nil {
+		return fmt.Errorf("flyway migration failed: %w", err)
+	}
+
+	return nil
+}
+
+// Runs the flyway migration with the given credentials.
+//
+// The output of flyway is printed and also returned
+func (s *Schema) runMigration(commandExecutor CommandFuncType, creds *cp.DatabaseCredentials) ([]byte, error) {
+	var allArgs []string
+	if s.configMode == FlywayConfigToml {
+		configFile, err := s.writeFlywayToml(creds)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(configFile)
+
+		allArgs = append([]string{"-configFiles=" + configFile}, s.FlywayArgs...)
+		allArgs = append(allArgs, "migrate")
+	} else {
+		var err error
+		if allArgs, err = s.flywayCommandArgs(creds); err != nil {
+			return nil, err
+		}
+	}
+
+	var output bytes.Buffer
+	cmd := commandExecutor("flyway", allArgs...)
+	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+
+	err := cmd.Run()
+	return output.Bytes(), err
+}
+
+// Returns the arguments of the flyway migrate command that pass the whole
+// configuration of the schema on the command line
+func (s *Schema) flywayCommandArgs(creds *cp.DatabaseCredentials) ([]string, error) {
+	settingArgs, err := s.Flyway.Args()
+	if err != nil {
+		return nil, err
+	}
+
 	allArgs := []string{}
 	allArgs = append(allArgs, s.FlywayArgs...)
+	allArgs = append(allArgs, settingArgs...)
 
+	placeholderArgs := []string{}
 	for _, p := range s.Placeholders {
 		pArg, err := p.ToFlywayArg()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		allArgs = append(allArgs, pArg)
+		placeholderArgs = append(placeholderArgs, pArg)
 	}
+	allArgs = append(allArgs, withoutOverridden(placeholderArgs, allArgs)...)
 
 	defaultArgs := []string{
 		fmt.Sprintf("-user=%s", creds.Username),
 		fmt.Sprintf("-password=%s", creds.Password),
-		fmt.Sprintf("-url=jdbc:postgresql://%s:%d/%s", creds.Host, creds.Port, creds.Database),
+		fmt.Sprintf("-url=%s", jdbcURL(creds)),
 		fmt.Sprintf("-schemas=%s", s.Name),
-		fmt.Sprintf("-locations=filesystem:%s", s.MigrationsPath),
-		"migrate",
+		fmt.Sprintf("-locations=%s", strings.Join(s.locations(), ",")),
 	}
-	allArgs = append(allArgs, defaultArgs...)
-	cmd := commandExecutor("flyway", allArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	allArgs = append(allArgs, withoutOverridden(defaultArgs, allArgs)...)
+	allArgs = append(allArgs, "migrate")
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("flyway migration failed: %w", err)
+	return allArgs, nil
+}
+
+// Returns the JDBC url of the database of the credentials
+func jdbcURL(creds *cp.DatabaseCredentials) string {
+	return fmt.Sprintf("jdbc:postgresql://%s:%d/%s", creds.Host, creds.Port, creds.Database)
+}
+
+// Returns the managed arguments whose settings are not overridden by the
+// given arguments, which the flyway policy may allow
+func withoutOverridden(managed []string, args []string) []string {
+	overridden := map[string]bool{}
+	for _, arg := range args {
+		if key, _, ok := cutFlywayArg(arg); ok {
+			overridden[key] = true
+		}
 	}
 
-	return nil
+	kept := []string{}
+	for _, arg := range managed {
+		if key, _, ok := cutFlywayArg(arg); !ok || !overridden[key] {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
 }
@@ -1,7 +1,10 @@
 package migrator
 
 import (
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
@@ -12,7 +15,7 @@ import (
 func validTestSchema() *Schema {
 	return &Schema{
 		Name:           "name",
-		MigrationsPath: "path",
+		MigrationsPath: "testdata/migrations",
 		FlywayArgs: []string{
 			"-key1=val1",
 			"-key2=val2",
@@ -41,6 +44,25 @@ func Test_Schema_Validate_FailsOnMissingMigrationsPath(t *testing.T) {
 	assert.Error(s.Validate())
 }
 
+func Test_Schema_Validate_AcceptsMigrationsPathsWithoutMigrationsPath(t *testing.T) {
+	s := validTestSchema()
+	s.MigrationsPath = ""
+	s.MigrationsPaths = []string{"testdata/foo", "testdata/bar"}
+	assert := assert.New(t)
+	assert.NoError(s.Validate())
+}
+
+func Test_Schema_Validate_FailsOnMissingMigrationsDirectories(t *testing.T) {
+	s := validTestSchema()
+	s.MigrationsPaths = []string{"testdata/foo", "testdata/missing"}
+	assert := assert.New(t)
+	assert.ErrorContains(s.Validate(), "migrationsPaths[1]: invalid 'migrationsPaths' in schema name: stat testdata/missing: no such file or directory")
+
+	s.MigrationsPaths = nil
+	s.sharedLocations = []string{"testdata/migrations/V1__init.sql"}
+	assert.EqualError(s.Validate(), "invalid shared location of schema name: testdata/migrations/V1__init.sql is not a directory")
+}
+
 func Test_Schema_Validate_FailsOnMissingCredentials(t *testing.T) {
 	s := validTestSchema()
 	s.Credentials = nil
@@ -59,9 +81,6 @@ func Test_Schema_Validate_FailsIfInvalidFlywayArg(t *testing.T) {
 	s := validTestSchema()
 	assert := assert.New(t)
 
-	s.FlywayArgs = []string{"-invalid=because=twoequalsigns"}
-	assert.Error(s.Validate())
-
 	s.FlywayArgs = []string{"-invalidbecausenoequalssign"}
 	assert.Error(s.Validate())
 
@@ -75,6 +94,14 @@ func Test_Schema_Validate_FailsIfInvalidFlywayArg(t *testing.T) {
 	assert.Error(s.Validate())
 }
 
+func Test_Schema_Validate_AcceptsEqualSignsInFlywayArgValues(t *testing.T) {
+	s := validTestSchema()
+	s.FlywayArgs = []string{"-initSql=SET search_path=x"}
+
+	assert := assert.New(t)
+	assert.NoError(s.Validate())
+}
+
 func Test_Schema_Validate_FailsIfPlaceholderInvalid(t *testing.T) {
 	s := validTestSchema()
 	s.Placeholders = append(s.Placeholders, &Placeholder{})
@@ -132,14 +159,38 @@ func Test_Schema_SetDefaultFlywayArgs_PicksCurrentOverDefaults(t *testing.T) {
 	assert.Contains(s.FlywayArgs, "-k5=v5")
 }
 
+func Test_Schema_SetDefaultFlywayArgs_KeepsArgumentOrder(t *testing.T) {
+	s := validTestSchema()
+	s.FlywayArgs = []string{"-k2=v2", "-initSql=SET search_path=x"}
+	s.Flyway = FlywaySettings{"k3": "v3"}
+
+	assert := assert.New(t)
+	assert.NoError(s.SetDefaultFlywayArgs([]string{"-k5=v5", "-k2=vX", "-k3=vX", "-k4=v4"}))
+	assert.Equal([]string{"-k5=v5", "-k4=v4", "-k2=v2", "-initSql=SET search_path=x"}, s.FlywayArgs)
+
+	// adding the defaults again changes nothing
+	assert.NoError(s.SetDefaultFlywayArgs([]string{"-k5=v5", "-k2=vX", "-k3=vX", "-k4=v4"}))
+	assert.Equal([]string{"-k5=v5", "-k4=v4", "-k2=v2", "-initSql=SET search_path=x"}, s.FlywayArgs)
+}
+
+func Test_Schema_SetDefaultFlywaySettings_PicksCurrentOverDefaults(t *testing.T) {
+	s := validTestSchema()
+	s.FlywayArgs = []string{"-k1=v1"}
+	s.Flyway = FlywaySettings{"k2": "v2"}
+	s.SetDefaultFlywaySettings(FlywaySettings{"k1": "vX", "k2": "vX", "k3": "v3"})
+
+	assert := assert.New(t)
+	assert.Equal(FlywaySettings{"k2": "v2", "k3": "v3"}, s.Flyway)
+}
+
 func Test_Schema_SetDefaultFlywayArgs_FailsOnInvalidFlywayArg(t *testing.T) {
 	s := validTestSchema()
 	assert := assert.New(t)
 
 	s.FlywayArgs = []string{"-k1=v1", "-k2=v2", "-k3=v3"}
-	assert.Error(s.SetDefaultFlywayArgs([]string{"-k4=v4", "-k5=v5", "-k1==vX"}))
+	assert.Error(s.SetDefaultFlywayArgs([]string{"-k4=v4", "-k5=v5", "-k1"}))
 
-	s.FlywayArgs = []string{"-k1=v1", "-k2==v2", "-k3=v3"}
+	s.FlywayArgs = []string{"-k1=v1", "k2=v2", "-k3=v3"}
 	assert.Error(s.SetDefaultFlywayArgs([]string{"-k4=v4", "-k5=v5", "-k1=vX"}))
 }
 
@@ -171,7 +222,7 @@ func Test_Schema_ensureFlyway_ReturnsErrorWhenFlywayNotInstalled(t *testing.T) {
 func Test_Schema_Migrate_AppliesCorrectSettingsToCommandExec(t *testing.T) {
 	s := Schema{
 		Name:           "test",
-		MigrationsPath: "./data",
+		MigrationsPath: "./testdata/migrations",
 		Credentials:    validTestCredentials(),
 		FlywayArgs: []string{
 			"-baselineOnMigrate=true",
@@ -192,7 +243,7 @@ func Test_Schema_Migrate_AppliesCorrectSettingsToCommandExec(t *testing.T) {
 
 		if callcount > 0 {
 			assert.Contains(arg, "-baselineOnMigrate=true")
-			assert.Contains(arg, "-locations=filesystem:./data")
+			assert.Contains(arg, "-locations=filesystem:./testdata/migrations")
 			assert.Contains(arg, "-schemas=test")
 			assert.Contains(arg, "-user=a")
 			assert.Contains(arg, "-password=a")
@@ -207,6 +258,22 @@ func Test_Schema_Migrate_AppliesCorrectSettingsToCommandExec(t *testing.T) {
 	assert.NoError(err)
 }
 
+func Test_Schema_Migrate_PassesMigrationsPathsAndSharedLocations(t *testing.T) {
+	s := validTestSchema()
+	s.MigrationsPaths = []string{"testdata/foo"}
+	s.sharedLocations = []string{"testdata/bar"}
+
+	calls := [][]string{}
+	err := s.Migrate(func(name string, arg ...string) *exec.Cmd {
+		calls = append(calls, arg)
+		return exec.Command("echo", "testing")
+	})
+
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.Contains(calls[1], "-locations=filesystem:testdata/migrations,filesystem:testdata/foo,filesystem:testdata/bar")
+}
+
 func Test_Schema_Migrate_FailsOnValidationErrors(t *testing.T) {
 	s := validTestSchema()
 	s.Name = ""
@@ -251,3 +318,168 @@ func Test_Schema_Migrate_FailsOnMigrationCommandErrors(t *testing.T) {
 	assert.Error(err)
 	assert.Greater(callcount, 0)
 }
+
+// Command that prints a rejected login like flyway does and fails
+func authFailureCommand() *exec.Cmd {
+	return exec.Command("sh", "-c", `echo 'FATAL: password authentication failed for user "a"' >&2; exit 1`)
+}
+
+// Schema reading its credentials from the environment, with the password set to "old"
+func envCredentialsTestSchema(t *testing.T) *Schema {
+	t.Setenv("DB_USER", "a")
+	t.Setenv("DB_PASSWORD", "old")
+	t.Setenv("DB_HOST", "a")
+	t.Setenv("DB_PORT", "5432")
+	t.Setenv("DB_NAME", "a")
+	s := validTestSchema()
+	s.Credentials = &Credentials{
+		Provider: string(cp.EnvProviderType),
+		CredentialProviders: CredentialProviders{
+			EnvProviderImpl: &cp.EnvDatabaseCredentials{
+				UsernameKey: "DB_USER",
+				PasswordKey: "DB_PASSWORD",
+				HostKey:     "DB_HOST",
+				PortKey:     "DB_PORT",
+				DatabaseKey: "DB_NAME",
+			},
+		},
+	}
+	return s
+}
+
+// Returns the password arguments of every flyway migration, and makes the migration
+// fail with a rejected login for the given number of times
+func recordPasswords(passwords *[]string, authFailures int) CommandFuncType {
+	return func(name string, arg ...string) *exec.Cmd {
+		if len(arg) == 0 {
+			return exec.Command("echo", "flyway")
+		}
+		for _, a := range arg {
+			if strings.HasPrefix(a, "-password=") {
+				*passwords = append(*passwords, a)
+			}
+		}
+		if len(*passwords) <= authFailures {
+			return authFailureCommand()
+		}
+		return exec.Command("echo", "migrated")
+	}
+}
+
+func Test_Schema_Migrate_RetriesWithRefreshedCredentialsOnAuthFailure(t *testing.T) {
+	s := envCredentialsTestSchema(t)
+	assert := assert.New(t)
+	assert.NoError(s.Validate())
+
+	// the secret is rotated after the credentials were fetched
+	t.Setenv("DB_PASSWORD", "new")
+	passwords := []string{}
+
+	err := s.Migrate(recordPasswords(&passwords, 1))
+	assert.NoError(err)
+	assert.Equal([]string{"-password=old", "-password=new"}, passwords)
+}
+
+func Test_Schema_Migrate_RunsPlaceholderCommandOnceAcrossRetries(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	s := envCredentialsTestSchema(t)
+	s.Placeholders = []*Placeholder{{Name: "token", ValueFromCommand: &PlaceholderCommand{Command: []string{"sh", "-c", "echo run >> " + runs + "; echo token"}}}}
+	assert := assert.New(t)
+	assert.NoError(s.Validate())
+	t.Setenv("DB_PASSWORD", "new")
+	passwords := []string{}
+
+	assert.NoError(s.Migrate(recordPasswords(&passwords, 1)))
+	assert.Len(passwords, 2)
+	data, err := os.ReadFile(runs)
+	assert.NoError(err)
+	assert.Equal("run\n", string(data))
+}
+
+func Test_Schema_Migrate_RetriesOnlyOnce(t *testing.T) {
+	s := envCredentialsTestSchema(t)
+	assert := assert.New(t)
+	assert.NoError(s.Validate())
+	t.Setenv("DB_PASSWORD", "new")
+	passwords := []string{}
+
+	err := s.Migrate(recordPasswords(&passwords, 2))
+	assert.ErrorContains(err, "flyway migration failed")
+	assert.Equal([]string{"-password=old", "-password=new"}, passwords)
+}
+
+func Test_Schema_Migrate_FailsWhenRefreshedCredentialsUnchanged(t *testing.T) {
+	s := envCredentialsTestSchema(t)
+	passwords := []string{}
+
+	err := s.Migrate(recordPasswords(&passwords, 1))
+	assert := assert.New(t)
+	assert.ErrorContains(err, "no other credentials are available")
+	assert.Equal([]string{"-password=old"}, passwords)
+}
+
+func Test_Schema_Migrate_DoesNotRetryOnOtherFailures(t *testing.T) {
+	s := validTestSchema()
+	migrations := 0
+
+	err := s.Migrate(func(name string, arg ...string) *exec.Cmd {
+		if len(arg) == 0 {
+			return exec.Command("echo", "flyway")
+		}
+		migrations++
+		return exec.Command("sh", "-c", "echo 'Migration V2__add_table.sql failed' >&2; exit 1")
+	})
+
+	assert := assert.New(t)
+	assert.Error(err)
+	assert.Equal(1, migrations)
+}
+
+func Test_Schema_ValidateConfig_DoesNotFetchCredentials(t *testing.T) {
+	s := validTestSchema()
+	s.Credentials = testEnvCredentials()
+	assert := assert.New(t)
+	assert.NoError(s.ValidateConfig())
+	assert.Error(s.Validate())
+}
+
+func Test_Schema_ValidateConfig_FailsIfInvalidCredentials(t *testing.T) {
+	s := validTestSchema()
+	s.Credentials.TextProviderImpl.Database = ""
+	assert := assert.New(t)
+	assert.Error(s.ValidateConfig())
+}
+
+func Test_Schema_checkFiles_FailsWhenFilesMissing(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	assert := assert.New(t)
+	assert.NoError(os.WriteFile(file, []byte("x"), 0o600))
+
+	s := validTestSchema()
+	s.MigrationsPath = file
+	s.Placeholders = []*Placeholder{{Name: "p", ValueFromFile: filepath.Join(dir, "missing")}}
+
+	err := s.checkFiles("schemas[0]", nil)
+	assert.ErrorContains(err, "is not a directory")
+	assert.ErrorContains(err, "'valueFromFile' of placeholder p")
+
+	s.MigrationsPath = dir
+	s.Placeholders[0].ValueFromFile = file
+	assert.NoError(s.checkFiles("schemas[0]", nil))
+}
+
+func Test_Schema_checkFiles_SkipsUnresolvedPaths(t *testing.T) {
+	s := validTestSchema()
+	s.MigrationsPath = "${env:MIGRATIONS_ROOT}/name"
+	s.Placeholders = []*Placeholder{{Name: "p", ValueFromFile: "1"}}
+
+	assert := assert.New(t)
+	assert.NoError(s.checkFiles("schemas[1]", map[string]bool{
+		"schemas[1].migrationsPath":                true,
+		"schemas[1].placeholders[0].valueFromFile": true,
+	}))
+	assert.Error(s.checkFiles("schemas[0]", map[string]bool{
+		"schemas[1].migrationsPath": true,
+	}))
+}
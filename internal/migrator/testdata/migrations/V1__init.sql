@@ -0,0 +1,2 @@
+-- migrations of the test schema
+SELECT 1;
@@ -2,49 +2,205 @@ package secrets_provider
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
+	"github.com/sourcehawk/go-flyway/internal/validation"
 )
 
 var RequestTimeoutDuration time.Duration = 10 * time.Second
 
+// Session name used when assuming a role without an explicit session name
+const DefaultRoleSessionName = "go-flyway"
+
 type SecretsManagerClient interface {
 	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
 }
 
+// AWSClientConfig selects the account, region and endpoint that an
+// AWS Secrets Manager client talks to. Empty fields use the AWS SDK defaults.
+type AWSClientConfig struct {
+	// AWS region of the secret, e.g eu-west-1
+	Region string `yaml:"region,omitempty"`
+	// Named profile from the shared AWS config and credentials files
+	Profile string `yaml:"profile,omitempty"`
+	// ARN of a role to assume before fetching the secret, e.g for cross account access
+	RoleArn string `yaml:"roleArn,omitempty"`
+	// External ID required by the trust policy of the assumed role
+	ExternalId string `yaml:"externalId,omitempty"`
+	// Session name of the assumed role, defaults to go-flyway
+	SessionName string `yaml:"sessionName,omitempty"`
+	// Custom endpoint of the secrets manager API, e.g http://localhost:4566 for LocalStack
+	EndpointUrl string `yaml:"endpointUrl,omitempty"`
+}
+
+func (c AWSClientConfig) IsZero() bool {
+	return c == AWSClientConfig{}
+}
+
+func (c AWSClientConfig) String() string {
+	fields := []string{}
+	for _, f := range []struct{ name, value string }{
+		{"region", c.Region},
+		{"profile", c.Profile},
+		{"roleArn", c.RoleArn},
+		{"externalId", c.ExternalId},
+		{"sessionName", c.SessionName},
+		{"endpointUrl", c.EndpointUrl},
+	} {
+		if f.value != "" {
+			fields = append(fields, fmt.Sprintf("%s=%s", f.name, f.value))
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+func (c AWSClientConfig) Validate() error {
+	errs := []error{}
+	if c.RoleArn == "" && c.ExternalId != "" {
+		errs = append(errs, validation.Errorf("externalId", "'externalId' requires 'roleArn' to be set"))
+	}
+	if c.RoleArn == "" && c.SessionName != "" {
+		errs = append(errs, validation.Errorf("sessionName", "'sessionName' requires 'roleArn' to be set"))
+	}
+	if c.EndpointUrl != "" {
+		u, err := url.Parse(c.EndpointUrl)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, validation.Errorf("endpointUrl", "'endpointUrl' %s is not an absolute URL", c.EndpointUrl))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c AWSClientConfig) withDefaults(defaults AWSClientConfig) AWSClientConfig {
+	for _, f := range []struct{ field, fallback *string }{
+		{&c.Region, &defaults.Region},
+		{&c.Profile, &defaults.Profile},
+		{&c.RoleArn, &defaults.RoleArn},
+		{&c.ExternalId, &defaults.ExternalId},
+		{&c.SessionName, &defaults.SessionName},
+		{&c.EndpointUrl, &defaults.EndpointUrl},
+	} {
+		if *f.field == "" {
+			*f.field = *f.fallback
+		}
+	}
+	return c
+}
+
 type AWSSecretsManager struct {
 	client SecretsManagerClient
 }
 
+// Creates a client using the default AWS configuration of the environment
 func NewAWSSecretsManager() (*AWSSecretsManager, error) {
+	return NewAWSSecretsManagerWithConfig(AWSClientConfig{})
+}
+
+// Creates a client for the given region, profile, role and endpoint
+func NewAWSSecretsManagerWithConfig(clientConfig AWSClientConfig) (*AWSSecretsManager, error) {
+	if err := clientConfig.Validate(); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeoutDuration)
 	defer cancel()
 
-	cfg, err := config.LoadDefaultConfig(ctx)
+	opts := []func(*config.LoadOptions) error{}
+
+	if clientConfig.Region != "" {
+		opts = append(opts, config.WithRegion(clientConfig.Region))
+	}
+	if clientConfig.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(clientConfig.Profile))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 
 	if err != nil {
 		return nil, fmt.Errorf("unable to load aws auth configuration: %w", err)
 	}
 
+	if clientConfig.RoleArn != "" {
+		sessionName := clientConfig.SessionName
+		if sessionName == "" {
+			sessionName = DefaultRoleSessionName
+		}
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), clientConfig.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if clientConfig.ExternalId != "" {
+				o.ExternalID = aws.String(clientConfig.ExternalId)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
 	return &AWSSecretsManager{
-		client: secretsmanager.NewFromConfig(cfg),
+		client: secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) {
+			if clientConfig.EndpointUrl != "" {
+				o.BaseEndpoint = aws.String(clientConfig.EndpointUrl)
+			}
+		}),
 	}, nil
 }
 
-func (s *AWSSecretsManager) GetSecret(name string) (map[string]any, error) {
+var (
+	sharedClientsMu sync.Mutex
+	sharedClients   = map[AWSClientConfig]*AWSSecretsManager{}
+)
+
+// Returns the client for the given configuration, creating it on first use.
+// One client is shared by all secrets with the same configuration during the run
+func SharedAWSSecretsManager(clientConfig AWSClientConfig) (*AWSSecretsManager, error) {
+	sharedClientsMu.Lock()
+	defer sharedClientsMu.Unlock()
+
+	if client, ok := sharedClients[clientConfig]; ok {
+		return client, nil
+	}
+
+	client, err := NewAWSSecretsManagerWithConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedClients[clientConfig] = client
+	return client, nil
+}
+
+func (s *AWSSecretsManager) GetSecret(name string) (*Secret, error) {
+	return s.GetSecretVersion(name, SecretVersion{})
+}
+
+func (s *AWSSecretsManager) GetSecretVersion(name string, version SecretVersion) (*Secret, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeoutDuration)
 	defer cancel()
 
-	resp, err := s.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+	input := &secretsmanager.GetSecretValueInput{
 		SecretId: aws.String(name),
-	})
+	}
+	if version.VersionId != "" {
+		input.VersionId = aws.String(version.VersionId)
+	}
+	if version.VersionStage != "" {
+		input.VersionStage = aws.String(version.VersionStage)
+	}
+
+	resp, err := s.client.GetSecretValue(ctx, input)
+
+	if !version.IsZero() {
+		name = fmt.Sprintf("%s (%s)", name, version)
+	}
 
 	if err != nil {
 		var ae smithy.APIError
@@ -54,17 +210,12 @@ func (s *AWSSecretsManager) GetSecret(name string) (map[string]any, error) {
 		return nil, fmt.Errorf("failed to get secret %s: %w", name, err)
 	}
 
-	var data map[string]any
-
-	if resp.SecretString != nil {
-		if err := json.Unmarshal([]byte(*resp.SecretString), &data); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal json from secret string: %w", err)
-		}
-	} else {
-		if err := json.Unmarshal(resp.SecretBinary, &data); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal json from secret binary: %w", err)
-		}
+	switch {
+	case resp.SecretString != nil:
+		return NewSecret(*resp.SecretString), nil
+	case resp.SecretBinary != nil:
+		return NewBinarySecret(resp.SecretBinary), nil
+	default:
+		return nil, fmt.Errorf("secret %s has neither a string nor a binary value", name)
 	}
-
-	return data, nil
 }
@@ -56,7 +56,8 @@ func Test_AWSSecretsManager_GetSecret_FetchesSecretFromString(t *testing.T) {
 
 	secretOut, err := awssm.GetSecret(secretName)
 	assert.Nil(err)
-	assert.Equal(secretOut, secret)
+	assert.Equal(secretOut.JSON, secret)
+	assert.Equal(secretOut.String, string(bytes))
 }
 
 func Test_AWSSecretsManager_GetSecret_FetchesSecretFromBinary(t *testing.T) {
@@ -92,7 +93,8 @@ func Test_AWSSecretsManager_GetSecret_FetchesSecretFromBinary(t *testing.T) {
 
 	secretOut, err := awssm.GetSecret(secretName)
 	assert.Nil(err)
-	assert.Equal(secretOut, secret)
+	assert.Equal(secretOut.JSON, secret)
+	assert.Equal(secretOut.String, string(bytes))
 }
 
 func Test_AWSSecretsManager_GetSecret_ErrorWhenNoStringOrBinary(t *testing.T) {
@@ -160,7 +162,7 @@ func Test_AWSSecretsManager_GetSecret_ErrorWhenContextTimeout(t *testing.T) {
 	assert.ErrorIs(err, context.DeadlineExceeded)
 }
 
-func Test_AWSSecretsManager_GetSecret_ErrorWhenSecretStringNotJsonParsable(t *testing.T) {
+func Test_AWSSecretsManager_GetSecret_ReturnsPlainStringSecret(t *testing.T) {
 	awssmClient := new(MockAWSSecretsManagerClient)
 	secretName := "foo/bar/baz"
 
@@ -188,12 +190,13 @@ func Test_AWSSecretsManager_GetSecret_ErrorWhenSecretStringNotJsonParsable(t *te
 		client: awssmClient,
 	}
 
-	_, err := awssm.GetSecret(secretName)
-	var syntaxError *json.SyntaxError
-	assert.ErrorAs(err, &syntaxError, "error should be a syntax error")
+	secretOut, err := awssm.GetSecret(secretName)
+	assert.NoError(err)
+	assert.Equal("notjson", secretOut.String)
+	assert.Nil(secretOut.JSON)
 }
 
-func Test_AWSSecretsManager_GetSecret_ErrorWhenBinaryNotJsonParsable(t *testing.T) {
+func Test_AWSSecretsManager_GetSecret_ReturnsPlainBinarySecret(t *testing.T) {
 	awssmClient := new(MockAWSSecretsManagerClient)
 	secretName := "foo/bar/baz"
 
@@ -222,7 +225,118 @@ func Test_AWSSecretsManager_GetSecret_ErrorWhenBinaryNotJsonParsable(t *testing.
 		client: awssmClient,
 	}
 
-	_, err := awssm.GetSecret(secretName)
-	var syntaxError *json.SyntaxError
-	assert.ErrorAs(err, &syntaxError, "error should be a syntax error")
+	secretOut, err := awssm.GetSecret(secretName)
+	assert.NoError(err)
+	assert.Equal("notjson", secretOut.String)
+	assert.Nil(secretOut.JSON)
+}
+
+func Test_AWSSecretsManager_GetSecretVersion_RequestsVersion(t *testing.T) {
+	awssmClient := new(MockAWSSecretsManagerClient)
+	assert := assert.New(t)
+
+	output := &secretsmanager.GetSecretValueOutput{
+		SecretString: aws.String(`{"hello": "previous"}`),
+	}
+
+	awssmClient.On(
+		"GetSecretValue",
+		mock.Anything,
+		mock.MatchedBy(func(p *secretsmanager.GetSecretValueInput) bool {
+			return *p.SecretId == "foo" && *p.VersionStage == "AWSPREVIOUS" && *p.VersionId == "v1"
+		}),
+		mock.Anything,
+	).Return(output, nil)
+
+	awssm := AWSSecretsManager{
+		client: awssmClient,
+	}
+
+	secretOut, err := awssm.GetSecretVersion("foo", SecretVersion{VersionId: "v1", VersionStage: "AWSPREVIOUS"})
+	assert.NoError(err)
+	assert.Equal(map[string]any{"hello": "previous"}, secretOut.JSON)
+}
+
+func Test_AWSSecretsManager_GetSecret_DoesNotRequestVersion(t *testing.T) {
+	awssmClient := new(MockAWSSecretsManagerClient)
+	assert := assert.New(t)
+
+	awssmClient.On(
+		"GetSecretValue",
+		mock.Anything,
+		mock.MatchedBy(func(p *secretsmanager.GetSecretValueInput) bool {
+			return p.VersionStage == nil && p.VersionId == nil
+		}),
+		mock.Anything,
+	).Return(&secretsmanager.GetSecretValueOutput{SecretString: aws.String(`{}`)}, nil)
+
+	awssm := AWSSecretsManager{
+		client: awssmClient,
+	}
+
+	_, err := awssm.GetSecret("foo")
+	assert.NoError(err)
+}
+
+func Test_AWSClientConfig_Validate(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(AWSClientConfig{}.Validate())
+	assert.NoError(AWSClientConfig{RoleArn: "arn", ExternalId: "id", SessionName: "s", EndpointUrl: "http://localhost:4566"}.Validate())
+	assert.Error(AWSClientConfig{ExternalId: "id"}.Validate())
+	assert.Error(AWSClientConfig{SessionName: "s"}.Validate())
+	assert.Error(AWSClientConfig{EndpointUrl: "localhost"}.Validate())
+}
+
+func Test_NewAWSSecretsManagerWithConfig_CreatesClient(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+
+	awssm, err := NewAWSSecretsManagerWithConfig(AWSClientConfig{
+		Region:      "eu-west-1",
+		RoleArn:     "arn:aws:iam::123456789012:role/x",
+		ExternalId:  "ext",
+		EndpointUrl: "http://localhost:4566",
+	})
+	assert := assert.New(t)
+	assert.NoError(err)
+	assert.NotNil(awssm.client)
+
+	_, err = NewAWSSecretsManagerWithConfig(AWSClientConfig{ExternalId: "ext"})
+	assert.Error(err)
+}
+
+func Test_SharedAWSSecretsManager_ReusesClientPerConfig(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	assert := assert.New(t)
+
+	a, err := SharedAWSSecretsManager(AWSClientConfig{Region: "eu-west-1"})
+	assert.NoError(err)
+	b, err := SharedAWSSecretsManager(AWSClientConfig{Region: "eu-west-1"})
+	assert.NoError(err)
+	c, err := SharedAWSSecretsManager(AWSClientConfig{Region: "us-east-1"})
+	assert.NoError(err)
+
+	assert.Same(a, b)
+	assert.NotSame(a, c)
+}
+
+func Test_AWSSecretsManager_GetSecret_EncodesNonTextBinarySecretAsBase64(t *testing.T) {
+	awssmClient := new(MockAWSSecretsManagerClient)
+	assert := assert.New(t)
+
+	awssmClient.On(
+		"GetSecretValue",
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+	).Return(&secretsmanager.GetSecretValueOutput{SecretBinary: []byte{0xff, 0xfe, 0x00}}, nil)
+
+	awssm := AWSSecretsManager{
+		client: awssmClient,
+	}
+
+	secretOut, err := awssm.GetSecret("foo")
+	assert.NoError(err)
+	assert.Equal("//4A", secretOut.String)
 }
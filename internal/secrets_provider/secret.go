@@ -0,0 +1,212 @@
+package secrets_provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A secret fetched from a secrets provider
+type Secret struct {
+	// The secret string as stored in the provider
+	String string
+	// The secret string parsed as JSON, nil if the secret is not valid JSON
+	JSON any
+}
+
+// Creates a secret from its string value, parsing it as JSON if possible
+func NewSecret(value string) *Secret {
+	secret := &Secret{String: value}
+	var data any
+	if err := json.Unmarshal([]byte(value), &data); err == nil {
+		secret.JSON = data
+	}
+	return secret
+}
+
+// Creates a secret from a binary value. Binary values that are not valid
+// UTF-8 text are represented by their base64 encoding
+func NewBinarySecret(value []byte) *Secret {
+	if utf8.Valid(value) {
+		return NewSecret(string(value))
+	}
+	return &Secret{String: base64.StdEncoding.EncodeToString(value)}
+}
+
+type pathSegment struct {
+	key   string
+	index int
+	// whether the segment is an array index rather than an object key
+	isIndex bool
+}
+
+func (p pathSegment) String() string {
+	if p.isIndex {
+		return fmt.Sprintf("[%d]", p.index)
+	}
+	return p.key
+}
+
+// Parses a key path such as a.b[0].c, $.a.b or $['a.b'].c into its segments
+func parseKeyPath(path string) ([]pathSegment, error) {
+	rest := strings.TrimPrefix(path, "$")
+	rest = strings.TrimPrefix(rest, ".")
+	segments := []pathSegment{}
+
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "['") || strings.HasPrefix(rest, `["`):
+			quote := rest[1:2]
+			end := strings.Index(rest[2:], quote+"]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted key in key path '%s'", path)
+			}
+			segments = append(segments, pathSegment{key: rest[2 : 2+end]})
+			rest = rest[2+end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in key path '%s'", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index '%s' in key path '%s'", rest[1:end], path)
+			}
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in key path '%s'", path)
+			}
+			segments = append(segments, pathSegment{key: rest[:end]})
+			rest = rest[end:]
+		}
+
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" {
+				return nil, fmt.Errorf("key path '%s' must not end with '.'", path)
+			}
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("key path '%s' is empty", path)
+	}
+
+	return segments, nil
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+// Returns the value at the given key path in the JSON secret.
+//
+// A top level key that matches the path exactly takes precedence, so keys
+// containing dots keep working without quoting
+func (s *Secret) Get(path string) (any, error) {
+	root, ok := s.JSON.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("secret is not a JSON object but a %s", jsonTypeName(s.JSON))
+	}
+
+	if v, ok := root[path]; ok {
+		return v, nil
+	}
+
+	segments, err := parseKeyPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var current any = root
+	traversed := "$"
+
+	for _, segment := range segments {
+		if segment.isIndex {
+			list, ok := current.([]any)
+			if !ok {
+				return nil, fmt.Errorf("expected an array at '%s' but found %s", traversed, jsonTypeName(current))
+			}
+			if segment.index >= len(list) {
+				return nil, fmt.Errorf("index %d out of range at '%s' (length %d)", segment.index, traversed, len(list))
+			}
+			current = list[segment.index]
+			traversed += segment.String()
+			continue
+		}
+
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected an object at '%s' but found %s", traversed, jsonTypeName(current))
+		}
+		value, ok := object[segment.key]
+		if !ok {
+			return nil, fmt.Errorf("'%s' not present at '%s'", segment.key, traversed)
+		}
+		current = value
+		traversed += "." + segment.key
+	}
+
+	return current, nil
+}
+
+// Converts a JSON scalar to its string representation
+func CoerceString(v any) (string, error) {
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	default:
+		return "", fmt.Errorf("cannot use %s as a string", jsonTypeName(v))
+	}
+}
+
+// Converts a JSON number or a numeric string to an integer. Values are not
+// part of the errors, as they may be secrets
+func CoerceInt(v any) (int, error) {
+	switch value := v.(type) {
+	case int:
+		return value, nil
+	case float64:
+		if value != math.Trunc(value) || math.Abs(value) > 1<<53 {
+			return 0, fmt.Errorf("value is not an integer")
+		}
+		return int(value), nil
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return 0, fmt.Errorf("value is not an integer")
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("cannot use %s as an integer", jsonTypeName(v))
+	}
+}
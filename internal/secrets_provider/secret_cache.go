@@ -0,0 +1,180 @@
+package secrets_provider
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The secret cache shared by all secrets providers during a run
+var DefaultSecretCache = NewSecretCache(0)
+
+// Hit and miss counters of a secret cache
+type SecretCacheStats struct {
+	// Number of lookups served from the cache or by joining an in-flight fetch
+	Hits uint64
+	// Number of lookups that triggered a fetch from the secrets provider
+	Misses uint64
+}
+
+type secretCacheEntry struct {
+	// closed once the fetch has completed
+	done      chan struct{}
+	secret    *Secret
+	err       error
+	fetchedAt time.Time
+}
+
+// SecretCache caches fetched secrets by key.
+//
+// Concurrent lookups of the same key share a single fetch, and failed
+// fetches are not cached. Entries expire after the TTL, a TTL of zero means
+// that entries never expire.
+type SecretCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*secretCacheEntry
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	now     func() time.Time
+}
+
+func NewSecretCache(ttl time.Duration) *SecretCache {
+	return &SecretCache{
+		ttl:     ttl,
+		entries: make(map[string]*secretCacheEntry),
+		now:     time.Now,
+	}
+}
+
+// Returns the cache key of a secret in the given namespace, e.g a secrets provider type
+func SecretCacheKey(namespace string, name string) string {
+	return fmt.Sprintf("%s://%s", namespace, name)
+}
+
+// Sets the time after which cached secrets are fetched again, zero disables expiry
+func (c *SecretCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+func (c *SecretCache) expired(entry *secretCacheEntry) bool {
+	return c.ttl > 0 && c.now().Sub(entry.fetchedAt) >= c.ttl
+}
+
+// Returns the cached secret for the key, calling fetch to populate the cache if
+// the secret is not cached or has expired
+func (c *SecretCache) Get(key string, fetch func() (*Secret, error)) (*Secret, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+
+	if ok {
+		select {
+		case <-entry.done:
+			if c.expired(entry) {
+				ok = false
+			}
+		default:
+			// fetch in flight
+		}
+	}
+
+	if ok {
+		c.mu.Unlock()
+		c.hits.Add(1)
+		<-entry.done
+		return entry.secret, entry.err
+	}
+
+	entry = &secretCacheEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	entry.secret, entry.err = fetch()
+	entry.fetchedAt = c.now()
+
+	if entry.err != nil {
+		c.mu.Lock()
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+
+	close(entry.done)
+	return entry.secret, entry.err
+}
+
+// Removes the secret with the given key from the cache
+func (c *SecretCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// Removes the secret with the given key and all of its versions from the cache
+func (c *SecretCache) invalidateVersions(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if k == key || strings.HasPrefix(k, key+"?") {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Removes all secrets from the cache
+func (c *SecretCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*secretCacheEntry)
+}
+
+// Returns the hit and miss counters of the cache
+func (c *SecretCache) Stats() SecretCacheStats {
+	return SecretCacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+type cachedSecretsProvider struct {
+	cache     *SecretCache
+	namespace string
+	provider  SecretsProvider
+}
+
+func (p *cachedSecretsProvider) GetSecret(name string) (*Secret, error) {
+	return p.GetSecretVersion(name, SecretVersion{})
+}
+
+func (p *cachedSecretsProvider) GetSecretVersion(name string, version SecretVersion) (*Secret, error) {
+	key := SecretCacheKey(p.namespace, name)
+	if !version.IsZero() {
+		key = fmt.Sprintf("%s?%s", key, version)
+	}
+	return p.cache.Get(key, func() (*Secret, error) {
+		return GetSecretVersion(p.provider, name, version)
+	})
+}
+
+// Removes every cached version of the secret so that it is fetched again
+func (p *cachedSecretsProvider) Invalidate(name string) {
+	p.cache.invalidateVersions(SecretCacheKey(p.namespace, name))
+}
+
+// Wraps the secrets provider so that its secrets are cached in the given namespace.
+//
+// Providers that read from the same secret store must share a namespace,
+// providers that read from different secret stores must not
+func (c *SecretCache) Wrap(namespace string, provider SecretsProvider) SecretsProvider {
+	return &cachedSecretsProvider{
+		cache:     c,
+		namespace: namespace,
+		provider:  provider,
+	}
+}
@@ -0,0 +1,197 @@
+package secrets_provider
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_SecretCache_Get_CachesSecrets(t *testing.T) {
+	c := NewSecretCache(0)
+	calls := 0
+	fetch := func() (*Secret, error) {
+		calls++
+		return NewSecret("b"), nil
+	}
+
+	assert := assert.New(t)
+	for i := 0; i < 3; i++ {
+		secret, err := c.Get("key", fetch)
+		assert.NoError(err)
+		assert.Equal(NewSecret("b"), secret)
+	}
+	assert.Equal(1, calls)
+	assert.Equal(SecretCacheStats{Hits: 2, Misses: 1}, c.Stats())
+}
+
+func Test_SecretCache_Get_DoesNotCacheErrors(t *testing.T) {
+	c := NewSecretCache(0)
+	calls := 0
+	fetch := func() (*Secret, error) {
+		calls++
+		return nil, fmt.Errorf("test error 123")
+	}
+
+	assert := assert.New(t)
+	_, err := c.Get("key", fetch)
+	assert.Error(err)
+	_, err = c.Get("key", fetch)
+	assert.Error(err)
+	assert.Equal(2, calls)
+}
+
+func Test_SecretCache_Get_RefetchesExpiredSecrets(t *testing.T) {
+	c := NewSecretCache(time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	calls := 0
+	fetch := func() (*Secret, error) {
+		calls++
+		return NewSecret(""), nil
+	}
+
+	assert := assert.New(t)
+	_, err := c.Get("key", fetch)
+	assert.NoError(err)
+	now = now.Add(59 * time.Second)
+	_, err = c.Get("key", fetch)
+	assert.NoError(err)
+	assert.Equal(1, calls)
+
+	now = now.Add(time.Second)
+	_, err = c.Get("key", fetch)
+	assert.NoError(err)
+	assert.Equal(2, calls)
+}
+
+func Test_SecretCache_Get_DeduplicatesConcurrentFetches(t *testing.T) {
+	c := NewSecretCache(0)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	fetch := func() (*Secret, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return NewSecret("b"), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*Secret, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = c.Get("key", fetch)
+		}(i)
+	}
+
+	// let the goroutines queue up behind the in-flight fetch
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert := assert.New(t)
+	assert.Equal(1, calls)
+	for _, r := range results {
+		assert.Equal(NewSecret("b"), r)
+	}
+	assert.Equal(uint64(9), c.Stats().Hits)
+	assert.Equal(uint64(1), c.Stats().Misses)
+}
+
+func Test_SecretCache_Invalidate_RemovesSecret(t *testing.T) {
+	c := NewSecretCache(0)
+	calls := 0
+	fetch := func() (*Secret, error) {
+		calls++
+		return NewSecret(""), nil
+	}
+
+	assert := assert.New(t)
+	_, err := c.Get("key", fetch)
+	assert.NoError(err)
+	c.Invalidate("key")
+	_, err = c.Get("key", fetch)
+	assert.NoError(err)
+	c.Clear()
+	_, err = c.Get("key", fetch)
+	assert.NoError(err)
+	assert.Equal(3, calls)
+}
+
+func Test_SecretCache_Wrap_SharesSecretsWithinNamespace(t *testing.T) {
+	sp := new(MockSecretsProvider)
+	sp.On("GetSecret", "test").Return(NewSecret("hello"), nil)
+	c := NewSecretCache(0)
+
+	assert := assert.New(t)
+	for _, p := range []SecretsProvider{c.Wrap("ns", sp), c.Wrap("ns", sp), c.Wrap("other", sp)} {
+		secret, err := p.GetSecret("test")
+		assert.NoError(err)
+		assert.Equal(NewSecret("hello"), secret)
+	}
+	sp.AssertNumberOfCalls(t, "GetSecret", 2)
+}
+
+type MockVersionedSecretsProvider struct {
+	MockSecretsProvider
+}
+
+func (m *MockVersionedSecretsProvider) GetSecretVersion(name string, version SecretVersion) (*Secret, error) {
+	args := m.Called(name, version)
+	return args.Get(0).(*Secret), args.Error(1)
+}
+
+func Test_SecretCache_Wrap_CachesVersionsSeparately(t *testing.T) {
+	sp := new(MockVersionedSecretsProvider)
+	sp.On("GetSecret", "test").Return(NewSecret("current"), nil)
+	sp.On("GetSecretVersion", "test", SecretVersion{VersionStage: "AWSPREVIOUS"}).Return(NewSecret("previous"), nil)
+	p := NewSecretCache(0).Wrap("ns", sp)
+
+	assert := assert.New(t)
+	for i := 0; i < 2; i++ {
+		current, err := GetSecretVersion(p, "test", SecretVersion{})
+		assert.NoError(err)
+		assert.Equal("current", current.String)
+		previous, err := GetSecretVersion(p, "test", SecretVersion{VersionStage: "AWSPREVIOUS"})
+		assert.NoError(err)
+		assert.Equal("previous", previous.String)
+	}
+	sp.AssertNumberOfCalls(t, "GetSecret", 1)
+	sp.AssertNumberOfCalls(t, "GetSecretVersion", 1)
+}
+
+func Test_SecretCache_Wrap_InvalidateDropsAllVersionsOfSecret(t *testing.T) {
+	sp := new(MockVersionedSecretsProvider)
+	sp.On("GetSecret", mock.Anything).Return(NewSecret("current"), nil)
+	sp.On("GetSecretVersion", "test", SecretVersion{VersionStage: "AWSPENDING"}).Return(NewSecret("pending"), nil)
+	p := NewSecretCache(0).Wrap("ns", sp)
+
+	assert := assert.New(t)
+	fetchAll := func() {
+		for _, name := range []string{"test", "testing"} {
+			_, err := p.GetSecret(name)
+			assert.NoError(err)
+		}
+		_, err := GetSecretVersion(p, "test", SecretVersion{VersionStage: "AWSPENDING"})
+		assert.NoError(err)
+	}
+
+	fetchAll()
+	InvalidateSecret(p, "test")
+	fetchAll()
+
+	sp.AssertNumberOfCalls(t, "GetSecret", 3)
+	sp.AssertNumberOfCalls(t, "GetSecretVersion", 2)
+}
+
+func Test_InvalidateSecret_IgnoresProvidersWithoutCache(t *testing.T) {
+	assert := assert.New(t)
+	assert.NotPanics(func() { InvalidateSecret(new(MockSecretsProvider), "test") })
+}
@@ -0,0 +1,124 @@
+package secrets_provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewSecret_ParsesJSON(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(map[string]any{"a": "b"}, NewSecret(`{"a": "b"}`).JSON)
+	assert.Nil(NewSecret("plain").JSON)
+	assert.Equal("plain", NewSecret("plain").String)
+}
+
+func Test_Secret_Get_ResolvesKeyPaths(t *testing.T) {
+	secret := NewSecret(`{
+		"host": "top",
+		"db.host": "dotted",
+		"db": {"host": "nested", "port": 5432, "replicas": [{"host": "r0"}, {"host": "r1"}]}
+	}`)
+	assert := assert.New(t)
+
+	for path, expected := range map[string]any{
+		"host":                  "top",
+		"db.host":               "dotted",
+		"$.db.host":             "nested",
+		"db.port":               float64(5432),
+		"db.replicas[1].host":   "r1",
+		"$.db.replicas[0].host": "r0",
+		"$['db.host']":          "dotted",
+		`$["db"]["host"]`:       "nested",
+	} {
+		v, err := secret.Get(path)
+		assert.NoError(err, path)
+		assert.Equal(expected, v, path)
+	}
+}
+
+func Test_Secret_Get_ReportsPreciseErrors(t *testing.T) {
+	secret := NewSecret(`{"db": {"host": "h", "replicas": [{"host": "r0"}]}}`)
+	assert := assert.New(t)
+
+	for path, message := range map[string]string{
+		"db.port":             "'port' not present at '$.db'",
+		"db.host.name":        "expected an object at '$.db.host' but found string",
+		"db.replicas[3].host": "index 3 out of range at '$.db.replicas' (length 1)",
+		"db[0]":               "expected an array at '$.db' but found object",
+	} {
+		_, err := secret.Get(path)
+		assert.EqualError(err, message, path)
+	}
+
+	_, err := NewSecret("plain").Get("a")
+	assert.EqualError(err, "secret is not a JSON object but a null")
+}
+
+func Test_CoerceInt_ConvertsNumbersAndNumericStrings(t *testing.T) {
+	assert := assert.New(t)
+	for _, v := range []any{5432, float64(5432), "5432", " 5432 "} {
+		i, err := CoerceInt(v)
+		assert.NoError(err)
+		assert.Equal(5432, i)
+	}
+	for _, v := range []any{float64(1.5), "port", true, map[string]any{}, nil} {
+		_, err := CoerceInt(v)
+		assert.Error(err)
+	}
+	for _, v := range []any{float64(1.5), "s3cret"} {
+		_, err := CoerceInt(v)
+		assert.EqualError(err, "value is not an integer")
+	}
+}
+
+func Test_CoerceString_ConvertsScalars(t *testing.T) {
+	assert := assert.New(t)
+	for v, expected := range map[any]string{"a": "a", float64(10): "10", 10: "10", true: "true"} {
+		s, err := CoerceString(v)
+		assert.NoError(err)
+		assert.Equal(expected, s)
+	}
+	_, err := CoerceString([]any{})
+	assert.Error(err)
+}
+
+func Test_SecretRef_Lookup_UsesWholeSecretWithoutKey(t *testing.T) {
+	assert := assert.New(t)
+	v, err := (&SecretRef{SecretName: "a"}).LookupString(NewSecret("plain-password"))
+	assert.NoError(err)
+	assert.Equal("plain-password", v)
+
+	v, err = (&SecretRef{SecretName: "a"}).LookupString(NewSecret(`{"a": 1}`))
+	assert.NoError(err)
+	assert.Equal(`{"a": 1}`, v)
+}
+
+func Test_SecretRef_Lookup_DecodesBase64(t *testing.T) {
+	assert := assert.New(t)
+	v, err := (&SecretRef{SecretName: "a", Encoding: "base64"}).LookupString(NewSecret("c2VjcmV0"))
+	assert.NoError(err)
+	assert.Equal("secret", v)
+
+	v, err = (&SecretRef{SecretName: "a", SecretKey: "k", Encoding: "base64"}).LookupString(NewSecret(`{"k": "c2VjcmV0"}`))
+	assert.NoError(err)
+	assert.Equal("secret", v)
+
+	_, err = (&SecretRef{SecretName: "a", Encoding: "base64"}).LookupString(NewSecret("not base64!"))
+	assert.Error(err)
+
+	_, err = (&SecretRef{SecretName: "a", SecretKey: "k", Encoding: "base64"}).LookupString(NewSecret(`{"k": 1}`))
+	assert.Error(err)
+}
+
+func Test_SecretRef_LookupInt_CoercesStrings(t *testing.T) {
+	secret := NewSecret(`{"port": "5432", "bad": "x"}`)
+	assert := assert.New(t)
+
+	v, err := (&SecretRef{SecretName: "db", SecretKey: "port"}).LookupInt(secret)
+	assert.NoError(err)
+	assert.Equal(5432, v)
+
+	_, err = (&SecretRef{SecretName: "db", SecretKey: "bad"}).LookupInt(secret)
+	assert.EqualError(err, "key 'bad' in secret db: value is not an integer")
+}
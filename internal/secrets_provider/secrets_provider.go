@@ -1,89 +1,296 @@
 package secrets_provider
 
-import "fmt"
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sourcehawk/go-flyway/internal/validation"
+)
+
+type SecretsProviderType string
+
+const (
+	AWSSMSecretsProviderType SecretsProviderType = "aws_sm"
+)
+
+// Encoding of secret values that are stored base64 encoded
+const Base64Encoding = "base64"
+
+// The secrets provider used for secret references that do not specify one
+const DefaultSecretsProviderType = AWSSMSecretsProviderType
 
 type SecretsProvider interface {
-	GetSecret(name string) (map[string]any, error)
+	GetSecret(name string) (*Secret, error)
+}
+
+// A specific version of a secret. The current version is used when empty
+type SecretVersion struct {
+	// Unique identifier of the version
+	VersionId string `yaml:"versionId,omitempty"`
+	// Staging label attached to the version, e.g AWSCURRENT or AWSPREVIOUS
+	VersionStage string `yaml:"versionStage,omitempty"`
+}
+
+func (v SecretVersion) IsZero() bool {
+	return v == SecretVersion{}
+}
+
+func (v SecretVersion) String() string {
+	switch {
+	case v.VersionId != "" && v.VersionStage != "":
+		return fmt.Sprintf("versionId=%s,versionStage=%s", v.VersionId, v.VersionStage)
+	case v.VersionId != "":
+		return fmt.Sprintf("versionId=%s", v.VersionId)
+	case v.VersionStage != "":
+		return fmt.Sprintf("versionStage=%s", v.VersionStage)
+	default:
+		return ""
+	}
+}
+
+// A secrets provider that can fetch other versions than the current one
+type VersionedSecretsProvider interface {
+	SecretsProvider
+	GetSecretVersion(name string, version SecretVersion) (*Secret, error)
+}
+
+// Fetches the given version of a secret, failing if a version is requested
+// from a provider that does not support versions
+func GetSecretVersion(provider SecretsProvider, name string, version SecretVersion) (*Secret, error) {
+	if version.IsZero() {
+		return provider.GetSecret(name)
+	}
+
+	versioned, ok := provider.(VersionedSecretsProvider)
+	if !ok {
+		return nil, fmt.Errorf("secrets provider for secret %s does not support secret versions", name)
+	}
+
+	return versioned.GetSecretVersion(name, version)
+}
+
+// Implemented by secrets providers that cache the secrets they fetch
+type InvalidatableSecretsProvider interface {
+	// Drops every cached version of the secret so that it is fetched again
+	Invalidate(name string)
+}
+
+// Drops the cached versions of the secret if the provider caches secrets
+func InvalidateSecret(provider SecretsProvider, name string) {
+	if p, ok := provider.(InvalidatableSecretsProvider); ok {
+		p.Invalidate(name)
+	}
+}
+
+// Creates a secrets provider able to fetch the given secret reference
+type SecretsProviderFactory func(ref *SecretRef) (SecretsProvider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[SecretsProviderType]SecretsProviderFactory{
+		AWSSMSecretsProviderType: func(ref *SecretRef) (SecretsProvider, error) {
+			return SharedAWSSecretsManager(ref.AWSClientConfig)
+		},
+	}
+)
+
+// Registers a secrets provider factory under the given provider type,
+// replacing any factory previously registered under the same type
+func RegisterSecretsProvider(providerType SecretsProviderType, factory SecretsProviderFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[providerType] = factory
+}
+
+// Returns the sorted list of registered secrets provider types
+func RegisteredSecretsProviders() []SecretsProviderType {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	types := make([]SecretsProviderType, 0, len(factories))
+	for t := range factories {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func isRegistered(providerType SecretsProviderType) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	_, ok := factories[providerType]
+	return ok
+}
+
+// Creates a secrets provider able to fetch the given secret reference,
+// using the factory registered for the provider type of the reference
+func NewSecretsProvider(ref *SecretRef) (SecretsProvider, error) {
+	providerType := ref.ProviderType()
+
+	factoriesMu.RLock()
+	factory, ok := factories[providerType]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%s is not a registered secrets provider", providerType)
+	}
+
+	return factory(ref)
 }
 
 type SecretRef struct {
+	// The secrets provider holding the secret, defaults to aws_sm.
+	// Only used where the provider is not implied by the surrounding configuration.
+	Provider   string `yaml:"provider,omitempty"`
 	SecretName string `yaml:"secretName"`
-	SecretKey  string `yaml:"secretKey,omitempty"`
+	// Key of the value in a JSON secret. Nested values are addressed with
+	// dotted paths such as db.port or servers[0].host. The whole secret string
+	// is used if the key is not set
+	SecretKey string `yaml:"secretKey,omitempty"`
+	// Encoding of the referenced value, set to base64 to decode it
+	Encoding string `yaml:"encoding,omitempty"`
+	// The version of the secret, the current version is used if not set
+	SecretVersion `yaml:",inline"`
+	// The AWS account, region and endpoint to fetch the secret from (aws_sm only)
+	AWSClientConfig `yaml:",inline"`
+}
+
+// Returns the secrets provider type of the reference, falling back to the default
+func (s *SecretRef) ProviderType() SecretsProviderType {
+	if s.Provider == "" {
+		return DefaultSecretsProviderType
+	}
+	return SecretsProviderType(s.Provider)
+}
+
+// Returns a key that is equal for all references that can be fetched
+// with the same secrets provider instance
+func (s *SecretRef) ProviderKey() string {
+	if s.AWSClientConfig.IsZero() {
+		return string(s.ProviderType())
+	}
+	return fmt.Sprintf("%s[%s]", s.ProviderType(), s.AWSClientConfig)
+}
+
+// Returns a copy of the reference where the version and AWS client settings
+// that are not set on the reference are taken from the given defaults
+func (s *SecretRef) WithDefaults(version SecretVersion, client AWSClientConfig) *SecretRef {
+	ref := *s
+	if ref.SecretVersion.IsZero() {
+		ref.SecretVersion = version
+	}
+	ref.AWSClientConfig = ref.AWSClientConfig.withDefaults(client)
+	return &ref
+}
+
+// Fetches the referenced version of the secret from the provider
+func (s *SecretRef) Fetch(provider SecretsProvider) (*Secret, error) {
+	return GetSecretVersion(provider, s.SecretName, s.SecretVersion)
 }
 
 func (s *SecretRef) Validate() error {
 	if s.SecretName == "" {
-		return fmt.Errorf("secretRef missing 'secretName' attribute")
+		return validation.Errorf("secretName", "secretRef missing 'secretName' attribute")
 	}
-	if s.SecretKey == "" {
-		return fmt.Errorf("secretRef '%s' missing 'secretKey' attribute", s.SecretName)
+	errs := []error{}
+	if s.SecretKey != "" {
+		if _, err := parseKeyPath(s.SecretKey); err != nil {
+			errs = append(errs, validation.Errorf("secretKey", "secretRef '%s' has invalid 'secretKey': %w", s.SecretName, err))
+		}
+	}
+	if s.Encoding != "" && s.Encoding != Base64Encoding {
+		errs = append(errs, validation.Errorf("encoding", "secretRef '%s' has unsupported encoding %s", s.SecretName, s.Encoding))
+	}
+	if !isRegistered(s.ProviderType()) {
+		errs = append(errs, validation.Errorf("provider", "secretRef '%s' refers to unknown secrets provider %s", s.SecretName, s.Provider))
+	} else if !s.AWSClientConfig.IsZero() && s.ProviderType() != AWSSMSecretsProviderType {
+		errs = append(errs, validation.Errorf("provider", "secretRef '%s' sets AWS client settings but uses provider %s", s.SecretName, s.Provider))
 	}
-	return nil
+	if err := s.AWSClientConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
 
-// SecretRefToStructJsonField links a JSON field name in a generic map
-// to a specific key in a named secret, fetching the secret if needed.
-type SecretRefToStructJsonField struct {
-	// The JSON representation of a struct field name
-	StructJsonField string
-	// The secret reference that refers to this field of the struct
-	SecretRef *SecretRef
+// Returns a description of the referenced value for error messages
+func (s *SecretRef) describe() string {
+	if s.SecretKey == "" {
+		return fmt.Sprintf("secret %s", s.SecretName)
+	}
+	return fmt.Sprintf("key '%s' in secret %s", s.SecretKey, s.SecretName)
 }
 
-// PopulateJSONFieldFromSecret fetches (once) the secret identified by
-// SecretRef.SecretName from the provider `sp` (caching it in `secrets`),
-// then looks up SecretRef.SecretKey inside that secret, and finally
-// writes the resulting value into `jsonStruct` under StructJsonField.
-//
-// Panics if either `secrets` or `jsonStruct` is nil. Returns an error if
-// the secret fetch fails or the specified key is missing/nil.
-//
-// After populating all the JSON fields your struct requires, you can then
-// marshal the jsonStruct map to a byte array and then unmarshal that back
-// into your concrete struct type, using the json library to do type conversions.
-//
-//	sp:         the secrets provider implementation
-//	secrets:    cache of fetched secrets, keyed by secret name
-//	jsonStruct: target map into which the secret value is inserted
-func (s *SecretRefToStructJsonField) PopulateJSONFieldFromSecret(sp SecretsProvider, secrets map[string]map[string]any, jsonStruct map[string]any) error {
-	if secrets == nil {
-		panic("secrets map must be initialized")
+// Looks up the referenced value in the given secret. This is the value at the
+// key path of a JSON secret, or the whole secret string if no key is set
+func (s *SecretRef) Lookup(secret *Secret) (any, error) {
+	if s.SecretKey == "" {
+		return s.decode(secret.String)
 	}
 
-	if jsonStruct == nil {
-		panic("jsonStruct must be initialized")
+	vAny, err := secret.Get(s.SecretKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s could not be read: %w", s.describe(), err)
 	}
 
-	if s.SecretRef == nil {
-		return fmt.Errorf("secret ref for field '%s' is missing (nil)", s.StructJsonField)
+	if vAny == nil {
+		return nil, fmt.Errorf("%s is null", s.describe())
 	}
 
-	var secret map[string]any
+	if str, ok := vAny.(string); ok {
+		return s.decode(str)
+	}
 
-	secret, ok := secrets[s.SecretRef.SecretName]
+	if s.Encoding != "" {
+		return nil, fmt.Errorf("%s is a %s and cannot be decoded as %s", s.describe(), jsonTypeName(vAny), s.Encoding)
+	}
 
-	if !ok {
-		newSecret, err := sp.GetSecret(s.SecretRef.SecretName)
+	return vAny, nil
+}
 
-		if err != nil {
-			return err
-		}
-		// update the secrets map with the newly fetched secret
-		secrets[s.SecretRef.SecretName] = newSecret
-		secret = newSecret
+func (s *SecretRef) decode(value string) (any, error) {
+	if s.Encoding != Base64Encoding {
+		return value, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid base64: %w", s.describe(), err)
 	}
+	return string(decoded), nil
+}
 
-	vAny, ok := secret[s.SecretRef.SecretKey]
+// Looks up the referenced value in the given secret and returns it as a string.
+// Numbers and booleans are formatted, objects and arrays are rejected
+func (s *SecretRef) LookupString(secret *Secret) (string, error) {
+	vAny, err := s.Lookup(secret)
+	if err != nil {
+		return "", err
+	}
 
-	if !ok {
-		return fmt.Errorf("key '%s' not present in secret %s", s.SecretRef.SecretKey, s.SecretRef.SecretName)
+	value, err := CoerceString(vAny)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", s.describe(), err)
 	}
 
-	if vAny == nil {
-		return fmt.Errorf("key '%s' in secret %s is nil", s.SecretRef.SecretKey, s.SecretRef.SecretName)
+	return value, nil
+}
+
+// Looks up the referenced value in the given secret and returns it as an integer.
+// Numeric strings such as "5432" are converted
+func (s *SecretRef) LookupInt(secret *Secret) (int, error) {
+	vAny, err := s.Lookup(secret)
+	if err != nil {
+		return 0, err
 	}
 
-	jsonStruct[s.StructJsonField] = vAny
+	value, err := CoerceInt(vAny)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", s.describe(), err)
+	}
 
-	return nil
+	return value, nil
 }
@@ -1,27 +1,20 @@
 package secrets_provider
 
 import (
-	"encoding/json"
-	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gopkg.in/yaml.v3"
 )
 
 type MockSecretsProvider struct {
 	mock.Mock
 }
 
-func (m *MockSecretsProvider) GetSecret(name string) (map[string]any, error) {
+func (m *MockSecretsProvider) GetSecret(name string) (*Secret, error) {
 	args := m.Called(name)
-	return args.Get(0).(map[string]any), args.Error(1)
-}
-
-type TestSecrets struct {
-	Foo string  `json:"foo,omitempty"`
-	Bar int     `json:"bar,omitempty"`
-	Baz float32 `json:"baz,omitempty"`
+	return args.Get(0).(*Secret), args.Error(1)
 }
 
 func Test_SecretRef_Validate_Succeeds(t *testing.T) {
@@ -36,153 +29,156 @@ func Test_SecretRef_Validate_FailsWithMissingSecretName(t *testing.T) {
 	assert.Error(secretRef.Validate())
 }
 
-func Test_SecretRef_Validate_FailsWithMissingSecretKey(t *testing.T) {
+func Test_SecretRef_Validate_SucceedsWithoutSecretKey(t *testing.T) {
 	assert := assert.New(t)
 	secretRef := &SecretRef{SecretName: "foo"}
-	assert.Error(secretRef.Validate())
+	assert.NoError(secretRef.Validate())
 }
 
-func Test_SecretRefToStructJsonField_WorksAndCachesSecrets(t *testing.T) {
-	sp := new(MockSecretsProvider)
-	spSecret := map[string]any{"1": "hello", "2": float64(45), "3": float64(13.4)}
-	sp.On("GetSecret", "test").Return(spSecret, nil)
-
-	secrets := make(map[string]map[string]any)
-	jsonStruct := make(map[string]any)
+func Test_SecretRef_Validate_FailsWithInvalidSecretKeyPath(t *testing.T) {
 	assert := assert.New(t)
-
-	for _, s := range []SecretRefToStructJsonField{
-		{StructJsonField: "foo", SecretRef: &SecretRef{SecretName: "test", SecretKey: "1"}},
-		{StructJsonField: "bar", SecretRef: &SecretRef{SecretName: "test", SecretKey: "2"}},
-		{StructJsonField: "baz", SecretRef: &SecretRef{SecretName: "test", SecretKey: "3"}},
-	} {
-		err := s.PopulateJSONFieldFromSecret(sp, secrets, jsonStruct)
-		assert.NoError(err)
+	for _, key := range []string{"a.", "a[x]", "a[0", "$['a"} {
+		secretRef := &SecretRef{SecretName: "foo", SecretKey: key}
+		assert.Error(secretRef.Validate(), key)
 	}
+}
 
-	sp.AssertNumberOfCalls(t, "GetSecret", 1)
-	assert.Contains(jsonStruct, "foo")
-	assert.Contains(jsonStruct, "bar")
-	assert.Contains(jsonStruct, "baz")
-	assert.Equal(jsonStruct["foo"], "hello")
-	assert.Equal(jsonStruct["bar"], float64(45))
-	assert.Equal(jsonStruct["baz"], float64(13.4))
-
-	data, err := json.Marshal(jsonStruct)
-	assert.Nil(err)
-	concreteStruct := &TestSecrets{}
-	err = json.Unmarshal(data, concreteStruct)
-	assert.Nil(err)
-	assert.Equal(concreteStruct.Foo, "hello")
-	assert.Equal(concreteStruct.Bar, 45)
-	assert.Equal(concreteStruct.Baz, float32(13.4))
-}
-
-func Test_SecretRefToStructJsonField_FailsWhenSecretMissing(t *testing.T) {
-	sp := new(MockSecretsProvider)
-	spSecret := map[string]any{}
-	sp.On("GetSecret", "test").Return(spSecret, fmt.Errorf("not found"))
-
-	secrets := make(map[string]map[string]any)
-	jsonStruct := make(map[string]any)
+func Test_SecretRef_Validate_FailsWithUnsupportedEncoding(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError((&SecretRef{SecretName: "foo", Encoding: "base64"}).Validate())
+	assert.Error((&SecretRef{SecretName: "foo", Encoding: "hex"}).Validate())
+}
 
-	secretRefJson := SecretRefToStructJsonField{
-		StructJsonField: "foo",
-		SecretRef:       &SecretRef{SecretName: "test", SecretKey: "NOT_CORRECT_KEY"},
-	}
-	err := secretRefJson.PopulateJSONFieldFromSecret(sp, secrets, jsonStruct)
+func Test_SecretRef_Validate_FailsWithUnknownProvider(t *testing.T) {
+	assert := assert.New(t)
+	secretRef := &SecretRef{Provider: "unknown", SecretName: "foo", SecretKey: "bar"}
+	assert.Error(secretRef.Validate())
+}
 
+func Test_SecretRef_ProviderType_DefaultsToAWSSM(t *testing.T) {
 	assert := assert.New(t)
-	assert.Error(err)
-	assert.NotContains(secrets, "test")
-	sp.AssertNumberOfCalls(t, "GetSecret", 1)
+	assert.Equal(AWSSMSecretsProviderType, (&SecretRef{}).ProviderType())
+	assert.Equal(SecretsProviderType("custom"), (&SecretRef{Provider: "custom"}).ProviderType())
 }
 
-func Test_SecretRefToStructJsonField_FailsWhenSecretKeyMissing(t *testing.T) {
-	sp := new(MockSecretsProvider)
-	spSecret := map[string]any{"1": "hello"}
-	sp.On("GetSecret", "test").Return(spSecret, nil)
+func Test_NewSecretsProvider_CreatesRegisteredProvider(t *testing.T) {
+	provider := new(MockSecretsProvider)
+	RegisterSecretsProvider("test_registered", func(ref *SecretRef) (SecretsProvider, error) {
+		return provider, nil
+	})
 
-	secrets := make(map[string]map[string]any)
-	jsonStruct := make(map[string]any)
+	assert := assert.New(t)
+	assert.Contains(RegisteredSecretsProviders(), SecretsProviderType("test_registered"))
+	assert.NoError((&SecretRef{Provider: "test_registered", SecretName: "a", SecretKey: "b"}).Validate())
 
-	secretRefJson := SecretRefToStructJsonField{
-		StructJsonField: "foo",
-		SecretRef:       &SecretRef{SecretName: "test", SecretKey: "NOT_CORRECT_KEY"},
-	}
-	err := secretRefJson.PopulateJSONFieldFromSecret(sp, secrets, jsonStruct)
+	created, err := NewSecretsProvider(&SecretRef{Provider: "test_registered"})
+	assert.NoError(err)
+	assert.Same(provider, created)
+}
 
+func Test_NewSecretsProvider_FailsForUnregisteredProvider(t *testing.T) {
+	_, err := NewSecretsProvider(&SecretRef{Provider: "not_registered"})
 	assert := assert.New(t)
 	assert.Error(err)
-	assert.NotContains(jsonStruct, "foo")
-	sp.AssertNumberOfCalls(t, "GetSecret", 1)
 }
 
-func Test_SecretRefToStructJsonField_FailsWhenSecretValueNil(t *testing.T) {
-	sp := new(MockSecretsProvider)
-	spSecret := map[string]any{"1": nil}
-	sp.On("GetSecret", "test").Return(spSecret, nil)
+func Test_SecretRef_LookupString_FormatsValues(t *testing.T) {
+	secret := NewSecret(`{"s": "x", "f": 5432, "b": true, "n": null, "m": {}}`)
+	assert := assert.New(t)
 
-	secrets := make(map[string]map[string]any)
-	jsonStruct := make(map[string]any)
+	for key, expected := range map[string]string{"s": "x", "f": "5432", "b": "true"} {
+		v, err := (&SecretRef{SecretName: "a", SecretKey: key}).LookupString(secret)
+		assert.NoError(err)
+		assert.Equal(expected, v)
+	}
 
-	secretRefJson := SecretRefToStructJsonField{
-		StructJsonField: "foo",
-		SecretRef:       &SecretRef{SecretName: "test", SecretKey: "1"},
+	for _, key := range []string{"n", "m", "missing"} {
+		_, err := (&SecretRef{SecretName: "a", SecretKey: key}).LookupString(secret)
+		assert.Error(err)
 	}
-	err := secretRefJson.PopulateJSONFieldFromSecret(sp, secrets, jsonStruct)
+}
+
+func Test_SecretRef_Validate_FailsWithAWSClientSettingsForOtherProvider(t *testing.T) {
+	RegisterSecretsProvider("test_other", func(ref *SecretRef) (SecretsProvider, error) {
+		return new(MockSecretsProvider), nil
+	})
+	secretRef := &SecretRef{Provider: "test_other", SecretName: "foo", SecretKey: "bar"}
+	secretRef.Region = "eu-west-1"
 
 	assert := assert.New(t)
-	assert.Error(err)
-	assert.NotContains(jsonStruct, "foo")
-	sp.AssertNumberOfCalls(t, "GetSecret", 1)
+	assert.Error(secretRef.Validate())
 }
 
-func Test_SecretRefToStructJsonField_PanicsWhenSecretsUninitialized(t *testing.T) {
-	sp := new(MockSecretsProvider)
+func Test_SecretRef_Validate_FailsWithInvalidAWSClientSettings(t *testing.T) {
+	secretRef := &SecretRef{SecretName: "foo", SecretKey: "bar"}
+	secretRef.ExternalId = "id"
 
-	var secrets map[string]map[string]any
+	assert := assert.New(t)
+	assert.Error(secretRef.Validate())
+}
 
-	jsonStruct := make(map[string]any)
-	secret := SecretRefToStructJsonField{
-		StructJsonField: "foo",
-		SecretRef:       &SecretRef{SecretName: "test", SecretKey: "1"},
-	}
+func Test_SecretRef_ProviderKey_DiffersPerClientConfig(t *testing.T) {
+	a := &SecretRef{SecretName: "a", SecretKey: "b"}
+	b := &SecretRef{SecretName: "c", SecretKey: "d"}
+	c := &SecretRef{SecretName: "a", SecretKey: "b", AWSClientConfig: AWSClientConfig{Region: "eu-west-1"}}
 
 	assert := assert.New(t)
-	assert.Panics(func() {
-		secret.PopulateJSONFieldFromSecret(sp, secrets, jsonStruct) //nolint:errcheck
-	})
+	assert.Equal("aws_sm", a.ProviderKey())
+	assert.Equal(a.ProviderKey(), b.ProviderKey())
+	assert.Equal("aws_sm[region=eu-west-1]", c.ProviderKey())
 }
 
-func Test_SecretRefToStructJsonField_PanicsWhenJsonStructUninitialized(t *testing.T) {
-	sp := new(MockSecretsProvider)
+func Test_SecretRef_WithDefaults_KeepsExplicitSettings(t *testing.T) {
+	ref := &SecretRef{
+		SecretName:      "a",
+		SecretKey:       "b",
+		SecretVersion:   SecretVersion{VersionId: "v1"},
+		AWSClientConfig: AWSClientConfig{Region: "eu-west-1"},
+	}
+	withDefaults := ref.WithDefaults(
+		SecretVersion{VersionStage: "AWSPREVIOUS"},
+		AWSClientConfig{Region: "us-east-1", RoleArn: "arn:aws:iam::123456789012:role/x"},
+	)
 
-	secrets := make(map[string]map[string]any)
-	var jsonStruct map[string]any
+	assert := assert.New(t)
+	assert.Equal(SecretVersion{VersionId: "v1"}, withDefaults.SecretVersion)
+	assert.Equal(AWSClientConfig{Region: "eu-west-1", RoleArn: "arn:aws:iam::123456789012:role/x"}, withDefaults.AWSClientConfig)
+	assert.Equal(AWSClientConfig{Region: "eu-west-1"}, ref.AWSClientConfig, "does not modify the original")
 
-	secret := SecretRefToStructJsonField{
-		StructJsonField: "foo",
-		SecretRef:       &SecretRef{SecretName: "test", SecretKey: "1"},
-	}
+	withDefaults = (&SecretRef{SecretName: "a", SecretKey: "b"}).WithDefaults(SecretVersion{VersionStage: "AWSPREVIOUS"}, AWSClientConfig{})
+	assert.Equal(SecretVersion{VersionStage: "AWSPREVIOUS"}, withDefaults.SecretVersion)
+}
 
+func Test_SecretRef_FromYaml(t *testing.T) {
+	data := `
+secretName: a
+secretKey: b
+versionStage: AWSPREVIOUS
+region: eu-west-1
+roleArn: arn:aws:iam::123456789012:role/x
+externalId: ext
+endpointUrl: http://localhost:4566
+`
+	ref := &SecretRef{}
 	assert := assert.New(t)
-	assert.Panics(func() {
-		secret.PopulateJSONFieldFromSecret(sp, secrets, jsonStruct) //nolint:errcheck
-	})
+	assert.NoError(yaml.Unmarshal([]byte(data), ref))
+	assert.NoError(ref.Validate())
+	assert.Equal("AWSPREVIOUS", ref.VersionStage)
+	assert.Equal(AWSClientConfig{
+		Region:      "eu-west-1",
+		RoleArn:     "arn:aws:iam::123456789012:role/x",
+		ExternalId:  "ext",
+		EndpointUrl: "http://localhost:4566",
+	}, ref.AWSClientConfig)
 }
 
-func Test_SecretRefToStructJsonField_FailsWhenSecretRefNil(t *testing.T) {
+func Test_GetSecretVersion_FailsForUnversionedProvider(t *testing.T) {
 	sp := new(MockSecretsProvider)
-
-	secrets := make(map[string]map[string]any)
-	jsonStruct := make(map[string]any)
-
-	secret := SecretRefToStructJsonField{
-		StructJsonField: "foo",
-		SecretRef:       nil,
-	}
+	sp.On("GetSecret", "a").Return(NewSecret(`{}`), nil)
 
 	assert := assert.New(t)
-	assert.Error(secret.PopulateJSONFieldFromSecret(sp, secrets, jsonStruct))
+	_, err := GetSecretVersion(sp, "a", SecretVersion{})
+	assert.NoError(err)
+	_, err = GetSecretVersion(sp, "a", SecretVersion{VersionStage: "AWSPREVIOUS"})
+	assert.Error(err)
 }
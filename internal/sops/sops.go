@@ -0,0 +1,352 @@
+package sops
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/sourcehawk/go-flyway/internal/validation"
+	"gopkg.in/yaml.v3"
+)
+
+// Key of the SOPS metadata at the root of an encrypted file
+const MetadataKey = "sops"
+
+// Environment variables holding the age identities, the same as used by sops
+const (
+	AgeKeyEnv     = "SOPS_AGE_KEY"
+	AgeKeyFileEnv = "SOPS_AGE_KEY_FILE"
+)
+
+// Path of the age identities file in the user config directory, used when
+// neither environment variable is set
+const ageKeyUserConfigPath = "sops/age/keys.txt"
+
+// The MAC of files encrypted with mac_only_encrypted starts with these bytes
+var macOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+var encryptedPattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]`)
+
+type ageKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// The part of the SOPS metadata needed to decrypt a file with age
+type metadata struct {
+	Age       []ageKey `yaml:"age"`
+	KeyGroups []struct {
+		Age []ageKey `yaml:"age"`
+	} `yaml:"key_groups"`
+	ShamirThreshold  int    `yaml:"shamir_threshold"`
+	LastModified     string `yaml:"lastmodified"`
+	MAC              string `yaml:"mac"`
+	MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+}
+
+// Reports whether the mapping is the root of a SOPS encrypted file
+func IsEncrypted(root *yaml.Node) bool {
+	if root.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == MetadataKey {
+			return root.Content[i+1].Kind == yaml.MappingNode
+		}
+	}
+	return false
+}
+
+// Decrypts the values of a SOPS encrypted file in place and removes its
+// metadata, so that the decrypted values never leave memory.
+//
+// The data key is decrypted with the age identities of SOPS_AGE_KEY,
+// SOPS_AGE_KEY_FILE or the sops keys file in the user config directory.
+// Files that were modified after they were encrypted are rejected
+func Decrypt(root *yaml.Node) error {
+	var meta metadata
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != MetadataKey {
+			continue
+		}
+		if err := root.Content[i+1].Decode(&meta); err != nil {
+			return fmt.Errorf("invalid %s metadata: %w", MetadataKey, err)
+		}
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+		break
+	}
+
+	key, err := meta.dataKey()
+	if err != nil {
+		return err
+	}
+
+	d := &decrypter{key: key, hash: sha512.New(), macOnlyEncrypted: meta.MACOnlyEncrypted}
+	if meta.MACOnlyEncrypted {
+		d.hash.Write(macOnlyEncryptedInitialization)
+	}
+
+	errs := []error{}
+	d.walk(root, nil, "", &errs)
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	return meta.verify(key, fmt.Sprintf("%X", d.hash.Sum(nil)))
+}
+
+// Decrypts the data key of the file with the first age recipient that one of
+// the available identities can decrypt
+func (m *metadata) dataKey() ([]byte, error) {
+	keys := m.Age
+	for _, group := range m.KeyGroups {
+		keys = append(keys, group.Age...)
+	}
+	if m.ShamirThreshold > 1 {
+		return nil, fmt.Errorf("files split with shamir_threshold are not supported")
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("the file is not encrypted for an age recipient, only age keys are supported")
+	}
+
+	identities, err := loadIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := make([]string, len(keys))
+	for i, k := range keys {
+		recipients[i] = k.Recipient
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(k.Enc)), identities...)
+		if err != nil {
+			continue
+		}
+		return io.ReadAll(r)
+	}
+
+	return nil, fmt.Errorf("none of the age identities can decrypt the file, it is encrypted for %s", strings.Join(recipients, ", "))
+}
+
+// Checks the MAC of the file against the MAC of its decrypted values
+func (m *metadata) verify(key []byte, mac string) error {
+	lastModified, err := time.Parse(time.RFC3339, m.LastModified)
+	if err != nil {
+		return fmt.Errorf("invalid %s.lastmodified: %w", MetadataKey, err)
+	}
+
+	expected, _, err := decryptValue(m.MAC, key, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the MAC of the file: %w", err)
+	}
+	if expected != mac {
+		return fmt.Errorf("MAC mismatch, the file was modified after it was encrypted")
+	}
+
+	return nil
+}
+
+// Loads the age identities from the environment, or from the sops keys file
+// in the user config directory if neither environment variable is set
+func loadIdentities() ([]age.Identity, error) {
+	identities := []age.Identity{}
+
+	if value := os.Getenv(AgeKeyEnv); value != "" {
+		ids, err := age.ParseIdentities(strings.NewReader(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", AgeKeyEnv, err)
+		}
+		identities = append(identities, ids...)
+	}
+
+	if path := os.Getenv(AgeKeyFileEnv); path != "" {
+		ids, err := readIdentities(path)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, ids...)
+	} else if path := userKeysFile(); len(identities) == 0 && path != "" {
+		ids, err := readIdentities(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		identities = append(identities, ids...)
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no age identity to decrypt the file with, set %s or %s", AgeKeyFileEnv, AgeKeyEnv)
+	}
+
+	return identities, nil
+}
+
+// Reads the age identities of a keys file
+func readIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read age identities: %w", err)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identities in %s: %w", path, err)
+	}
+	return identities, nil
+}
+
+// Returns the path of the sops keys file in the user config directory
+func userKeysFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(dir, ageKeyUserConfigPath)
+}
+
+type decrypter struct {
+	key              []byte
+	hash             hash.Hash
+	macOnlyEncrypted bool
+}
+
+// Decrypts the encrypted values under the node and adds every value to the
+// MAC, in the same order as sops does.
+//
+// Values are authenticated together with the mapping keys leading to them
+// so that they cannot be moved around. Errors are reported at the
+// configuration path of the value
+func (d *decrypter) walk(node *yaml.Node, keys []string, path string, errs *[]error) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			d.walk(node.Content[i+1], append(keys[:len(keys):len(keys)], key), validation.JoinPath(path, key), errs)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			d.walk(item, keys, validation.JoinPath(path, fmt.Sprintf("[%d]", i)), errs)
+		}
+	case yaml.ScalarNode:
+		if err := d.scalar(node, keys); err != nil {
+			*errs = append(*errs, validation.Errorf(path, "%v", err))
+		}
+	}
+}
+
+func (d *decrypter) scalar(node *yaml.Node, keys []string) error {
+	if !encryptedPattern.MatchString(node.Value) {
+		if d.macOnlyEncrypted {
+			return nil
+		}
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		return d.add(value)
+	}
+
+	plaintext, valueType, err := decryptValue(node.Value, d.key, strings.Join(keys, ":")+":")
+	if err != nil {
+		return err
+	}
+
+	var value any
+	switch valueType {
+	case "str", "bytes":
+		value = plaintext
+		node.Tag = "!!str"
+	case "int":
+		value, err = strconv.Atoi(plaintext)
+		node.Tag = "!!int"
+	case "float":
+		value, err = strconv.ParseFloat(plaintext, 64)
+		node.Tag = "!!float"
+	case "bool":
+		var b bool
+		b, err = strconv.ParseBool(plaintext)
+		value, plaintext = b, strconv.FormatBool(b)
+		node.Tag = "!!bool"
+	default:
+		return fmt.Errorf("unknown type %s of encrypted value", valueType)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value: %w", valueType, err)
+	}
+
+	node.Value = plaintext
+	node.Style = 0
+	return d.add(value)
+}
+
+// Adds the value to the MAC in the representation used by sops
+func (d *decrypter) add(value any) error {
+	switch v := value.(type) {
+	case nil:
+	case string:
+		d.hash.Write([]byte(v))
+	case int:
+		d.hash.Write([]byte(strconv.Itoa(v)))
+	case float64:
+		d.hash.Write([]byte(strconv.FormatFloat(v, 'f', -1, 64)))
+	case bool:
+		if v {
+			d.hash.Write([]byte("True"))
+		} else {
+			d.hash.Write([]byte("False"))
+		}
+	default:
+		return fmt.Errorf("unsupported value of type %T", value)
+	}
+	return nil
+}
+
+// Decrypts a value in the sops format, ENC[AES256_GCM,data:...,iv:...,tag:...,type:...],
+// returning the plaintext and its type
+func decryptValue(value string, key []byte, additionalData string) (string, string, error) {
+	matches := encryptedPattern.FindStringSubmatch(value)
+	if matches == nil {
+		return "", "", fmt.Errorf("value is not in the sops format")
+	}
+
+	parts := make([][]byte, 3)
+	for i, name := range []string{"data", "iv", "tag"} {
+		decoded, err := base64.StdEncoding.DecodeString(matches[i+1])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid %s: %w", name, err)
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return string(plaintext), matches[4], nil
+}
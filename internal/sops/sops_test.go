@@ -0,0 +1,184 @@
+package sops
+
+import (
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// Identity of the recipient that the test files are encrypted for
+const testAgeKey = "AGE-SECRET-KEY-1Q9VLZ4KUYH59NHZD2MKACVD7N93MYSCMKMLJUWGSL9VJEA4P3RMSF34RLU"
+
+// Encrypted with sops --encrypt --age
+const testEncryptedFile = `credentials:
+    provider: ENC[AES256_GCM,data:EfLvsg==,iv:DQ8iZVL090sh2FZRaX/6i8JfuBTgoHjUWfPH1WZ9Vrg=,tag:lJZzvYNvPFrNWyfRFrtpPA==,type:str]
+    text:
+        username: ENC[AES256_GCM,data:CY8O4ms=,iv:hGWAo1UFAc5QJS5QPfUGT2HnhHKHhx2ZywHN9bAccJA=,tag:3tszH1U0dThG32fOY6E0Nw==,type:str]
+        password: ENC[AES256_GCM,data:wiRZiQe2,iv:H02mVfWtuGr7iG3ge4ncAi9Nb15NOkU/a6D42Ou34AI=,tag:3KlB4HEtYjWnBgdJbQpFpQ==,type:str]
+        host: ENC[AES256_GCM,data:Xroq4wMP0ylu,iv:DtpAFuBEiTpuldlWkPZom/01sD/7D14wIpq21n1K7/U=,tag:naACt5YAfzLVxEjaxVUxZw==,type:str]
+        port: ENC[AES256_GCM,data:h3AwFQ==,iv:xyTBY1sg+WDMTKcbror6pvWsq0BTBhuvnfDCBYI0LtI=,tag:2YXZguwuS3A02i0CMst37Q==,type:int]
+        database: ENC[AES256_GCM,data:Q116GrC1rP0=,iv:p6w+GqI/sq8WZ1cpa2UD+4isVYi62lgq3ByLBwr2+/o=,tag:Q19U+e4/6MPlH+NtYL09hA==,type:str]
+schemas:
+    - name: ENC[AES256_GCM,data:0IxWK3BbFw==,iv:AGW/tQn4LpwqoadA4IBPl4S/aFq//A6g3AqrdwWvons=,tag:x0kALO1GOrQCP7t0DUY3iA==,type:str]
+      enabled: ENC[AES256_GCM,data:b8zv3g==,iv:sIqbjoJy470fe1/EB7TxAUczjlTocosO4DoHGkGvSeo=,tag:ge9UWE2et+3ODZfEE5cvjg==,type:bool]
+      placeholders:
+        - name: ENC[AES256_GCM,data:IXCzkkqUyg==,iv:PeIB46k7GGX5bRu992scLovkBQHWVNHrl3lpM01KX00=,tag:NtuYo/LqDdpODi/dNHWzDw==,type:str]
+          value: ENC[AES256_GCM,data:pPiL9rCF,iv:dhuugY3SjiSJ+x2tw2DXBxSF0tc4HvpalZq4XmC+QGM=,tag:wfFnYejrxYbV07UgkzGagQ==,type:str]
+      flywayArgs:
+        - ENC[AES256_GCM,data:IvB75/Px/Fn3uRCDszdxhMY=,iv:4ETq5q7svbIUeT93dtRIp4y3TMnWgcwtQ855e2NVVsY=,tag:pPJ2mO6WYCqpGDoQ8KtkNg==,type:str]
+ratio: ENC[AES256_GCM,data:FX8A,iv:rw41xQj4yE1wl3E83NWJJqa/cTP66agV7QJ0mT55g0U=,tag:DKt5T5ZU1qd1aq+GoFRfnQ==,type:float]
+sops:
+    kms: []
+    gcp_kms: []
+    azure_kv: []
+    hc_vault: []
+    age:
+        - recipient: age1an43h83py8atuyl0t063vv2uueray2uwhhwpj7c5vamfrwgejswqytjj2a
+          enc: |
+            -----BEGIN AGE ENCRYPTED FILE-----
+            YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBLTStscGtVZlQ4aEIzQ1E2
+            aXk2TkF6ODNyRkQzYURZM0xJWWxpckhwYkFJClBrSTZVMVhjUTNQQXJJT3VJWHRH
+            VHBwaitOQnlCOCtBeUpJSTBHeVZXRDQKLS0tIGYwRDdBSWluNGVJb04xdDlmczlM
+            V3JEKzNkZjZZeEpUVkFscjZRWFpsQjgKvd/H4di9+u6FlbgNsRH0U+JbFxRCuFjO
+            w+uoMYPZ6r89LQJ+uCYChDP+WdiRsZ84EPWxUJpqYZ/YUOZ0QAn26g==
+            -----END AGE ENCRYPTED FILE-----
+    lastmodified: "2026-10-18T16:39:26Z"
+    mac: ENC[AES256_GCM,data:EgqGz13ALvZKGxM6pyMrh9PKKUGgmRXjHwD2ek5LrYVaF7V4iD3x2cUabWxmuLMbadPKeAI8+Cj8JdwJp0gYoVpLI3ETV5gI/pIXuG0mnq5A9NspjF2xxEmp+PfZG0Jm8vEbXrJxnPu+mhuoByNv2K0maZepM824HjMJXVqIwZk=,iv:qpzCuCnpOqoNofTrfh4Mc0ogELaW/2EXs01vEqTvzzM=,tag:tlb94vKiMRGI9RZejRxuZw==,type:str]
+    pgp: []
+    unencrypted_suffix: _unencrypted
+    version: 3.9.4
+`
+
+// Encrypted with sops --encrypt --age --encrypted-regex '^(password|port|enabled|ratio|value)$'
+const testPartiallyEncryptedFile = `credentials:
+    provider: text
+    text:
+        username: admin
+        password: ENC[AES256_GCM,data:u53FY5s4,iv:D/WrI+gJ94eYtQay2rJkYRy/OoCYNQRdeurn4Gv/1Fg=,tag:ve6vlhpZyCc/8HzwmX9QcQ==,type:str]
+        host: localhost
+        port: ENC[AES256_GCM,data:5wYaxw==,iv:blEiChpLqSA6/iJjFF2SzsuYBV8Tla+gPoNw/XB6b8c=,tag:MF7ZFHhsBmpRuMJRVynX7A==,type:int]
+        database: postgres
+schemas:
+    - name: billing
+      enabled: ENC[AES256_GCM,data:a2lgcQ==,iv:YHwTrmdj4jIoZhj6wyAfzvMD5qovmZh2MrGFhrEbtU8=,tag:60SIpBhB/Bl8EM0CylEUXQ==,type:bool]
+      placeholders:
+        - name: api_key
+          value: ENC[AES256_GCM,data:47LOoIfn,iv:SbVTDMz9yo+yPVAMhsVK9C8ohTwE6suaJwTHsPf8EDM=,tag:mPhOJEiUqdz3NdJbS5Nbmg==,type:str]
+      flywayArgs:
+        - -connectRetries=3
+ratio: ENC[AES256_GCM,data:quf1,iv:+el5YlPJMVm6cLyrb0u1QWmUOclocoa9gIbEjNoEmMI=,tag:CnM8yjw1LuHqNORYdBGerg==,type:float]
+sops:
+    kms: []
+    gcp_kms: []
+    azure_kv: []
+    hc_vault: []
+    age:
+        - recipient: age1an43h83py8atuyl0t063vv2uueray2uwhhwpj7c5vamfrwgejswqytjj2a
+          enc: |
+            -----BEGIN AGE ENCRYPTED FILE-----
+            YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBNUThzVm8zQkk3TXpEN0Rt
+            dUw1eExoSWt5enRZLzk0emFKa2lwMTNLdzNnClRGSjZtdkZTOUxxcTA5Y21IZ0F3
+            Y0VUb0FUMTh0d3NJN2NFMng2M0MxTUkKLS0tIDlQdmcxdVYxY0lXZ21DR3BuSHNE
+            Zy9JZzd3L1N6emJKcG9MNEgxME51ekUKs81Oo3jVzCf9i3MlS5J0GbkeZdVTAs/d
+            altsjyhTdkfCdJSADTBuU9vu5K/1pNn1dudW/R/tVqv8edK+T3e4RQ==
+            -----END AGE ENCRYPTED FILE-----
+    lastmodified: "2026-10-18T16:39:30Z"
+    mac: ENC[AES256_GCM,data:XopjU0cRT6stPMBs9Tsi5YG5cCxYSo6sdTJx/ZGuraCoC1H7dRnwK1KQncDr8/gAMh+kLUV7OE/YLK/CQ3KcwoO34hisjq5y0s4aHQa2ENsevFzncs5FF9qwtlK+X6f28xpVaFNjHXnumdpXTwo1rYsOtRuC/Hg2AnD6QRSggRc=,iv:1mHqcTD+YgEuv4+4ldQWCTeG8P207HWsso4i3NhP3lU=,tag:l2vAO99VGWlmI/kq9x7T/g==,type:str]
+    pgp: []
+    encrypted_regex: ^(password|port|enabled|ratio|value)$
+    version: 3.9.4
+`
+
+func parseRoot(t *testing.T, data string) *yaml.Node {
+	var file yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(data), &file))
+	return file.Content[0]
+}
+
+func setIdentity(t *testing.T, key string) {
+	t.Setenv(AgeKeyEnv, key)
+	t.Setenv(AgeKeyFileEnv, "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+}
+
+type testDecryptedConfig struct {
+	Credentials struct {
+		Provider string `yaml:"provider"`
+		Text     struct {
+			Username string `yaml:"username"`
+			Password string `yaml:"password"`
+			Host     string `yaml:"host"`
+			Port     int    `yaml:"port"`
+		} `yaml:"text"`
+	} `yaml:"credentials"`
+	Schemas []struct {
+		Name         string              `yaml:"name"`
+		Enabled      bool                `yaml:"enabled"`
+		Placeholders []map[string]string `yaml:"placeholders"`
+		FlywayArgs   []string            `yaml:"flywayArgs"`
+	} `yaml:"schemas"`
+	Ratio float64 `yaml:"ratio"`
+}
+
+func Test_IsEncrypted_DetectsSopsMetadata(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsEncrypted(parseRoot(t, testEncryptedFile)))
+	assert.False(IsEncrypted(parseRoot(t, "credentials: {provider: text}")))
+	assert.False(IsEncrypted(parseRoot(t, "sops: not metadata")))
+}
+
+func Test_Decrypt_DecryptsValuesInPlace(t *testing.T) {
+	for name, data := range map[string]string{"full": testEncryptedFile, "partial": testPartiallyEncryptedFile} {
+		t.Run(name, func(t *testing.T) {
+			setIdentity(t, testAgeKey)
+			root := parseRoot(t, data)
+
+			assert := assert.New(t)
+			assert.NoError(Decrypt(root))
+			assert.False(IsEncrypted(root))
+
+			decrypted := &testDecryptedConfig{}
+			assert.NoError(root.Decode(decrypted))
+			assert.Equal("text", decrypted.Credentials.Provider)
+			assert.Equal("admin", decrypted.Credentials.Text.Username)
+			assert.Equal("s3cr3t", decrypted.Credentials.Text.Password)
+			assert.Equal(5432, decrypted.Credentials.Text.Port)
+			assert.Equal("billing", decrypted.Schemas[0].Name)
+			assert.True(decrypted.Schemas[0].Enabled)
+			assert.Equal("abc123", decrypted.Schemas[0].Placeholders[0]["value"])
+			assert.Equal([]string{"-connectRetries=3"}, decrypted.Schemas[0].FlywayArgs)
+			assert.Equal(0.5, decrypted.Ratio)
+		})
+	}
+}
+
+func Test_Decrypt_FailsOnModifiedFiles(t *testing.T) {
+	setIdentity(t, testAgeKey)
+	assert := assert.New(t)
+
+	modified := strings.Replace(testPartiallyEncryptedFile, "username: admin", "username: root", 1)
+	assert.EqualError(Decrypt(parseRoot(t, modified)), "MAC mismatch, the file was modified after it was encrypted")
+
+	// values are authenticated with their keys
+	root := parseRoot(t, testPartiallyEncryptedFile)
+	text := root.Content[1].Content[3]
+	text.Content[3].Value, text.Content[7].Value = text.Content[7].Value, text.Content[3].Value
+	err := Decrypt(root)
+	assert.ErrorContains(err, "credentials.text.password: failed to decrypt value")
+	assert.ErrorContains(err, "credentials.text.port: failed to decrypt value")
+}
+
+func Test_Decrypt_FailsWithoutIdentity(t *testing.T) {
+	assert := assert.New(t)
+
+	setIdentity(t, "")
+	assert.ErrorContains(Decrypt(parseRoot(t, testEncryptedFile)), "no age identity to decrypt the file with, set SOPS_AGE_KEY_FILE or SOPS_AGE_KEY")
+
+	other, err := age.GenerateX25519Identity()
+	assert.NoError(err)
+	setIdentity(t, other.String())
+	assert.ErrorContains(Decrypt(parseRoot(t, testEncryptedFile)), "none of the age identities can decrypt the file, it is encrypted for age1")
+}
@@ -0,0 +1,185 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// Checks that every mapping key in the node is decoded into a field of v,
+// reporting each unknown key, e.g a typo such as migrationPath, at its path.
+//
+// Values that do not match the shape of v are left for the decoder to report
+func KnownFields(node *yaml.Node, v any) error {
+	errs := []error{}
+	checkKnownFields(node, reflect.TypeOf(v), "", &errs)
+	return errors.Join(errs...)
+}
+
+func checkKnownFields(node *yaml.Node, t reflect.Type, path string, errs *[]error) {
+	if node == nil || t == nil {
+		return
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			checkKnownFields(child, t, path, errs)
+		}
+		return
+	case yaml.AliasNode:
+		// the anchored node is checked where it is defined
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields, anyKey := structFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if key == "<<" {
+				continue
+			}
+			fieldType, ok := fields[key]
+			if !ok {
+				if !anyKey {
+					*errs = append(*errs, UnknownKey(JoinPath(path, key), key, fieldNames(fields)))
+				}
+				continue
+			}
+			checkKnownFields(value, fieldType, JoinPath(path, key), errs)
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			checkKnownFields(item, t.Elem(), JoinPath(path, fmt.Sprintf("[%d]", i)), errs)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkKnownFields(node.Content[i+1], t.Elem(), JoinPath(path, node.Content[i].Value), errs)
+		}
+	}
+}
+
+// Returns the types of the fields decoded from the keys of a mapping, and
+// whether the struct accepts any key through an inlined map
+func structFields(t reflect.Type) (map[string]reflect.Type, bool) {
+	fields := map[string]reflect.Type{}
+	anyKey := false
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if strings.Contains(","+options+",", ",inline,") {
+			inlined := field.Type
+			for inlined.Kind() == reflect.Pointer {
+				inlined = inlined.Elem()
+			}
+			switch inlined.Kind() {
+			case reflect.Struct:
+				inner, innerAnyKey := structFields(inlined)
+				for k, v := range inner {
+					fields[k] = v
+				}
+				anyKey = anyKey || innerAnyKey
+			case reflect.Map:
+				anyKey = true
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+
+	return fields, anyKey
+}
+
+func fieldNames(fields map[string]reflect.Type) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Returns an error for an unknown key at the given path, suggesting the
+// known key that is closest to it if the unknown key is likely a typo
+func UnknownKey(path string, key string, known []string) error {
+	if suggestion := ClosestKey(key, known); suggestion != "" {
+		return Errorf(path, "unknown key %s, did you mean %s?", key, suggestion)
+	}
+	return Errorf(path, "unknown key %s", key)
+}
+
+// Returns the known key that is closest to the key, if the key is likely a
+// typo of it, or an empty string
+func ClosestKey(key string, known []string) string {
+	names := append([]string{}, known...)
+	sort.Strings(names)
+
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+// Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
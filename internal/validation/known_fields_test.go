@@ -0,0 +1,70 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+type knownFieldsInner struct {
+	Name string `yaml:"name"`
+}
+
+type knownFieldsOuter struct {
+	knownFieldsInner `yaml:",inline"`
+	Items            []*knownFieldsInner         `yaml:"items"`
+	ByKey            map[string]knownFieldsInner `yaml:"byKey"`
+	Untagged         string
+	Ignored          string `yaml:"-"`
+}
+
+func parseNode(t *testing.T, data string) *yaml.Node {
+	var node yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(data), &node))
+	return &node
+}
+
+func Test_KnownFields_AcceptsKnownKeys(t *testing.T) {
+	node := parseNode(t, `
+name: a
+untagged: b
+items:
+  - name: c
+byKey:
+  x:
+    name: d
+`)
+	assert := assert.New(t)
+	assert.NoError(KnownFields(node, &knownFieldsOuter{}))
+}
+
+func Test_KnownFields_ReportsEveryUnknownKeyWithPath(t *testing.T) {
+	node := parseNode(t, `
+nmae: a
+Ignored: b
+items:
+  - name: c
+  - name: d
+    other: e
+byKey:
+  x:
+    Name: f
+`)
+	errs := Errors(KnownFields(node, &knownFieldsOuter{}))
+	assert := assert.New(t)
+	assert.Len(errs, 4)
+	assert.EqualError(errs[0], "nmae: unknown key nmae, did you mean name?")
+	assert.EqualError(errs[1], "Ignored: unknown key Ignored")
+	assert.EqualError(errs[2], "items[1].other: unknown key other")
+	assert.EqualError(errs[3], "byKey.x.Name: unknown key Name, did you mean name?")
+}
+
+func Test_KnownFields_LeavesMismatchedShapesToDecoder(t *testing.T) {
+	node := parseNode(t, `
+items: notalist
+byKey: []
+`)
+	assert := assert.New(t)
+	assert.NoError(KnownFields(node, &knownFieldsOuter{}))
+}
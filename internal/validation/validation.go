@@ -0,0 +1,85 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FieldError is a validation error of a single value in the configuration
+type FieldError struct {
+	// Path of the value in the configuration, e.g schemas[3].credentials.aws_sm.port
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Returns a field error at the given path
+func Errorf(path string, format string, a ...any) error {
+	return &FieldError{Path: path, Err: fmt.Errorf(format, a...)}
+}
+
+// Joins a path and a path relative to it, e.g schemas and [0].name
+func JoinPath(parent string, child string) string {
+	switch {
+	case parent == "":
+		return child
+	case child == "":
+		return parent
+	case strings.HasPrefix(child, "["):
+		return parent + child
+	default:
+		return parent + "." + child
+	}
+}
+
+// Places every error contained in err at the given path.
+//
+// The paths of field errors are treated as relative to the given path,
+// any other error is placed at the path itself. Joined errors are kept apart
+func AtPath(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := []error{}
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, AtPath(path, e))
+		}
+		return errors.Join(errs...)
+	}
+
+	if fieldErr, ok := err.(*FieldError); ok {
+		return &FieldError{Path: JoinPath(path, fieldErr.Path), Err: fieldErr.Err}
+	}
+
+	return &FieldError{Path: path, Err: err}
+}
+
+// Returns the individual errors of possibly joined errors
+func Errors(err error) []error {
+	if err == nil {
+		return nil
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := []error{}
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, Errors(e)...)
+		}
+		return errs
+	}
+
+	return []error{err}
+}
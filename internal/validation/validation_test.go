@@ -0,0 +1,50 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_JoinPath_JoinsKeysAndIndexes(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("schemas[0].name", JoinPath("schemas", "[0].name"))
+	assert.Equal("credentials.aws_sm", JoinPath("credentials", "aws_sm"))
+	assert.Equal("name", JoinPath("", "name"))
+	assert.Equal("schemas", JoinPath("schemas", ""))
+}
+
+func Test_FieldError_Error_PrefixesPath(t *testing.T) {
+	assert := assert.New(t)
+	assert.EqualError(Errorf("schemas[0].name", "missing %s", "name"), "schemas[0].name: missing name")
+	assert.EqualError(&FieldError{Err: fmt.Errorf("missing name")}, "missing name")
+}
+
+func Test_FieldError_Unwrap_ReturnsCause(t *testing.T) {
+	cause := fmt.Errorf("cause")
+	assert := assert.New(t)
+	assert.ErrorIs(AtPath("a", cause), cause)
+}
+
+func Test_AtPath_PrefixesEveryJoinedError(t *testing.T) {
+	err := AtPath("schemas[3]", errors.Join(
+		Errorf("credentials.aws_sm.port", "missing"),
+		AtPath("flywayArgs[1]", fmt.Errorf("invalid")),
+		fmt.Errorf("plain"),
+	))
+
+	assert := assert.New(t)
+	errs := Errors(err)
+	assert.Len(errs, 3)
+	assert.EqualError(errs[0], "schemas[3].credentials.aws_sm.port: missing")
+	assert.EqualError(errs[1], "schemas[3].flywayArgs[1]: invalid")
+	assert.EqualError(errs[2], "schemas[3]: plain")
+}
+
+func Test_AtPath_ReturnsNilForNil(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(AtPath("a", nil))
+	assert.Nil(Errors(nil))
+}
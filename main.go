@@ -1,86 +1,384 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/sourcehawk/go-flyway/internal/config"
+	cp "github.com/sourcehawk/go-flyway/internal/credentials_provider"
+	fc "github.com/sourcehawk/go-flyway/internal/flyway_config"
+	ml "github.com/sourcehawk/go-flyway/internal/migration_lint"
 	"github.com/sourcehawk/go-flyway/internal/migrator"
-	"gopkg.in/yaml.v3"
+	sp "github.com/sourcehawk/go-flyway/internal/secrets_provider"
+	"github.com/sourcehawk/go-flyway/internal/validation"
 )
 
-// mergeYAML does a deep merge: later keys override earlier ones.
-func mergeYAML(paths []string) (map[string]any, error) {
-	out := make(map[string]any)
-	for _, p := range paths {
-		buf, err := os.ReadFile(p)
-		if err != nil {
-			return nil, err
+// Options selecting and loading the configuration
+type configOptions struct {
+	configs []string
+	env     string
+	// resolve relative paths against the working directory instead of the config file
+	cwdRelativePaths bool
+}
+
+// Registers the flags selecting and loading the configuration
+func configFlags(fs *flag.FlagSet) *configOptions {
+	opts := &configOptions{}
+	fs.Func("config", "Path to a YAML config file (can repeat)", func(s string) error {
+		opts.configs = append(opts.configs, s)
+		return nil
+	})
+	fs.StringVar(&opts.env, "env", os.Getenv("GO_FLYWAY_ENV"), "Environment whose overrides are applied to the config (default $GO_FLYWAY_ENV)")
+	fs.BoolVar(&opts.cwdRelativePaths, "cwd-relative-paths", false, "Resolve relative paths in the config against the working directory instead of the config file that defines them")
+	return opts
+}
+
+// Merges the config files and the overrides of the environment, if any,
+// validates them against the JSON Schema of the configuration, expands their
+// references, resolves relative paths and decodes the migrator. Errors and
+// warnings at a config path are reported with the file, line and column of the value
+func loadMigrator(opts *configOptions, resolver *config.ReferenceResolver) (*config.Document, *migrator.Migrator, error) {
+	if len(opts.configs) == 0 {
+		return nil, nil, fmt.Errorf("you must supply at least one --config")
+	}
+
+	doc, err := config.LoadDocument(opts.configs, migrator.MergeKeys())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.env != "" {
+		if err := doc.SelectEnvironment(opts.env); err != nil {
+			return nil, nil, err
 		}
-		var m map[string]any
-		if err := yaml.Unmarshal(buf, &m); err != nil {
-			return nil, err
+		log.Printf("using environment %s", opts.env)
+	}
+
+	if err := migrator.JSONSchema().Validate(doc.Root); err != nil {
+		return nil, nil, doc.Annotate(err)
+	}
+
+	if err := resolver.Expand(doc.Root); err != nil {
+		return nil, nil, doc.Annotate(err)
+	}
+
+	if !opts.cwdRelativePaths {
+		if err := doc.ResolvePaths(migrator.PathFields()); err != nil {
+			return nil, nil, err
 		}
-		deepMerge(out, m)
 	}
-	return out, nil
+
+	m, err := migrator.DecodeMigrator(doc.Root)
+	if err != nil {
+		return nil, nil, doc.Annotate(err)
+	}
+
+	for _, warning := range validation.Errors(doc.Annotate(m.Warnings())) {
+		log.Printf("warning: %s", warning)
+	}
+
+	return doc, m, nil
 }
 
-func deepMerge(dst, src map[string]any) {
-	for k, v := range src {
-		if vm, ok := v.(map[string]any); ok {
-			if existing, found := dst[k]; found {
-				if existingMap, ok := existing.(map[string]any); ok {
-					deepMerge(existingMap, vm)
-					dst[k] = existingMap
-					continue
-				}
+// Logs the resolved file system paths of the schemas
+func logPaths(m *migrator.Migrator) {
+	for _, dir := range m.SharedLocations {
+		log.Printf("sharedLocations %s", dir)
+	}
+	for _, s := range m.Schemas {
+		if !s.IsEnabled() {
+			continue
+		}
+		if s.MigrationsPath != "" {
+			log.Printf("schema %s: migrationsPath %s", s.Name, s.MigrationsPath)
+		}
+		for _, dir := range s.MigrationsPaths {
+			log.Printf("schema %s: migrationsPaths %s", s.Name, dir)
+		}
+		for _, p := range s.Placeholders {
+			if p.ValueFromFile != "" {
+				log.Printf("schema %s: placeholder %s valueFromFile %s", s.Name, p.Name, p.ValueFromFile)
 			}
-			dst[k] = vm
-		} else {
-			dst[k] = v
 		}
 	}
 }
 
-func main() {
-	var configs []string
-	flag.Func("config", "Path to a YAML config file (can repeat)", func(s string) error {
-		configs = append(configs, s)
+// Prints the JSON Schema of the configuration file
+func printSchema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	output := fs.String("o", "", "Write the schema to this file instead of stdout")
+	fs.Parse(args) //nolint:errcheck
+
+	data, err := json.MarshalIndent(migrator.JSONSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	return os.WriteFile(*output, data, 0644)
+}
+
+// Runs the migration of all schemas
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	opts := configFlags(fs)
+	fs.Parse(args) //nolint:errcheck
+
+	doc, m, err := loadMigrator(opts, config.NewReferenceResolver())
+	if err != nil {
+		return err
+	}
+
+	err = doc.Annotate(m.Migrate())
+
+	stats := sp.DefaultSecretCache.Stats()
+	log.Printf("secret cache: %d hits, %d misses", stats.Hits, stats.Misses)
+
+	return err
+}
+
+// Validates the configuration without migrating.
+//
+// In offline mode, references and credentials are not resolved so that no
+// secret store, environment variable or credentials file is accessed
+func validateConfig(args []string) error {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	opts := configFlags(fs)
+	offline := fs.Bool("offline", false, "Only validate the structure of the config, without resolving references or credentials")
+	fs.Parse(args) //nolint:errcheck
+
+	if !*offline {
+		doc, m, err := loadMigrator(opts, config.NewReferenceResolver())
+		if err != nil {
+			return err
+		}
+		if err := doc.Annotate(m.Validate()); err != nil {
+			return err
+		}
+		logPaths(m)
 		return nil
-	})
-	flag.Parse()
+	}
+
+	resolver := config.NewOfflineReferenceResolver()
+	doc, m, err := loadMigrator(opts, resolver)
+	if err != nil {
+		return err
+	}
+
+	if err := doc.Annotate(m.ValidateConfig(resolver.Unresolved())); err != nil {
+		return err
+	}
+	logPaths(m)
+	return nil
+}
 
-	if len(configs) == 0 {
-		log.Fatal("you must supply at least one --config")
+// Lints the migration files of the schemas without connecting to the database.
+//
+// Like the offline validation, it does not resolve references or credentials.
+// Fails if any migration file has errors
+func lintMigrations(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	opts := configFlags(fs)
+	format := fs.String("format", string(ml.FormatText), "Format of the diagnostics: text, json or github")
+	fs.Parse(args) //nolint:errcheck
+
+	if err := ml.Format(*format).Validate(); err != nil {
+		return err
 	}
 
-	merged, err := mergeYAML(configs)
+	resolver := config.NewOfflineReferenceResolver()
+	doc, m, err := loadMigrator(opts, resolver)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if err := doc.Annotate(m.ValidateConfig(resolver.Unresolved())); err != nil {
+		return err
+	}
+
+	diagnostics := m.Lint(resolver.Unresolved())
+	for i, d := range diagnostics {
+		if d.Path != "" {
+			diagnostics[i].File, diagnostics[i].Line, _ = doc.Locate(d.Path)
+		}
 	}
 
-	tmp, err := os.CreateTemp("", "merged-*.yml")
+	// paths relative to the working directory, e.g the repository root in CI
+	if wd, err := os.Getwd(); err == nil {
+		for i, d := range diagnostics {
+			if rel, err := filepath.Rel(wd, d.File); err == nil && !strings.HasPrefix(rel, "..") {
+				diagnostics[i].File = rel
+			}
+			diagnostics[i].Message = strings.ReplaceAll(d.Message, wd+string(filepath.Separator), "")
+		}
+	}
+
+	if err := ml.Write(os.Stdout, ml.Format(*format), diagnostics); err != nil {
+		return err
+	}
+
+	if errors := ml.Count(diagnostics, ml.Error); errors > 0 {
+		return fmt.Errorf("found %d errors in the migration files", errors)
+	}
+	return nil
+}
+
+// Converts a native flyway.conf or flyway.toml file into a config file
+func importConfig(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	output := fs.String("o", "", "Write the config to this file instead of stdout")
+	fs.Parse(args) //nolint:errcheck
+
+	// flags may follow the file, e.g import flyway.toml -o config.yaml
+	paths := []string{}
+	for fs.NArg() > 0 {
+		paths = append(paths, fs.Arg(0))
+		fs.Parse(fs.Args()[1:]) //nolint:errcheck
+	}
+
+	if len(paths) != 1 {
+		return fmt.Errorf("you must supply exactly one flyway.conf or flyway.toml file to import")
+	}
+	path := paths[0]
+
+	flywayConfig, err := fc.Load(path)
+	if err != nil {
+		return err
+	}
+
+	m, err := migrator.ImportFlywayConfig(flywayConfig)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("%s: %w", path, err)
 	}
-	defer os.Remove(tmp.Name()) //nolint:errcheck
 
-	out, err := yaml.Marshal(merged)
+	data, err := migrator.EncodeMigrator(m)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	if _, err := tmp.Write(out); err != nil {
-		log.Fatal(err)
+	data = append([]byte(fmt.Sprintf("# Imported from %s\n", filepath.Base(path))), data...)
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
 	}
 
-	migrator, err := migrator.NewMigrator(tmp.Name())
+	return os.WriteFile(*output, data, 0644)
+}
+
+// Writes a flyway.toml for every enabled schema of the configuration, to
+// <dir>/<schema>/flyway.toml, or for a single schema to stdout
+func exportConfig(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	opts := configFlags(fs)
+	output := fs.String("o", "", "Directory to write a <schema>/flyway.toml file per schema to")
+	schema := fs.String("schema", "", "Only export this schema, to stdout unless -o is set")
+	withCredentials := fs.Bool("with-credentials", false, "Fetch the credentials and secrets and write them to the files, instead of having flyway read them from FLYWAY_URL, FLYWAY_USER, FLYWAY_PASSWORD and FLYWAY_PLACEHOLDERS_<NAME>")
+	fs.Parse(args) //nolint:errcheck
 
+	if *output == "" && *schema == "" {
+		return fmt.Errorf("you must supply an output directory with -o or a schema with -schema")
+	}
+
+	// without credentials, no secret is fetched and no reference is written to the files
+	resolver := config.NewOfflineReferenceResolver()
+	if *withCredentials {
+		resolver = config.NewReferenceResolver()
+	}
+	doc, m, err := loadMigrator(opts, resolver)
 	if err != nil {
-		log.Fatal(err.Error())
+		return err
 	}
+	if err := doc.Annotate(m.ValidateConfig(resolver.Unresolved())); err != nil {
+		return err
+	}
+	if !*withCredentials {
+		if err := doc.Annotate(m.PrepareExport(resolver.Unresolved())); err != nil {
+			return err
+		}
+	}
+
+	exported := 0
+	for _, s := range m.Schemas {
+		if !s.IsEnabled() || (*schema != "" && s.Name != *schema) {
+			continue
+		}
+		exported++
+
+		var creds *cp.DatabaseCredentials
+		if *withCredentials {
+			if creds, err = s.Credentials.FetchCredentials(); err != nil {
+				return fmt.Errorf("schema %s: %w", s.Name, err)
+			}
+		}
+
+		data, err := s.ExportFlywayToml(creds)
+		if err != nil {
+			return fmt.Errorf("schema %s: %w", s.Name, err)
+		}
+
+		if *output == "" {
+			if _, err := os.Stdout.WriteString(data); err != nil {
+				return err
+			}
+			continue
+		}
 
-	err = migrator.Migrate()
+		dir := filepath.Join(*output, s.Name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		// the file may hold credentials
+		path := filepath.Join(dir, "flyway.toml")
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			return err
+		}
+		log.Printf("schema %s: exported %s", s.Name, path)
+	}
+
+	if *schema != "" && exported == 0 {
+		return fmt.Errorf("schema %s is not defined or not enabled", *schema)
+	}
+	return nil
+}
+
+func main() {
+	args := os.Args[1:]
+	command := "migrate"
+
+	// the migrate command is the default for backwards compatibility
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+
+	switch command {
+	case "migrate":
+		err = migrate(args)
+	case "schema":
+		err = printSchema(args)
+	case "lint":
+		err = lintMigrations(args)
+	case "import":
+		err = importConfig(args)
+	case "export":
+		err = exportConfig(args)
+	case "validate-config":
+		if err = validateConfig(args); err == nil {
+			log.Print("config is valid")
+		}
+	default:
+		log.Fatalf("unknown command %s, expected one of: migrate, validate-config, lint, schema, import, export", command)
+	}
 
 	if err != nil {
 		log.Fatal(err.Error())
@@ -1,4 +1,4 @@
--- sql/afterMigrate.sql
+-- callbacks/afterMigrate.sql
 -- Verify that the placeholder test inserted the correct, static values
 
 DO $$